@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/handlers"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/mailer"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"go.uber.org/zap"
+)
+
+// envOrDefault returns the value of an environment variable, or def when it is not set.
+func envOrDefault(key string, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// envDuration parses an environment variable such as "15m" or "24h", or returns def when it is not set.
+func envDuration(logger *zap.Logger, key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Fatal("Invalid duration in environment variable", zap.String("env_variable", key), zap.Error(err))
+	}
+	return d
+}
+
+// envBool reads a boolean from the environment, falling back to def when the variable is unset.
+func envBool(logger *zap.Logger, key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Fatal("Invalid boolean in environment variable", zap.String("env_variable", key), zap.Error(err))
+	}
+	return b
+}
+
+// envInt reads an integer from the environment, falling back to def when the variable is unset.
+func envInt(logger *zap.Logger, key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Fatal("Invalid integer in environment variable", zap.String("env_variable", key), zap.Error(err))
+	}
+	return i
+}
+
+// newRepository opens the storage backend selected by STORAGE_BACKEND: bunt (default), sqlite or postgres.
+// BuntDB stores its data in LOCAL_DB_PATH, the SQL backends connect to DATABASE_URL.
+func newRepository() (local.Repository, error) {
+	switch backend := envOrDefault("STORAGE_BACKEND", "bunt"); backend {
+	case "bunt":
+		return local.NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
+	case "sqlite", "postgres":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for the %s backend", backend)
+		}
+		return local.NewSQLRepository(backend, dsn)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// newLoginThrottle reads the failed login limits from the environment, using the defaults for unset variables.
+func newLoginThrottle(logger *zap.Logger) handlers.LoginThrottle {
+	throttle := handlers.DefaultLoginThrottle
+	throttle.FreeAttempts = envInt(logger, "LOGIN_FREE_ATTEMPTS", throttle.FreeAttempts)
+	throttle.LockoutThreshold = envInt(logger, "LOGIN_LOCKOUT_THRESHOLD", throttle.LockoutThreshold)
+	throttle.IPThreshold = envInt(logger, "LOGIN_IP_THRESHOLD", throttle.IPThreshold)
+	throttle.LockoutDuration = envDuration(logger, "LOGIN_LOCKOUT_DURATION", throttle.LockoutDuration)
+	throttle.Window = envDuration(logger, "LOGIN_FAILURE_WINDOW", throttle.Window)
+	return throttle
+}
+
+// newPasswordHasher builds the password hasher from the environment. PASSWORD_HASH_ALGORITHM selects the algorithm
+// new hashes use, hashes of the other algorithms keep verifying and are upgraded on the next login.
+func newPasswordHasher(logger *zap.Logger) (*password.Hasher, error) {
+	argon := password.DefaultArgon2id
+	argon.Memory = uint32(envInt(logger, "ARGON2_MEMORY_KIB", int(argon.Memory)))
+	argon.Iterations = uint32(envInt(logger, "ARGON2_ITERATIONS", int(argon.Iterations)))
+	argon.Parallelism = uint8(envInt(logger, "ARGON2_PARALLELISM", int(argon.Parallelism)))
+
+	scrypt := password.DefaultScrypt
+	scrypt.LogN = uint8(envInt(logger, "SCRYPT_LOG_N", int(scrypt.LogN)))
+
+	bcrypt := password.DefaultBcrypt
+	bcrypt.Cost = envInt(logger, "BCRYPT_COST", bcrypt.Cost)
+
+	switch algorithm := envOrDefault("PASSWORD_HASH_ALGORITHM", "argon2id"); algorithm {
+	case "argon2id":
+		return password.NewHasher(argon, scrypt, bcrypt), nil
+	case "scrypt":
+		return password.NewHasher(scrypt, argon, bcrypt), nil
+	case "bcrypt":
+		return password.NewHasher(bcrypt, argon, scrypt), nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASH_ALGORITHM %q", algorithm)
+	}
+}
+
+// newPasswordPolicy reads the password rules from the environment, using the defaults for unset variables.
+// BREACHED_PASSWORDS_DIR points at a directory of breached password range files in the Have I Been Pwned format.
+func newPasswordPolicy(logger *zap.Logger) *password.Policy {
+	policy := password.DefaultPolicy
+	policy.MinLength = envInt(logger, "PASSWORD_MIN_LENGTH", policy.MinLength)
+	policy.MaxLength = envInt(logger, "PASSWORD_MAX_LENGTH", policy.MaxLength)
+	policy.RequireUppercase = envBool(logger, "PASSWORD_REQUIRE_UPPERCASE", policy.RequireUppercase)
+	policy.RequireLowercase = envBool(logger, "PASSWORD_REQUIRE_LOWERCASE", policy.RequireLowercase)
+	policy.RequireDigit = envBool(logger, "PASSWORD_REQUIRE_DIGIT", policy.RequireDigit)
+	policy.RequireSymbol = envBool(logger, "PASSWORD_REQUIRE_SYMBOL", policy.RequireSymbol)
+	policy.ForbidUserInfo = envBool(logger, "PASSWORD_FORBID_USER_INFO", policy.ForbidUserInfo)
+
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			logger.Fatal("Breached password directory not found", zap.String("env_variable", "BREACHED_PASSWORDS_DIR"), zap.String("dir", dir))
+		}
+		policy.Breached = password.RangeDirectory{Dir: dir, MinCount: envInt(logger, "BREACHED_PASSWORDS_MIN_COUNT", 1)}
+	}
+	return &policy
+}
+
+// newRoles loads the role definitions from the JSON file named by ROLES_FILE, or returns model.DefaultRoles when it is not set.
+// The file holds an array such as [{"name": "user", "permissions": ["users:read"]}].
+func newRoles(logger *zap.Logger) ([]model.Role, error) {
+	path := os.Getenv("ROLES_FILE")
+	if path == "" {
+		return append([]model.Role(nil), model.DefaultRoles...), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var roles []model.Role
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, fmt.Errorf("invalid roles file: %w", err)
+	}
+	logger.Info("Loaded role definitions", zap.String("file", path), zap.Int("roles", len(roles)))
+	return roles, nil
+}
+
+// definesRole reports whether a role of the given name is among the definitions.
+func definesRole(roles []model.Role, name string) bool {
+	for _, role := range roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newMailer creates the mailer selected by the MAILER environment variable: smtp, file or log (default).
+func newMailer(logger *zap.Logger) (mailer.Mailer, error) {
+	from := envOrDefault("MAIL_FROM", "no-reply@localhost")
+	switch kind := envOrDefault("MAILER", "log"); kind {
+	case "smtp":
+		return mailer.NewSMTPMailer(envOrDefault("SMTP_ADDR", "localhost:1025"), from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case "file":
+		return mailer.NewFileMailer(envOrDefault("MAIL_DIR", "./mail"), from)
+	case "log":
+		return mailer.NewLogMailer(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
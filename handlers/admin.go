@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/services"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/token"
+	"go.uber.org/zap"
+)
+
+type admin struct {
+	log    *zap.Logger      // Logger for logging events
+	repo   local.Repository // Repository interface for database operations
+	config *AppConfig       // Application configuration, including the JWT keyring
+	roles  services.RoleService
+	errors middleware.AppError
+}
+
+// NewAdmin initializes the handler for operations only administrators may perform.
+func NewAdmin(log *zap.Logger, repo local.Repository, config *AppConfig, roles services.RoleService, errors middleware.AppError) Handler {
+	return &admin{
+		log:    log,
+		repo:   repo,
+		config: config,
+		roles:  roles,
+		errors: errors,
+	}
+}
+
+// AssignEndpoints sets up the administration routes, all of which require the users:manage permission.
+func (handler *admin) AssignEndpoints(prefix string, router fiber.Router) {
+	r := router.Group(prefix, middleware.JWTAuthMiddleware(handler.config.Keyring, handler.repo), middleware.RequirePermission(handler.roles, model.PermissionUsersManage))
+
+	// User management routes
+	r.Get("users", handler.listUsersEndpoint)                                 // GET /admin/users: Lists all users with their full details.
+	r.Put("users/:id/role", handler.changeRoleEndpoint)                       // PUT /admin/users/:id/role: Changes the role of a user.
+	r.Post("users/:id/suspend", handler.suspendEndpoint)                      // POST /admin/users/:id/suspend: Suspends an account and signs it out.
+	r.Post("users/:id/unsuspend", handler.unsuspendEndpoint)                  // POST /admin/users/:id/unsuspend: Lifts a suspension.
+	r.Post("users/:id/logout", handler.forceLogoutEndpoint)                   // POST /admin/users/:id/logout: Signs a user out on every device.
+	r.Post("users/:id/temporary-password", handler.temporaryPasswordEndpoint) // POST /admin/users/:id/temporary-password: Replaces the password with a temporary one.
+	r.Get("users/:id/logins", handler.loginHistoryEndpoint)                   // GET /admin/users/:id/logins: Lists recent login attempts of a user.
+
+	// Deleted user routes
+	r.Get("users/deleted", handler.listDeletedUsersEndpoint) // GET /admin/users/deleted: Lists deleted users that are not purged yet.
+	r.Post("users/:id/restore", handler.restoreUserEndpoint) // POST /admin/users/:id/restore: Restores a user deleted within the restore window.
+
+	// Account lockout routes
+	r.Post("users/:id/unlock", handler.unlockEndpoint) // POST /admin/users/:id/unlock: Lifts a lockout after too many failed logins.
+}
+
+// listUsersEndpoint returns every user with the details only administrators may see.
+func (handler *admin) listUsersEndpoint(ctx *fiber.Ctx) error {
+	users, err := handler.repo.FindAll()
+	if err != nil {
+		handler.log.Error("Failed to list users", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to list users") // Return 500 if the users cannot be read
+	}
+
+	response := make([]model.AdminUserResponse, len(users))
+	for i, user := range users {
+		response[i] = ToAdminUserResponse(user)
+	}
+	return ctx.JSON(response)
+}
+
+// changeRoleEndpoint assigns another defined role to a user. The user is signed out everywhere,
+// because the role travels in access tokens that would otherwise keep the old permissions.
+func (handler *admin) changeRoleEndpoint(ctx *fiber.Ctx) error {
+	type changeRoleRequest struct {
+		Role string `json:"role"` // Name of a defined role
+	}
+
+	userID := ctx.Params("id")
+	adminID, _ := ctx.Locals("user_id").(string)
+
+	var req changeRoleRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+	if req.Role == "" {
+		return handler.errors.NewBadRequest("Role is required") // Return 400 if no role is provided
+	}
+	if userID == adminID {
+		return handler.errors.NewBadRequest("Administrators cannot change their own role") // Return 400 so nobody locks themselves out
+	}
+	if _, err := handler.roles.FindRole(req.Role); err != nil {
+		return handler.errors.NewBadRequest("Unknown role") // Return 400 if the role is not defined
+	}
+
+	user, err := handler.repo.ModifyOneByID(userID, func(user *model.User) error {
+		user.Role = req.Role
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the user does not exist, 500 if it cannot be read
+	}
+
+	if err := endAllSessions(handler.repo, userID); err != nil {
+		handler.log.Error("Failed to revoke sessions after role change", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to revoke sessions") // Return 500 if sessions cannot be revoked
+	}
+
+	handler.log.Info("User role changed", zap.String("userID", userID), zap.String("role", req.Role), zap.String("adminID", adminID))
+	return ctx.JSON(ToAdminUserResponse(user))
+}
+
+// suspendEndpoint suspends an account and signs it out on every device.
+func (handler *admin) suspendEndpoint(ctx *fiber.Ctx) error {
+	userID := ctx.Params("id")
+	adminID, _ := ctx.Locals("user_id").(string)
+
+	if userID == adminID {
+		return handler.errors.NewBadRequest("Administrators cannot suspend themselves") // Return 400 so nobody locks themselves out
+	}
+
+	user, err := handler.repo.ModifyOneByID(userID, func(user *model.User) error {
+		user.Suspended = true
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the user does not exist, 500 if it cannot be read
+	}
+
+	if err := endAllSessions(handler.repo, userID); err != nil {
+		handler.log.Error("Failed to revoke sessions of suspended user", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to revoke sessions") // Return 500 if sessions cannot be revoked
+	}
+
+	handler.log.Info("User suspended", zap.String("userID", userID), zap.String("adminID", adminID))
+	return ctx.JSON(ToAdminUserResponse(user))
+}
+
+// unsuspendEndpoint lifts the suspension of an account.
+func (handler *admin) unsuspendEndpoint(ctx *fiber.Ctx) error {
+	userID := ctx.Params("id")
+	adminID, _ := ctx.Locals("user_id").(string)
+
+	user, err := handler.repo.ModifyOneByID(userID, func(user *model.User) error {
+		user.Suspended = false
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the user does not exist, 500 if it cannot be read
+	}
+
+	handler.log.Info("User unsuspended", zap.String("userID", userID), zap.String("adminID", adminID))
+	return ctx.JSON(ToAdminUserResponse(user))
+}
+
+// forceLogoutEndpoint ends every session of a user, including outstanding access tokens.
+func (handler *admin) forceLogoutEndpoint(ctx *fiber.Ctx) error {
+	userID := ctx.Params("id")
+	adminID, _ := ctx.Locals("user_id").(string)
+
+	if _, err := handler.repo.FindOneByID(userID); err != nil {
+		handler.log.Error("User not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the user does not exist, 500 if it cannot be read
+	}
+
+	if err := endAllSessions(handler.repo, userID); err != nil {
+		handler.log.Error("Failed to revoke sessions", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to revoke sessions") // Return 500 if sessions cannot be revoked
+	}
+
+	handler.log.Info("User signed out by administrator", zap.String("userID", userID), zap.String("adminID", adminID))
+	return ctx.JSON(fiber.Map{
+		"message": "User logged out from all sessions",
+		"user_id": userID,
+	})
+}
+
+// temporaryPasswordEndpoint replaces the password of a user with a random temporary one and signs them out.
+// The temporary password is returned once, and the user is asked to change it at the next login.
+func (handler *admin) temporaryPasswordEndpoint(ctx *fiber.Ctx) error {
+	userID := ctx.Params("id")
+	adminID, _ := ctx.Locals("user_id").(string)
+
+	temporary, err := token.Generate()
+	if err != nil {
+		handler.log.Error("Failed to generate temporary password", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to generate temporary password") // Return 500 if no randomness is available
+	}
+	hashed, err := password.HashPassword(temporary)
+	if err != nil {
+		handler.log.Error("Failed to hash temporary password", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to hash temporary password") // Return 500 if hashing fails
+	}
+
+	_, err = handler.repo.ModifyOneByID(userID, func(user *model.User) error {
+		user.Password = hashed
+		user.PasswordChangeRequired = true
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the user does not exist, 500 if it cannot be read
+	}
+
+	if err := endAllSessions(handler.repo, userID); err != nil {
+		handler.log.Error("Failed to revoke sessions after password change", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to revoke sessions") // Return 500 if sessions cannot be revoked
+	}
+
+	handler.log.Info("Temporary password set", zap.String("userID", userID), zap.String("adminID", adminID))
+	return ctx.JSON(fiber.Map{
+		"message":            "Temporary password set, the user has to change it after signing in",
+		"user_id":            userID,
+		"temporary_password": temporary,
+	})
+}
+
+// loginHistoryEndpoint lists the most recent login attempts of a user, newest first.
+func (handler *admin) loginHistoryEndpoint(ctx *fiber.Ctx) error {
+	userID := ctx.Params("id")
+
+	limit := ctx.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		return handler.errors.NewBadRequest("Limit must be between 1 and 500") // Return 400 if the limit is out of range
+	}
+
+	if _, err := handler.repo.FindOneByID(userID); err != nil {
+		handler.log.Error("User not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the user does not exist, 500 if it cannot be read
+	}
+
+	events, err := handler.repo.FindLoginEvents(userID, limit)
+	if err != nil {
+		handler.log.Error("Failed to read login history", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to read login history") // Return 500 if the history cannot be read
+	}
+	if events == nil {
+		events = []*model.LoginEvent{}
+	}
+	return ctx.JSON(events)
+}
+
+// listDeletedUsersEndpoint returns the deleted users that were not purged yet, including those whose
+// restore window has already passed.
+func (handler *admin) listDeletedUsersEndpoint(ctx *fiber.Ctx) error {
+	users, err := handler.repo.FindDeletedUsers()
+	if err != nil {
+		handler.log.Error("Failed to list deleted users", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to list deleted users") // Return 500 if the users cannot be read
+	}
+
+	response := make([]model.AdminUserResponse, len(users))
+	for i, user := range users {
+		response[i] = ToAdminUserResponse(user)
+	}
+	return ctx.JSON(response)
+}
+
+// restoreUserEndpoint brings back a user deleted within the restore window. The user signs in again,
+// because their sessions ended with the deletion.
+func (handler *admin) restoreUserEndpoint(ctx *fiber.Ctx) error {
+	userID := ctx.Params("id")
+	adminID, _ := ctx.Locals("user_id").(string)
+
+	user, err := handler.repo.RestoreOneByID(userID, time.Now().Add(-handler.config.UserRestoreWindow))
+	if err != nil {
+		handler.log.Error("Deleted user not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "No user was deleted with this ID within the restore window", "Failed to restore user") // Return 404 if there is nothing to restore, 500 if it cannot be read
+	}
+
+	handler.log.Info("User restored", zap.String("userID", userID), zap.String("adminID", adminID))
+	return ctx.JSON(ToAdminUserResponse(user))
+}
+
+// unlockEndpoint lifts the lockout of an account and forgets its failed logins.
+func (handler *admin) unlockEndpoint(ctx *fiber.Ctx) error {
+	userID := ctx.Params("id")
+
+	user, err := handler.repo.ModifyOneByID(userID, func(user *model.User) error {
+		user.LockedUntil = time.Time{}
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err), zap.String("userID", userID))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the user does not exist, 500 if it cannot be read
+	}
+
+	if err := handler.repo.ResetLoginAttempts(accountThrottleKey(user.Email)); err != nil {
+		handler.log.Error("Failed to reset login attempts", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to unlock user") // Return 500 if the counter cannot be reset
+	}
+
+	adminID, _ := ctx.Locals("user_id").(string)
+	handler.log.Info("User unlocked", zap.String("userID", userID), zap.String("adminID", adminID))
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User unlocked successfully",
+		"user_id": userID,
+	})
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+func TestAdminAuthorization(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+
+	// Every administration endpoint requires the users:manage permission
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{fiber.MethodGet, "/admin/users"},
+		{fiber.MethodPut, "/admin/users/" + john.id + "/role"},
+		{fiber.MethodPost, "/admin/users/" + john.id + "/suspend"},
+		{fiber.MethodPost, "/admin/users/" + john.id + "/unsuspend"},
+		{fiber.MethodPost, "/admin/users/" + john.id + "/logout"},
+		{fiber.MethodPost, "/admin/users/" + john.id + "/temporary-password"},
+		{fiber.MethodGet, "/admin/users/" + john.id + "/logins"},
+		{fiber.MethodGet, "/admin/users/deleted"},
+		{fiber.MethodPost, "/admin/users/" + john.id + "/restore"},
+		{fiber.MethodPost, "/admin/users/" + john.id + "/unlock"},
+	}
+	for _, endpoint := range endpoints {
+		t.Run(endpoint.method+" "+endpoint.path, func(t *testing.T) {
+			s.request(t, endpoint.method, endpoint.path, "", nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenMissing)
+			s.request(t, endpoint.method, endpoint.path, john.accessToken, nil).expect(t, fiber.StatusForbidden, "")
+		})
+	}
+}
+
+func TestListUsersEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.register(t, "john")
+	admin := s.registerAdmin(t, "admin")
+
+	resp := s.request(t, fiber.MethodGet, "/admin/users", admin.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	if users := resp.body.Array(); len(users) != 2 || !users[0].Get("role").Exists() {
+		t.Fatalf("GET /admin/users = %s, want both users with their roles", resp.body.Raw)
+	}
+
+	s.repo.FailCalls("FindAll", errStorage)
+	s.request(t, fiber.MethodGet, "/admin/users", admin.accessToken, nil).expect(t, fiber.StatusInternalServerError, "")
+}
+
+func TestChangeRoleEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	admin := s.registerAdmin(t, "admin")
+
+	testCases := []struct {
+		name       string
+		userID     string
+		role       string
+		fail       string
+		wantStatus int
+	}{
+		{name: "Missing Role", userID: john.id, role: "", wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown Role", userID: john.id, role: "superuser", wantStatus: fiber.StatusBadRequest},
+		{name: "Own Role", userID: admin.id, role: model.DefaultRole, wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown User", userID: "unknown", role: model.AdminRole, wantStatus: fiber.StatusNotFound},
+		{name: "Failed Update", userID: john.id, role: model.AdminRole, fail: "ModifyOneByID", wantStatus: fiber.StatusInternalServerError},
+		{name: "Defined Role", userID: john.id, role: model.AdminRole, wantStatus: fiber.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodPut, "/admin/users/"+tc.userID+"/role", admin.accessToken, fiber.Map{"role": tc.role})
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusOK && resp.body.Get("role").String() != tc.role {
+				t.Fatalf("role = %s, want %s", resp.body.Get("role").String(), tc.role)
+			}
+		})
+	}
+
+	// The old token carries the old role, so it is revoked
+	s.request(t, fiber.MethodGet, "/user/"+john.id, john.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+}
+
+func TestSuspendEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	admin := s.registerAdmin(t, "admin")
+
+	testCases := []struct {
+		name       string
+		action     string
+		userID     string
+		wantStatus int
+	}{
+		{name: "Suspend Self", action: "suspend", userID: admin.id, wantStatus: fiber.StatusBadRequest},
+		{name: "Suspend Unknown User", action: "suspend", userID: "unknown", wantStatus: fiber.StatusNotFound},
+		{name: "Unsuspend Unknown User", action: "unsuspend", userID: "unknown", wantStatus: fiber.StatusNotFound},
+		{name: "Suspend User", action: "suspend", userID: john.id, wantStatus: fiber.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.request(t, fiber.MethodPost, "/admin/users/"+tc.userID+"/"+tc.action, admin.accessToken, nil).expect(t, tc.wantStatus, "")
+		})
+	}
+
+	// A suspended user is signed out and cannot sign in until the suspension is lifted
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusForbidden, middleware.CodeAccountSuspended)
+	s.request(t, fiber.MethodPost, "/admin/users/"+john.id+"/unsuspend", admin.accessToken, nil).expect(t, fiber.StatusOK, "")
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusOK, "")
+}
+
+func TestForceLogoutEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	admin := s.registerAdmin(t, "admin")
+
+	s.request(t, fiber.MethodPost, "/admin/users/unknown/logout", admin.accessToken, nil).expect(t, fiber.StatusNotFound, "")
+	s.repo.FailCalls("FindSessionsByUserID", errStorage)
+	s.request(t, fiber.MethodPost, "/admin/users/"+john.id+"/logout", admin.accessToken, nil).expect(t, fiber.StatusInternalServerError, "")
+	s.repo.ResetFaults()
+
+	s.request(t, fiber.MethodPost, "/admin/users/"+john.id+"/logout", admin.accessToken, nil).expect(t, fiber.StatusOK, "")
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+	s.request(t, fiber.MethodPost, "/auth/refresh", "", fiber.Map{"identifier": john.email, "refresh_token": john.refreshToken}).expect(t, fiber.StatusUnauthorized, "")
+}
+
+func TestTemporaryPasswordEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	admin := s.registerAdmin(t, "admin")
+
+	s.request(t, fiber.MethodPost, "/admin/users/unknown/temporary-password", admin.accessToken, nil).expect(t, fiber.StatusNotFound, "")
+
+	resp := s.request(t, fiber.MethodPost, "/admin/users/"+john.id+"/temporary-password", admin.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	temporary := resp.body.Get("temporary_password").String()
+
+	// Only the temporary password works, and the user is asked to change it
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+	s.login(t, john.email, temporary).expect(t, fiber.StatusOK, "")
+	user, err := s.repo.FindOneByID(john.id)
+	if err != nil || !user.PasswordChangeRequired {
+		t.Fatalf("FindOneByID() = %v, %v, want a required password change", user, err)
+	}
+}
+
+func TestLoginHistoryEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	admin := s.registerAdmin(t, "admin")
+	s.login(t, john.email, "wrong password").expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusOK, "")
+
+	testCases := []struct {
+		name       string
+		path       string
+		fail       string
+		wantStatus int
+		wantEvents int
+	}{
+		{name: "All Events", path: "/admin/users/" + john.id + "/logins", wantStatus: fiber.StatusOK, wantEvents: 2},
+		{name: "Limited", path: "/admin/users/" + john.id + "/logins?limit=1", wantStatus: fiber.StatusOK, wantEvents: 1},
+		{name: "Invalid Limit", path: "/admin/users/" + john.id + "/logins?limit=0", wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown User", path: "/admin/users/unknown/logins", wantStatus: fiber.StatusNotFound},
+		{name: "Failed Query", path: "/admin/users/" + john.id + "/logins", fail: "FindLoginEvents", wantStatus: fiber.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodGet, tc.path, admin.accessToken, nil)
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusOK && len(resp.body.Array()) != tc.wantEvents {
+				t.Fatalf("GET %s = %s, want %d events", tc.path, resp.body.Raw, tc.wantEvents)
+			}
+		})
+	}
+}
+
+func TestRestoreUserEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	jane := s.register(t, "jane")
+	admin := s.registerAdmin(t, "admin")
+	s.request(t, fiber.MethodDelete, "/user/"+john.id, john.accessToken, nil).expect(t, fiber.StatusOK, "")
+
+	resp := s.request(t, fiber.MethodGet, "/admin/users/deleted", admin.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	if deleted := resp.body.Array(); len(deleted) != 1 || deleted[0].Get("id").String() != john.id {
+		t.Fatalf("GET /admin/users/deleted = %s, want only john", resp.body.Raw)
+	}
+
+	testCases := []struct {
+		name       string
+		userID     string
+		fail       string
+		wantStatus int
+	}{
+		{name: "Active User", userID: jane.id, wantStatus: fiber.StatusNotFound},
+		{name: "Failed Restore", userID: john.id, fail: "RestoreOneByID", wantStatus: fiber.StatusInternalServerError},
+		{name: "Deleted User", userID: john.id, wantStatus: fiber.StatusOK},
+		{name: "Restored User", userID: john.id, wantStatus: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			s.request(t, fiber.MethodPost, "/admin/users/"+tc.userID+"/restore", admin.accessToken, nil).expect(t, tc.wantStatus, "")
+		})
+	}
+
+	// The restored user signs in again, users deleted before the restore window cannot be restored
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusOK, "")
+	s.config.UserRestoreWindow = time.Nanosecond
+	s.request(t, fiber.MethodDelete, "/user/"+jane.id, jane.accessToken, nil).expect(t, fiber.StatusOK, "")
+	time.Sleep(time.Millisecond)
+	s.request(t, fiber.MethodPost, "/admin/users/"+jane.id+"/restore", admin.accessToken, nil).expect(t, fiber.StatusNotFound, "")
+
+	s.repo.FailCalls("FindDeletedUsers", errStorage)
+	s.request(t, fiber.MethodGet, "/admin/users/deleted", admin.accessToken, nil).expect(t, fiber.StatusInternalServerError, "")
+}
+
+func TestUnlockEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.config.LoginThrottle.FreeAttempts = 1
+	s.config.LoginThrottle.LockoutThreshold = 2
+	john := s.register(t, "john")
+	admin := s.registerAdmin(t, "admin")
+
+	s.login(t, john.email, "wrong password").expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+	s.login(t, john.email, "wrong password").expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusTooManyRequests, middleware.CodeLoginThrottled)
+
+	s.request(t, fiber.MethodPost, "/admin/users/unknown/unlock", admin.accessToken, nil).expect(t, fiber.StatusNotFound, "")
+	s.repo.FailCalls("ResetLoginAttempts", errStorage)
+	s.request(t, fiber.MethodPost, "/admin/users/"+john.id+"/unlock", admin.accessToken, nil).expect(t, fiber.StatusInternalServerError, "")
+	s.repo.ResetFaults()
+
+	s.request(t, fiber.MethodPost, "/admin/users/"+john.id+"/unlock", admin.accessToken, nil).expect(t, fiber.StatusOK, "")
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusOK, "")
+}
@@ -1,51 +1,107 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/mailer"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/services"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/token"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/validator"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"strings"
+	"time"
 )
 
-// AppConfig contains the JWT secret and other global configurations
+// AppConfig contains the JWT keyring and other global configurations
 type AppConfig struct {
-	JWTSecret []byte // JWT secret used for signing tokens
+	Keyring               *jwt.Keyring  // Keys used for signing and verifying tokens
+	PublicURL             string        // Base URL of the frontend, used for links in emails
+	PasswordResetTTL      time.Duration // Lifetime of password reset tokens
+	EmailVerificationTTL  time.Duration // Lifetime of email verification tokens
+	RequireVerifiedEmail  bool          // Refuse login and refresh until the email address is verified
+	MFAIssuer             string        // Issuer shown in authenticator apps
+	LoginThrottle         LoginThrottle // Delays and lockout after failed logins
+	DefaultRole           string        // Role assigned to newly registered users
+	LoginHistoryRetention time.Duration // How long login attempts are kept in the login history
+	UserRestoreWindow     time.Duration // How long deleted users can be restored before they are purged
+}
+
+// link builds a frontend link carrying a token, or returns the bare token when no public URL is configured.
+func (config *AppConfig) link(path string, token string) string {
+	if config.PublicURL == "" {
+		return token
+	}
+	return strings.TrimSuffix(config.PublicURL, "/") + path + "?token=" + url.QueryEscape(token)
 }
 
 type Auth struct {
 	log      *zap.Logger        // Logger for logging events
 	repo     local.Repository   // Repository interface for database operations
 	validate validator.Validate // Validator for input validation
-	config   *AppConfig         // Application configuration, including JWT secret
+	config   *AppConfig         // Application configuration, including the JWT keyring
 	errors   middleware.AppError
+	mailer   mailer.Mailer // Mailer for password reset and verification emails
+	users    *user         // User handler whose registration flow backs /auth/register
 }
 
 // NewAuth initializes a new Auth handler with its dependencies.
-func NewAuth(log *zap.Logger, repo local.Repository, validate validator.Validate, config *AppConfig, errors middleware.AppError) Handler {
+func NewAuth(log *zap.Logger, repo local.Repository, validate validator.Validate, config *AppConfig, userService services.UserService, roles services.RoleService, mailer mailer.Mailer, errors middleware.AppError) Handler {
 	return &Auth{
 		log:      log,
 		repo:     repo,
 		validate: validate,
 		config:   config,
 		errors:   errors,
+		mailer:   mailer,
+		users:    NewUser(log, repo, validate, config, userService, roles, mailer, errors).(*user),
 	}
 }
 
-// AssignEndpoints sets up the routes for login, logout, and token refresh.
+// AssignEndpoints sets up the routes for registration, login, logout, and token refresh.
 func (handler *Auth) AssignEndpoints(prefix string, router fiber.Router) {
 	r := router.Group(prefix)
 
+	// Route for user registration, shares its implementation with POST /user/create
+	r.Post("register", handler.users.createEndpoint) // POST /auth/register: Creates a new user and signs it in.
+
 	// Route for user login
-	r.Post("login", handler.loginEndpoint) // POST /auth/login: Authenticates user and returns tokens.
+	r.Post("login", handler.loginEndpoint)        // POST /auth/login: Authenticates user and returns tokens or an MFA challenge.
+	r.Post("login/mfa", handler.loginMFAEndpoint) // POST /auth/login/mfa: Completes a login with a second factor.
 
 	// Route for user logout
 	r.Post("logout", handler.logoutEndpoint) // POST /auth/logout: Logs the user out by invalidating their refresh token.
 
 	// Route for refreshing tokens
 	r.Post("refresh", handler.refreshTokenEndpoint) // POST /auth/refresh: Generates new access and refresh tokens.
+
+	// Routes for password recovery
+	r.Post("password/forgot", handler.forgotPasswordEndpoint) // POST /auth/password/forgot: Emails a password reset token.
+	r.Post("password/reset", handler.resetPasswordEndpoint)   // POST /auth/password/reset: Sets a new password using a reset token.
+
+	// Route for email verification
+	r.Post("email/verify", handler.verifyEmailEndpoint) // POST /auth/email/verify: Confirms an email address using a verification token.
+
+	// Routes that require JWT authentication
+	protectedRoutes := r.Group("/", middleware.JWTAuthMiddleware(handler.config.Keyring, handler.repo))
+
+	// Session management routes
+	protectedRoutes.Get("sessions", handler.listSessionsEndpoint)         // GET /auth/sessions: Lists the devices the user is signed in on.
+	protectedRoutes.Delete("sessions/:id", handler.deleteSessionEndpoint) // DELETE /auth/sessions/:id: Signs out a single device.
+
+	// Email verification routes
+	protectedRoutes.Post("email/resend", handler.resendVerificationEndpoint) // POST /auth/email/resend: Sends a new verification email.
+
+	// Two-factor authentication routes
+	protectedRoutes.Post("mfa/enroll", handler.enrollMFAEndpoint)                       // POST /auth/mfa/enroll: Generates a TOTP secret to confirm.
+	protectedRoutes.Post("mfa/confirm", handler.confirmMFAEndpoint)                     // POST /auth/mfa/confirm: Enables MFA and returns recovery codes.
+	protectedRoutes.Post("mfa/disable", handler.disableMFAEndpoint)                     // POST /auth/mfa/disable: Disables MFA.
+	protectedRoutes.Post("mfa/recovery-codes", handler.regenerateRecoveryCodesEndpoint) // POST /auth/mfa/recovery-codes: Replaces the recovery codes.
 }
 
 // loginEndpoint handles the login process by validating the user, checking credentials, and generating tokens.
@@ -56,6 +112,7 @@ func (handler *Auth) loginEndpoint(ctx *fiber.Ctx) error {
 	type loginRequest struct {
 		Email    string `json:"email"`    // User's email address
 		Password string `json:"password"` // User's password
+		Device   string `json:"device"`   // Optional human readable device name, e.g. "work laptop"
 	}
 	var req loginRequest
 
@@ -73,45 +130,98 @@ func (handler *Auth) loginEndpoint(ctx *fiber.Ctx) error {
 
 	// Fetch the user from the repository using their email
 	user, err := handler.repo.FindOneByEmail(req.Email)
-	if err != nil {
+	if errors.Is(err, local.ErrNotFound) {
+		user = nil // Unknown accounts are throttled like known ones
+	} else if err != nil {
+		handler.log.Error("Failed to find user by email", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to find user") // Return 500 if the account cannot be read
+	}
+
+	// Refuse the attempt while the account or the client IP is delayed or locked
+	if err := handler.checkLoginThrottle(ctx, req.Email, user); err != nil {
+		if user != nil {
+			handler.recordLoginEvent(ctx, user.ID, req.Device, model.LoginThrottled)
+		}
+		return err
+	}
+
+	if user == nil {
 		handler.log.Error("failed to find user by email", zap.Error(err))
-		return handler.errors.NewUnauthorized("Invalid username or password") // This could be improved to return 404 if user not found
+		handler.recordLoginFailure(ctx, req.Email, nil)
+		return handler.errors.NewUnauthorized("Invalid username or password").WithCode(middleware.CodeInvalidCredentials) // Return 401 without revealing that the account does not exist
 	}
 
 	// Compare the provided password with the hashed password from the database
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	match, needsRehash, err := password.VerifyPassword(req.Password, user.Password)
+	if err != nil || !match {
 		handler.log.Error("invalid password", zap.Error(err))
-		return handler.errors.NewUnauthorized("Invalid username or password") // Return 401 Unauthorized if password is incorrect
+		handler.recordLoginFailure(ctx, req.Email, user)
+		handler.recordLoginEvent(ctx, user.ID, req.Device, model.LoginInvalidPassword)
+		return handler.errors.NewUnauthorized("Invalid username or password").WithCode(middleware.CodeInvalidCredentials) // Return 401 Unauthorized if password is incorrect
 	}
 
-	// Delete any previous refresh token before issuing a new one
-	if err := handler.repo.DeleteRefreshToken(user.ID); err != nil {
-		if err.Error() == "not found" {
-			handler.log.Info("No previous refresh token found", zap.String("userID", user.ID))
-		} else {
-			handler.log.Error("Failed to delete previous refresh token", zap.Error(err))
-		}
+	// Move hashes made with an older algorithm or weaker parameters to the current ones
+	if needsRehash {
+		handler.upgradePasswordHash(user, req.Password)
+	}
+
+	// Suspended accounts may not sign in until an administrator lifts the suspension
+	if user.Suspended {
+		handler.recordLoginEvent(ctx, user.ID, req.Device, model.LoginSuspended)
+		return handler.errors.NewForbidden("Account is suspended").WithCode(middleware.CodeAccountSuspended) // Return 403 if the account is suspended
+	}
+
+	// Unverified accounts may not sign in when verification is required
+	if requireVerifiedEmail(handler.config, user) {
+		handler.recordLoginEvent(ctx, user.ID, req.Device, model.LoginEmailNotVerified)
+		return handler.errors.NewForbidden("Email address is not verified").WithCode(middleware.CodeEmailNotVerified) // Return 403 if the email address is not verified
 	}
 
-	// Generate access and refresh tokens using the JWT secret from the AppConfig
-	accessToken, refreshToken, err := jwt.GenerateTokens(user.ID, user.Username, user.Role, handler.config.JWTSecret)
+	// Accounts with two-factor authentication enabled have to pass a second step first
+	mfa, err := handler.repo.FindMFA(user.ID)
+	if err != nil {
+		handler.log.Error("Failed to read two-factor settings", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to read two-factor settings") // Return 500 if the settings cannot be read
+	}
+	if mfa.Enabled {
+		handler.recordLoginEvent(ctx, user.ID, req.Device, model.LoginMFAChallenged)
+		return handler.startMFAChallenge(ctx, user, req.Device)
+	}
+
+	// Failures are only forgotten once the login is complete, not after the password step of a two-factor login
+	if err := handler.signIn(ctx, user, req.Device); err != nil {
+		return err
+	}
+	handler.resetLoginFailures(req.Email)
+	return nil
+}
+
+// signIn opens a new session for the user and responds with its tokens.
+func (handler *Auth) signIn(ctx *fiber.Ctx, user *model.User, device string) error {
+	// Every login opens its own session, so sessions on other devices stay signed in
+	session := newSession(ctx, user.ID, device)
+
+	// Generate access and refresh tokens using the keyring from the AppConfig
+	accessToken, refreshToken, err := jwt.GenerateTokens(user.ID, user.Username, user.Role, session.ID, handler.config.Keyring)
 	if err != nil {
 		handler.log.Error("Failed to generate tokens", zap.Error(err))
 		return handler.errors.NewInternalServerError("Failed to generate tokens") // Return 500 if token generation fails
 	}
 
-	// Save the refresh token in the database
-	err = handler.repo.SaveRefreshToken(user.ID, refreshToken)
-	if err != nil {
+	// Save the session together with its refresh token in the database
+	bindTokens(session, accessToken, refreshToken)
+	if err := handler.repo.SaveSession(session); err != nil {
 		handler.log.Error("Failed to save refresh token", zap.Error(err))
 		return handler.errors.NewInternalServerError("Failed to save refresh token") // Return 500 if refresh token save fails
 	}
+	handler.recordLoginEvent(ctx, user.ID, device, model.LoginSucceeded)
 
 	// Respond with access token, refresh token, and user details
 	return ctx.JSON(fiber.Map{
-		"access_token":  accessToken,          // JWT access token for authentication
-		"refresh_token": refreshToken,         // JWT refresh token for obtaining new access tokens
-		"user":          ToResponseUser(user), // User details
+		"access_token":             accessToken,                 // JWT access token for authentication
+		"refresh_token":            refreshToken,                // JWT refresh token for obtaining new access tokens
+		"user":                     ToResponseUser(user),        // User details
+		"password_change_required": user.PasswordChangeRequired, // Set after an administrator assigned a temporary password
 	})
 }
 
@@ -132,22 +242,21 @@ func (handler *Auth) logoutEndpoint(ctx *fiber.Ctx) error {
 		return handler.errors.NewBadRequest("Token is required to logged out") // Return 400 if no token is provided
 	}
 
-	handler.log.Info("Logout successful", zap.String("token", req.Token))
-
-	// Find the user associated with the refresh token by its value
-	userID, err := handler.repo.FindRefreshToken(req.Token)
+	// Find the session associated with the refresh token
+	session, err := handler.repo.FindSessionByRefreshToken(token.Hash(req.Token))
 	if err != nil {
 		handler.log.Error("Invalid refresh token", zap.Error(err))
 		return handler.errors.NewUnauthorized("You are not logged in") // If token is invalid, respond with 401
 	}
 
-	// Delete the refresh token from the database by userID
-	if err := handler.repo.DeleteRefreshToken(userID); err != nil {
+	// End only this session, other devices stay signed in
+	if err := endSession(handler.repo, session); err != nil {
 		handler.log.Error("Failed to delete refresh token", zap.Error(err))
 		return handler.errors.NewInternalServerError("Failed to delete refresh token") // Return 500 if refresh token deletion fails
 	}
 
 	// Success response
+	handler.log.Info("Logout successful", zap.String("userID", session.UserID), zap.String("sessionID", session.ID))
 	return ctx.JSON(fiber.Map{
 		"message": "Logged out successfully", // Successful logout message
 	})
@@ -171,43 +280,69 @@ func (handler *Auth) refreshTokenEndpoint(ctx *fiber.Ctx) error {
 		return handler.errors.NewBadRequest("Identifier and refresh token are required") // 400 - Bad request if identifier or token is missing
 	}
 
-	// Verify if the refresh token has expired
-	if jwt.IsExpired(req.RefreshToken, handler.config.JWTSecret) {
-		return handler.errors.NewUnauthorized("Token has expired") // 401 - Unauthorized if the token has expired
+	// Verify that the token is a refresh token and has not expired
+	if jwt.IsExpired(req.RefreshToken, handler.config.Keyring) {
+		return handler.errors.NewUnauthorized("Invalid or expired refresh token") // 401 - Unauthorized if the token has expired or is an access token
 	}
 
-	// Verify the refresh token by searching for it in the database
-	userID, err := handler.repo.FindRefreshToken(req.RefreshToken)
+	// Verify the refresh token by searching for its session in the database
+	refreshTokenHash := token.Hash(req.RefreshToken)
+	session, err := handler.repo.FindSessionByRefreshToken(refreshTokenHash)
 	if err != nil {
+		// A token that was already rotated is being replayed, so the whole token family is considered stolen
+		if userID, sessionID, reuseErr := handler.repo.FindRotatedRefreshToken(refreshTokenHash); reuseErr == nil {
+			handler.revokeTokenFamily(ctx, userID, sessionID)
+			return handler.errors.NewUnauthorized("Invalid refresh token") // 401 - Unauthorized, the family has been revoked
+		}
 		handler.log.Error("Invalid refresh token", zap.Error(err))
 		return handler.errors.NewUnauthorized("Invalid refresh token") // 401 - Unauthorized if token is not found or invalid
 	}
 
 	// Fetch the user using the identifier (could be email or username)
-	user, err := handler.repo.FindOneByID(userID)
+	user, err := handler.repo.FindOneByID(session.UserID)
 	if err != nil || (user.Email != req.Identifier && user.Username != req.Identifier) {
 		handler.log.Error("User not found or identifier mismatch", zap.Error(err))
 		return handler.errors.NewUnauthorized("There is no matching user") // 401 - Unauthorized if the user is not found or identifier mismatch
 	}
 
-	// Delete any previous refresh token before issuing a new one
-	if err := handler.repo.DeleteRefreshToken(user.ID); err != nil {
-		handler.log.Error("Failed to delete previous refresh token", zap.Error(err))
+	// Suspended accounts may not refresh their tokens
+	if user.Suspended {
+		return handler.errors.NewForbidden("Account is suspended").WithCode(middleware.CodeAccountSuspended) // 403 - Forbidden if the account is suspended
 	}
 
-	// Generate new access and refresh tokens using the JWT secret from the AppConfig
-	accessToken, refreshToken, err := jwt.GenerateTokens(user.ID, user.Username, user.Role, handler.config.JWTSecret)
+	// Unverified accounts may not refresh their tokens when verification is required
+	if requireVerifiedEmail(handler.config, user) {
+		return handler.errors.NewForbidden("Email address is not verified").WithCode(middleware.CodeEmailNotVerified) // 403 - Forbidden if the email address is not verified
+	}
+
+	// Generate new access and refresh tokens for the same session
+	accessToken, refreshToken, err := jwt.GenerateTokens(user.ID, user.Username, user.Role, session.ID, handler.config.Keyring)
 	if err != nil {
 		handler.log.Error("Failed to generate tokens", zap.Error(err))
 		return handler.errors.NewInternalServerError("Failed to generate tokens") // 500 - Internal server error if token generation fails
 	}
 
-	// Save the new refresh token in the database
-	if err := handler.repo.SaveRefreshToken(user.ID, refreshToken); err != nil {
+	// Rotate the session's tokens, which invalidates the old refresh token
+	previousAccessTokenID := session.AccessTokenID
+	bindTokens(session, accessToken, refreshToken)
+	session.Generation++
+	session.LastUsedAt = time.Now()
+	session.IP = ctx.IP()
+	if err := handler.repo.RotateSession(session, refreshTokenHash); err != nil {
+		if errors.Is(err, local.ErrRefreshTokenReused) {
+			// Another request rotated the same token first, which only happens when the token was copied
+			handler.revokeTokenFamily(ctx, session.UserID, session.ID)
+			return handler.errors.NewUnauthorized("Invalid refresh token") // 401 - Unauthorized, the family has been revoked
+		}
 		handler.log.Error("Failed to save new refresh token", zap.Error(err))
 		return handler.errors.NewInternalServerError("Failed to save new refresh token") // 500 - Internal server error if saving refresh token fails
 	}
 
+	// Only the newest access token of a session stays valid
+	if err := revokeAccessToken(handler.repo, previousAccessTokenID); err != nil {
+		handler.log.Error("Failed to revoke previous access token", zap.Error(err))
+	}
+
 	// Use the ToCreateUserResponse function to generate the response
 	response := ToCreateUserResponse(user, accessToken, refreshToken)
 
@@ -215,3 +350,49 @@ func (handler *Auth) refreshTokenEndpoint(ctx *fiber.Ctx) error {
 	handler.log.Info("Successfully created new token", zap.String("user", req.Identifier))
 	return ctx.Status(fiber.StatusOK).JSON(response) // Return successful response with new tokens
 }
+
+// listSessionsEndpoint returns every device the authenticated user is currently signed in on.
+func (handler *Auth) listSessionsEndpoint(ctx *fiber.Ctx) error {
+	// Extracting the user and session IDs from the JWT token (authenticated user).
+	tokenUserID, _ := ctx.Locals("user_id").(string)
+	tokenSessionID, _ := ctx.Locals("session_id").(string)
+
+	sessions, err := handler.repo.FindSessionsByUserID(tokenUserID)
+	if err != nil {
+		handler.log.Error("Failed to fetch sessions", zap.Error(err))
+		return handler.errors.NewInternalServerError("Could not fetch sessions") // Return 500 if sessions cannot be read
+	}
+
+	sessionResponses := make([]model.SessionResponse, len(sessions))
+	for i, session := range sessions {
+		sessionResponses[i] = ToSessionResponse(session, tokenSessionID)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(sessionResponses)
+}
+
+// deleteSessionEndpoint signs the authenticated user out of a single session without touching the others.
+func (handler *Auth) deleteSessionEndpoint(ctx *fiber.Ctx) error {
+	sessionID := ctx.Params("id")
+
+	// Extracting the user ID from the JWT token (authenticated user).
+	tokenUserID, _ := ctx.Locals("user_id").(string)
+
+	// Sessions are stored per user, so users can only ever reach their own sessions.
+	session, err := handler.repo.FindSession(tokenUserID, sessionID)
+	if err != nil {
+		handler.log.Error("Session not found", zap.Error(err), zap.String("sessionID", sessionID))
+		return lookupError(&handler.errors, err, "Session not found", "Failed to find session") // Return 404 if the session does not exist, 500 if it cannot be read
+	}
+
+	if err := endSession(handler.repo, session); err != nil {
+		handler.log.Error("Failed to delete session", zap.Error(err), zap.String("sessionID", sessionID))
+		return handler.errors.NewInternalServerError("Failed to delete session") // Return 500 if the session cannot be ended
+	}
+
+	handler.log.Info("Session deleted", zap.String("userID", tokenUserID), zap.String("sessionID", sessionID))
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Session deleted successfully",
+		"session_id": sessionID,
+	})
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// errStorage is injected into the repository to exercise the error paths of the handlers.
+var errStorage = errors.New("storage unavailable")
+
+func TestRegisterEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.register(t, "john")
+
+	testCases := []struct {
+		name       string
+		body       interface{}
+		fail       string // Repository method failing during the request
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "Taken Email",
+			body:       fiber.Map{"username": "jane", "email": "John@example.com", "name": "Jane", "lastname": "Doe", "age": 30, "password": testPassword},
+			wantStatus: fiber.StatusConflict,
+			wantCode:   middleware.CodeEmailTaken,
+		},
+		{
+			name:       "Taken Username",
+			body:       fiber.Map{"username": "john", "email": "jane@example.com", "name": "Jane", "lastname": "Doe", "age": 30, "password": testPassword},
+			wantStatus: fiber.StatusConflict,
+			wantCode:   middleware.CodeUsernameTaken,
+		},
+		{
+			name:       "Invalid Fields",
+			body:       fiber.Map{"username": "jane", "email": "not-an-email", "name": "Jane", "lastname": "Doe", "age": 30, "password": testPassword},
+			wantStatus: fiber.StatusBadRequest,
+			wantCode:   middleware.CodeValidationFailed,
+		},
+		{
+			name:       "Weak Password",
+			body:       fiber.Map{"username": "jane", "email": "jane@example.com", "name": "Jane", "lastname": "Doe", "age": 30, "password": "jane@example.com"},
+			wantStatus: fiber.StatusBadRequest,
+			wantCode:   middleware.CodeValidationFailed,
+		},
+		{
+			name:       "Failed Session Save",
+			body:       fiber.Map{"username": "jane", "email": "jane@example.com", "name": "Jane", "lastname": "Doe", "age": 30, "password": testPassword},
+			fail:       "SaveSession",
+			wantStatus: fiber.StatusInternalServerError,
+			wantCode:   middleware.CodeInternalError,
+		},
+		{
+			name:       "Failed Create",
+			body:       fiber.Map{"username": "jack", "email": "jack@example.com", "name": "Jack", "lastname": "Doe", "age": 30, "password": testPassword},
+			fail:       "Create",
+			wantStatus: fiber.StatusInternalServerError,
+			wantCode:   middleware.CodeInternalError,
+		},
+		{
+			name:       "Valid User",
+			body:       fiber.Map{"username": "jill", "email": "jill@example.com", "name": "Jill", "lastname": "Doe", "age": 30, "password": testPassword},
+			wantStatus: fiber.StatusCreated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodPost, "/auth/register", "", tc.body)
+			resp.expect(t, tc.wantStatus, tc.wantCode)
+		})
+	}
+}
+
+func TestLoginEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	suspended := s.register(t, "suspended")
+	if _, err := s.repo.ModifyOneByID(suspended.id, func(user *model.User) error {
+		user.Suspended = true
+		return nil
+	}); err != nil {
+		t.Fatalf("ModifyOneByID() error = %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		email      string
+		password   string
+		fail       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "Valid Credentials", email: john.email, password: testPassword, wantStatus: fiber.StatusOK},
+		{name: "Wrong Password", email: john.email, password: "wrong password", wantStatus: fiber.StatusUnauthorized, wantCode: middleware.CodeInvalidCredentials},
+		{name: "Unknown Email", email: "nobody@example.com", password: testPassword, wantStatus: fiber.StatusUnauthorized, wantCode: middleware.CodeInvalidCredentials},
+		{name: "Suspended Account", email: suspended.email, password: testPassword, wantStatus: fiber.StatusForbidden, wantCode: middleware.CodeAccountSuspended},
+		{name: "Failed User Lookup", email: john.email, password: testPassword, fail: "FindOneByEmail", wantStatus: fiber.StatusInternalServerError},
+		{name: "Failed Throttle Check", email: john.email, password: testPassword, fail: "FindLoginAttempts", wantStatus: fiber.StatusInternalServerError},
+		{name: "Failed MFA Lookup", email: john.email, password: testPassword, fail: "FindMFA", wantStatus: fiber.StatusInternalServerError},
+		{name: "Failed Session Save", email: john.email, password: testPassword, fail: "SaveSession", wantStatus: fiber.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.login(t, tc.email, tc.password)
+			resp.expect(t, tc.wantStatus, tc.wantCode)
+			if tc.wantStatus == fiber.StatusOK && resp.body.Get("access_token").String() == "" {
+				t.Fatalf("login returned no access token: %s", resp.body.Raw)
+			}
+		})
+	}
+
+	// Every attempt on an existing account is recorded in its login history
+	events, err := s.repo.FindLoginEvents(john.id, 0)
+	if err != nil || len(events) == 0 {
+		t.Fatalf("FindLoginEvents() = %d events, %v, want the recorded attempts", len(events), err)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	s := newTestServer(t)
+	s.config.LoginThrottle.FreeAttempts = 1
+	s.config.LoginThrottle.LockoutThreshold = 3
+	john := s.register(t, "john")
+
+	// Failures beyond the free attempts are delayed, the lockout refuses even the right password.
+	// Spellings of the address that fold to the same account share its counter.
+	s.login(t, john.email, "wrong password").expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+	s.login(t, " "+strings.Replace(strings.ToUpper(john.email), "J", "\uff2a", 1), "wrong password").expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+	resp := s.login(t, john.email, testPassword)
+	resp.expect(t, fiber.StatusTooManyRequests, middleware.CodeLoginThrottled)
+	if resp.header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Fatalf("throttled login has no Retry-After header")
+	}
+}
+
+func TestLogoutEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+
+	testCases := []struct {
+		name       string
+		token      string
+		fail       string
+		wantStatus int
+	}{
+		{name: "Missing Token", token: "", wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown Token", token: "unknown", wantStatus: fiber.StatusUnauthorized},
+		{name: "Failed Delete", token: john.refreshToken, fail: "DeleteSession", wantStatus: fiber.StatusInternalServerError},
+		{name: "Valid Token", token: john.refreshToken, wantStatus: fiber.StatusOK},
+		{name: "Already Logged Out", token: john.refreshToken, wantStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodPost, "/auth/logout", "", fiber.Map{"token": tc.token})
+			resp.expect(t, tc.wantStatus, "")
+		})
+	}
+
+	// The access token of the ended session is revoked
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+}
+
+func TestRefreshTokenEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	refresh := func(identifier string, refreshToken string) testResponse {
+		return s.request(t, fiber.MethodPost, "/auth/refresh", "", fiber.Map{"identifier": identifier, "refresh_token": refreshToken})
+	}
+
+	refresh("", john.refreshToken).expect(t, fiber.StatusBadRequest, "")
+	refresh("jane", john.refreshToken).expect(t, fiber.StatusUnauthorized, "")
+	refresh(john.email, "unknown").expect(t, fiber.StatusUnauthorized, "")
+
+	s.repo.FailCalls("RotateSession", errStorage)
+	refresh(john.email, john.refreshToken).expect(t, fiber.StatusInternalServerError, "")
+	s.repo.ResetFaults()
+
+	// Rotating hands out a new pair, the previous access token stops working
+	resp := refresh(john.username, john.refreshToken)
+	resp.expect(t, fiber.StatusOK, "")
+	rotated := resp.body.Get("refresh_token").String()
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+	s.request(t, fiber.MethodGet, "/auth/sessions", resp.body.Get("access_token").String(), nil).expect(t, fiber.StatusOK, "")
+
+	// Replaying the rotated token revokes the whole family, including the newest token
+	refresh(john.email, john.refreshToken).expect(t, fiber.StatusUnauthorized, "")
+	refresh(john.email, rotated).expect(t, fiber.StatusUnauthorized, "")
+}
+
+func TestTokenTypes(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+
+	// A refresh token is no bearer token, and an access token cannot be exchanged for new tokens
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.refreshToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenInvalid)
+	s.request(t, fiber.MethodGet, "/user/"+john.id, john.refreshToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenInvalid)
+	s.request(t, fiber.MethodPost, "/auth/refresh", "", fiber.Map{"identifier": john.email, "refresh_token": john.accessToken}).expect(t, fiber.StatusUnauthorized, "")
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusOK, "")
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+
+	// Both requests find the session before either rotates it, only one of them may win
+	s.repo.DelayCalls("FindSessionByRefreshToken", 100*time.Millisecond)
+	statuses := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := s.request(t, fiber.MethodPost, "/auth/refresh", "", fiber.Map{"identifier": john.email, "refresh_token": john.refreshToken})
+			statuses[i] = resp.status
+		}(i)
+	}
+	wg.Wait()
+
+	if statuses[0]+statuses[1] != fiber.StatusOK+fiber.StatusUnauthorized {
+		t.Fatalf("statuses = %v, want one %d and one %d", statuses, fiber.StatusOK, fiber.StatusUnauthorized)
+	}
+	if sessions, _ := s.repo.FindSessionsByUserID(john.id); len(sessions) != 0 {
+		t.Fatalf("%d sessions left, want the family revoked", len(sessions))
+	}
+}
+
+func TestSessionEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	laptop := s.login(t, john.email, testPassword)
+	laptop.expect(t, fiber.StatusOK, "")
+	jane := s.register(t, "jane")
+
+	resp := s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	if sessions := resp.body.Array(); len(sessions) != 2 {
+		t.Fatalf("GET /auth/sessions = %d sessions, want 2", len(sessions))
+	}
+	var laptopSessionID string
+	for _, session := range resp.body.Array() {
+		if !session.Get("current").Bool() {
+			laptopSessionID = session.Get("id").String()
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		sessionID  string
+		token      string
+		fail       string
+		wantStatus int
+	}{
+		{name: "Unknown Session", sessionID: "unknown", token: john.accessToken, wantStatus: fiber.StatusNotFound},
+		{name: "Session Of Another User", sessionID: laptopSessionID, token: jane.accessToken, wantStatus: fiber.StatusNotFound},
+		{name: "Failed Lookup", sessionID: laptopSessionID, token: john.accessToken, fail: "FindSession", wantStatus: fiber.StatusInternalServerError},
+		{name: "Own Session", sessionID: laptopSessionID, token: john.accessToken, wantStatus: fiber.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			s.request(t, fiber.MethodDelete, "/auth/sessions/"+tc.sessionID, tc.token, nil).expect(t, tc.wantStatus, "")
+		})
+	}
+
+	// The signed out device can no longer use its token, the other one can
+	s.request(t, fiber.MethodGet, "/auth/sessions", laptop.body.Get("access_token").String(), nil).expect(t, fiber.StatusUnauthorized, "")
+	s.repo.FailCalls("FindSessionsByUserID", errStorage)
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusInternalServerError, "")
+	s.repo.ResetFaults()
+	s.request(t, fiber.MethodGet, "/auth/sessions", "", nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenMissing)
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/services"
+)
+
+// hasPermission reports whether the role in the access token of the request grants a permission.
+// It must be called behind JWTAuthMiddleware, which puts the role into the context.
+func hasPermission(roles services.RoleService, ctx *fiber.Ctx, permission string) (bool, error) {
+	role, _ := ctx.Locals("role").(string)
+	return roles.HasPermission(role, permission)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+)
+
+// userETag returns the entity tag of a stored user, its version as a quoted string.
+func userETag(user *model.User) string {
+	return strconv.Quote(strconv.FormatInt(user.Version, 10))
+}
+
+// parseIfMatch returns the user version an If-Match header asks for. A missing header or "*" allows any version.
+// Weak tags and lists of tags are refused, since the version check compares a single strong tag.
+func parseIfMatch(header string) (int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return local.AnyVersion, nil
+	}
+	unquoted, err := strconv.Unquote(header)
+	if err != nil || !strings.HasPrefix(header, `"`) {
+		return 0, fmt.Errorf("malformed If-Match header %q", header)
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("malformed If-Match header %q", header)
+	}
+	return version, nil
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/tidwall/gjson"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/mailer"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/services"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/validator"
+	"go.uber.org/zap"
+)
+
+// testPassword satisfies the default password policy for every user the tests register.
+const testPassword = "correct horse battery"
+
+func TestMain(m *testing.M) {
+	// Cheap parameters keep the tests fast, they are not meant for production.
+	password.SetDefault(password.NewHasher(password.Bcrypt{Cost: 4}))
+	os.Exit(m.Run())
+}
+
+// testServer is the application wired like main.go, on an in-memory repository.
+type testServer struct {
+	app    *fiber.App
+	repo   *local.MemoryImpl
+	config *AppConfig
+	mail   *testMailer
+}
+
+// newTestServer starts an application with an empty repository and the default roles.
+func newTestServer(t *testing.T) *testServer {
+	keyring, err := jwt.LoadKeyring([]byte("test-secret"), "", "")
+	if err != nil {
+		t.Fatalf("Error loading JWT keyring: %v", err)
+	}
+	config := &AppConfig{
+		Keyring:               keyring,
+		PasswordResetTTL:      time.Hour,
+		EmailVerificationTTL:  time.Hour,
+		MFAIssuer:             "Test",
+		LoginThrottle:         DefaultLoginThrottle,
+		DefaultRole:           model.DefaultRole,
+		LoginHistoryRetention: time.Hour,
+		UserRestoreWindow:     time.Hour,
+	}
+
+	repo := local.NewMemoryRepository()
+	roleService := services.NewRoleService(repo)
+	if err := roleService.LoadRoles(model.DefaultRoles); err != nil {
+		t.Fatalf("Error storing role definitions: %v", err)
+	}
+
+	logger := zap.NewNop()
+	errors := middleware.AppError{}
+	validate := validator.NewValidator(&password.DefaultPolicy)
+	userService := services.NewUserService(repo)
+	mail := &testMailer{}
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler(logger)})
+	app.Use(requestid.New())
+	NewWellKnown(logger, config).AssignEndpoints("/.well-known", app)
+	NewAuth(logger, repo, validate, config, userService, roleService, mail, errors).AssignEndpoints("auth", app)
+	NewUser(logger, repo, validate, config, userService, roleService, mail, errors).AssignEndpoints("/user", app)
+	NewAdmin(logger, repo, config, roleService, errors).AssignEndpoints("/admin", app)
+
+	return &testServer{app: app, repo: repo, config: config, mail: mail}
+}
+
+// testResponse is a response of the application with its JSON body.
+type testResponse struct {
+	status int
+	header http.Header
+	body   gjson.Result
+}
+
+// request sends a request with an optional JSON body and bearer token and reads the response.
+func (s *testServer) request(t *testing.T, method string, path string, accessToken string, body interface{}, headers ...string) testResponse {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Error encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	if accessToken != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+accessToken)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response of %s %s: %v", method, path, err)
+	}
+	return testResponse{status: resp.StatusCode, header: resp.Header, body: gjson.ParseBytes(data)}
+}
+
+// expect fails the test unless the response has the wanted status and, if set, the wanted error code.
+func (r testResponse) expect(t *testing.T, wantStatus int, wantCode string) {
+	t.Helper()
+	if r.status != wantStatus {
+		t.Fatalf("status = %d, want %d, body %s", r.status, wantStatus, r.body.Raw)
+	}
+	if wantCode != "" && r.body.Get("code").String() != wantCode {
+		t.Fatalf("code = %q, want %q", r.body.Get("code").String(), wantCode)
+	}
+}
+
+// testAccount is a registered user with the tokens of its first session.
+type testAccount struct {
+	id           string
+	username     string
+	email        string
+	accessToken  string
+	refreshToken string
+}
+
+// register signs a new user up with testPassword.
+func (s *testServer) register(t *testing.T, username string) testAccount {
+	t.Helper()
+	email := username + "@example.com"
+	resp := s.request(t, fiber.MethodPost, "/auth/register", "", fiber.Map{
+		"username": username,
+		"email":    email,
+		"name":     "Test",
+		"lastname": "User",
+		"age":      30,
+		"password": testPassword,
+	})
+	resp.expect(t, fiber.StatusCreated, "")
+	return testAccount{
+		id:           resp.body.Get("id").String(),
+		username:     username,
+		email:        email,
+		accessToken:  resp.body.Get("access_token").String(),
+		refreshToken: resp.body.Get("refresh_token").String(),
+	}
+}
+
+// login signs a user in and returns the response.
+func (s *testServer) login(t *testing.T, email string, plain string) testResponse {
+	t.Helper()
+	return s.request(t, fiber.MethodPost, "/auth/login", "", fiber.Map{"email": email, "password": plain})
+}
+
+// registerAdmin signs a new user up and signs it in again as an administrator.
+func (s *testServer) registerAdmin(t *testing.T, username string) testAccount {
+	t.Helper()
+	return s.setRole(t, s.register(t, username), model.AdminRole)
+}
+
+// setRole assigns a role to a user and signs the user in again, so the access token carries it.
+func (s *testServer) setRole(t *testing.T, account testAccount, role string) testAccount {
+	t.Helper()
+	if _, err := s.repo.ModifyOneByID(account.id, func(user *model.User) error {
+		user.Role = role
+		return nil
+	}); err != nil {
+		t.Fatalf("Error assigning role %s: %v", role, err)
+	}
+	resp := s.login(t, account.email, testPassword)
+	resp.expect(t, fiber.StatusOK, "")
+	account.accessToken = resp.body.Get("access_token").String()
+	account.refreshToken = resp.body.Get("refresh_token").String()
+	return account
+}
+
+// testMailer records the emails the handlers send.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+	attempts int   // Calls of Send, including failed ones
+	err      error // Returned by Send instead of recording the message, if set
+}
+
+// mailTimeout bounds how long the tests wait for emails sent in the background.
+const mailTimeout = 2 * time.Second
+
+// Send records a message.
+func (m *testMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts++
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// failWith makes Send fail with an error, or deliver again if it is nil.
+func (m *testMailer) failWith(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// attemptCount returns how often Send was called, including failed calls.
+func (m *testMailer) attemptCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.attempts
+}
+
+// waitAttempts waits until Send was called n times in total, for emails sent in the background.
+func (m *testMailer) waitAttempts(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(mailTimeout)
+	for m.attemptCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d emails sent, want %d", m.attemptCount(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// lastToken returns the token of the last email sent to an address. Without a public URL the links in
+// emails are the bare tokens, on a line of their own.
+func (m *testMailer) lastToken(t *testing.T, to string, subject string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if msg := m.messages[i]; msg.To == to && msg.Subject == subject {
+			lines := strings.Split(msg.Body, "\n\n")
+			return strings.TrimSpace(lines[2])
+		}
+	}
+	t.Fatalf("No email %q sent to %s", subject, to)
+	return ""
+}
+
+// count returns the number of emails with a subject sent to an address.
+func (m *testMailer) count(to string, subject string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, msg := range m.messages {
+		if msg.To == to && msg.Subject == subject {
+			n++
+		}
+	}
+	return n
+}
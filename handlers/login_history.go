@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"go.uber.org/zap"
+)
+
+// recordLoginEvent adds a login attempt on an existing account to its login history.
+// Failures to record are logged, they never fail the login itself.
+func (handler *Auth) recordLoginEvent(ctx *fiber.Ctx, userID string, device string, outcome string) {
+	event := &model.LoginEvent{
+		UserID:    userID,
+		Outcome:   outcome,
+		IP:        ctx.IP(),
+		UserAgent: ctx.Get(fiber.HeaderUserAgent),
+		Device:    device,
+		CreatedAt: time.Now(),
+	}
+	if err := handler.repo.SaveLoginEvent(event, handler.config.LoginHistoryRetention); err != nil {
+		handler.log.Error("Failed to record login event", zap.Error(err), zap.String("userID", userID))
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"go.uber.org/zap"
+)
+
+// LoginThrottle configures how failed logins are slowed down and locked out.
+type LoginThrottle struct {
+	FreeAttempts     int           // Failures allowed before logins are delayed
+	BaseDelay        time.Duration // Delay after the first delayed failure, doubled with every further failure
+	MaxDelay         time.Duration // Upper bound of the delay
+	LockoutThreshold int           // Failures of an account that lock it
+	IPThreshold      int           // Failures from a single IP that block it
+	LockoutDuration  time.Duration // How long a locked account or blocked IP stays locked
+	Window           time.Duration // How long failures are remembered
+}
+
+// DefaultLoginThrottle is used for settings that are not configured.
+var DefaultLoginThrottle = LoginThrottle{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         time.Minute,
+	LockoutThreshold: 10,
+	IPThreshold:      100,
+	LockoutDuration:  15 * time.Minute,
+	Window:           15 * time.Minute,
+}
+
+// accountThrottleKey counts failures per email address, whether or not an account exists for it.
+// The address is folded like the repository's lookup key, so spellings of one account share a counter.
+func accountThrottleKey(email string) string {
+	return "account:" + local.NormalizeIdentifier(email)
+}
+
+// ipThrottleKey counts failures per client IP across all accounts.
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// delay returns how long a client has to wait after the given number of failures.
+func (throttle LoginThrottle) delay(failures int) time.Duration {
+	if failures <= throttle.FreeAttempts {
+		return 0
+	}
+	exponent := float64(failures - throttle.FreeAttempts - 1)
+	delay := time.Duration(float64(throttle.BaseDelay) * math.Pow(2, exponent))
+	if delay > throttle.MaxDelay || delay <= 0 {
+		return throttle.MaxDelay
+	}
+	return delay
+}
+
+// retryAfter returns how long logins for an account counter are refused, zero if they are allowed.
+func (throttle LoginThrottle) retryAfter(attempts *model.LoginAttempts, now time.Time) time.Duration {
+	if wait := attempts.LockedUntil.Sub(now); wait > 0 {
+		return wait
+	}
+	if wait := attempts.LastFailureAt.Add(throttle.delay(attempts.Failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// recordFailure counts a failed login, locking the counter once it reaches threshold.
+func (throttle LoginThrottle) recordFailure(attempts *model.LoginAttempts, threshold int, now time.Time) {
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	attempts.ExpiresAt = now.Add(throttle.Window)
+	if attempts.Failures >= threshold {
+		attempts.LockedUntil = now.Add(throttle.LockoutDuration)
+		if attempts.LockedUntil.After(attempts.ExpiresAt) {
+			attempts.ExpiresAt = attempts.LockedUntil
+		}
+	}
+}
+
+// checkLoginThrottle refuses a login attempt while the account or the client IP is delayed or locked.
+// Unknown accounts are counted by email address as well, so the answer never reveals whether an account exists.
+func (handler *Auth) checkLoginThrottle(ctx *fiber.Ctx, email string, user *model.User) error {
+	now := time.Now()
+	accountAttempts, err := handler.repo.FindLoginAttempts(accountThrottleKey(email))
+	if err != nil {
+		handler.log.Error("Failed to read login attempts", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to check login attempts") // Return 500 if the counters cannot be read
+	}
+	ipAttempts, err := handler.repo.FindLoginAttempts(ipThrottleKey(ctx.IP()))
+	if err != nil {
+		handler.log.Error("Failed to read login attempts", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to check login attempts") // Return 500 if the counters cannot be read
+	}
+
+	// Accounts are delayed progressively, IPs are only blocked at their own, higher threshold,
+	// so users behind a shared address are not slowed down by each other's typos
+	wait := handler.config.LoginThrottle.retryAfter(accountAttempts, now)
+	if w := ipAttempts.LockedUntil.Sub(now); w > wait {
+		wait = w
+	}
+	if user != nil {
+		if w := user.LockedUntil.Sub(now); w > wait {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return handler.errors.NewTooManyRequests("Too many failed login attempts, try again in " + strconv.Itoa(seconds) + " seconds").WithCode(middleware.CodeLoginThrottled) // Return 429 while delayed or locked
+}
+
+// recordLoginFailure counts a failed login for the account and the client IP, and locks the account
+// record once it reaches the lockout threshold.
+func (handler *Auth) recordLoginFailure(ctx *fiber.Ctx, email string, user *model.User) {
+	throttle := handler.config.LoginThrottle
+	now := time.Now()
+
+	accountAttempts, err := handler.repo.ModifyLoginAttempts(accountThrottleKey(email), func(attempts *model.LoginAttempts) error {
+		throttle.recordFailure(attempts, throttle.LockoutThreshold, now)
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("Failed to record failed login", zap.Error(err))
+	}
+	_, err = handler.repo.ModifyLoginAttempts(ipThrottleKey(ctx.IP()), func(attempts *model.LoginAttempts) error {
+		throttle.recordFailure(attempts, throttle.IPThreshold, now)
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("Failed to record failed login", zap.Error(err))
+	}
+
+	// Mirror the lockout on the account so it is visible on the user record
+	if user == nil || accountAttempts == nil || !accountAttempts.LockedUntil.After(now) {
+		return
+	}
+	_, err = handler.repo.ModifyOneByID(user.ID, func(user *model.User) error {
+		user.LockedUntil = accountAttempts.LockedUntil
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("Failed to lock account", zap.Error(err))
+		return
+	}
+	handler.log.Warn("Security event: account locked after too many failed logins",
+		zap.String("event", "account_locked"),
+		zap.String("userID", user.ID),
+		zap.String("ip", ctx.IP()),
+		zap.Time("lockedUntil", accountAttempts.LockedUntil),
+	)
+}
+
+// resetLoginFailures forgets the failed logins of an account after a successful login.
+func (handler *Auth) resetLoginFailures(email string) {
+	if err := handler.repo.ResetLoginAttempts(accountThrottleKey(email)); err != nil {
+		handler.log.Error("Failed to reset login attempts", zap.Error(err))
+	}
+}
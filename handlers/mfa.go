@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/token"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/totp"
+	"go.uber.org/zap"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute // Time between the password step and the second step of a login
+	recoveryCodeCount = 10              // Number of recovery codes handed out at once
+)
+
+var (
+	errMFANotEnabled  = errors.New("two-factor authentication is not enabled")
+	errMFAEnabled     = errors.New("two-factor authentication is already enabled")
+	errMFANotEnrolled = errors.New("two-factor authentication enrollment was not started")
+	errInvalidMFACode = errors.New("invalid two-factor code")
+)
+
+// recoveryCodeEncoding produces codes that are easy to read and type.
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns a fresh set of recovery codes and the hashes that are stored in their place.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = token.Hash(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes regardless of case, dashes and spaces.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// useSecondFactor accepts either a current TOTP code or an unused recovery code, which is then burned.
+// Each TOTP code is accepted only once, so a code seen by an attacker cannot be replayed.
+func useSecondFactor(mfa *model.MFA, code string, now time.Time) error {
+	if !mfa.Enabled {
+		return errMFANotEnabled
+	}
+
+	if step, ok := totp.Validate(mfa.Secret, code, now); ok {
+		if step <= mfa.LastUsedStep {
+			return errInvalidMFACode // The code was already used.
+		}
+		mfa.LastUsedStep = step
+		return nil
+	}
+
+	hash := token.Hash(normalizeRecoveryCode(code))
+	for i, stored := range mfa.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			mfa.RecoveryCodes = append(mfa.RecoveryCodes[:i], mfa.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+	return errInvalidMFACode
+}
+
+// startMFAChallenge answers the password step of a login with a short-lived challenge token instead of session tokens.
+func (handler *Auth) startMFAChallenge(ctx *fiber.Ctx, user *model.User, device string) error {
+	challengeToken, err := token.Generate()
+	if err != nil {
+		handler.log.Error("Failed to generate MFA challenge", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to generate MFA challenge") // Return 500 if token generation fails
+	}
+	err = handler.repo.SaveOneTimeToken(token.Hash(challengeToken), &model.OneTimeToken{
+		Purpose:   model.TokenPurposeMFAChallenge,
+		UserID:    user.ID,
+		Payload:   device,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		handler.log.Error("Failed to save MFA challenge", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to save MFA challenge") // Return 500 if the challenge cannot be saved
+	}
+
+	return ctx.JSON(fiber.Map{
+		"mfa_required": true,                               // The login has to be completed at /auth/login/mfa
+		"mfa_token":    challengeToken,                     // Challenge token for the second step
+		"expires_in":   int(mfaChallengeTTL / time.Second), // Seconds until the challenge expires
+	})
+}
+
+// loginMFAEndpoint completes a login by checking the second factor against the challenge issued by loginEndpoint.
+// A challenge can only be answered once, a wrong code means starting the login over.
+func (handler *Auth) loginMFAEndpoint(ctx *fiber.Ctx) error {
+	type loginMFARequest struct {
+		MFAToken string `json:"mfa_token"` // Challenge token returned by /auth/login
+		Code     string `json:"code"`      // TOTP code or recovery code
+	}
+
+	var req loginMFARequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+
+	if req.MFAToken == "" || req.Code == "" {
+		return handler.errors.NewBadRequest("MFA token and code are required") // Return 400 if the token or code is missing
+	}
+
+	// Consume the challenge, a second attempt with the same challenge fails
+	challenge, err := handler.repo.ConsumeOneTimeToken(model.TokenPurposeMFAChallenge, token.Hash(req.MFAToken))
+	if err != nil {
+		handler.log.Error("Invalid MFA challenge", zap.Error(err))
+		return handler.errors.NewUnauthorized("Invalid or expired MFA token") // Return 401 if the challenge is unknown, used or expired
+	}
+
+	user, err := handler.repo.FindOneByID(challenge.UserID)
+	if err != nil {
+		handler.log.Error("User of MFA challenge not found", zap.Error(err))
+		return handler.errors.NewUnauthorized("Invalid or expired MFA token") // Return 401 if the account is gone
+	}
+
+	// Wrong codes count against the same counters as wrong passwords, so a leaked password
+	// does not allow guessing codes with fresh challenges
+	if err := handler.checkLoginThrottle(ctx, user.Email, user); err != nil {
+		handler.recordLoginEvent(ctx, user.ID, challenge.Payload, model.LoginThrottled)
+		return err
+	}
+
+	// Check the second factor and record its use in the same transaction
+	_, err = handler.repo.ModifyMFA(user.ID, func(mfa *model.MFA) error {
+		return useSecondFactor(mfa, req.Code, time.Now())
+	})
+	if err != nil {
+		handler.log.Warn("Invalid second factor", zap.String("userID", user.ID), zap.Error(err))
+		handler.recordLoginFailure(ctx, user.Email, user)
+		handler.recordLoginEvent(ctx, user.ID, challenge.Payload, model.LoginInvalidSecondFactor)
+		return handler.errors.NewUnauthorized("Invalid two-factor code") // Return 401 if the code is wrong or was already used
+	}
+
+	// The account may have been suspended while the challenge was open
+	if user.Suspended {
+		handler.recordLoginEvent(ctx, user.ID, challenge.Payload, model.LoginSuspended)
+		return handler.errors.NewForbidden("Account is suspended").WithCode(middleware.CodeAccountSuspended) // Return 403 if the account is suspended
+	}
+
+	if err := handler.signIn(ctx, user, challenge.Payload); err != nil {
+		return err
+	}
+	handler.resetLoginFailures(user.Email)
+	return nil
+}
+
+// enrollMFAEndpoint generates a new TOTP secret for the authenticated user. It only becomes active once confirmed.
+func (handler *Auth) enrollMFAEndpoint(ctx *fiber.Ctx) error {
+	// Extracting the user ID from the JWT token (authenticated user).
+	tokenUserID, _ := ctx.Locals("user_id").(string)
+
+	user, err := handler.repo.FindOneByID(tokenUserID)
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the account is gone, 500 if it cannot be read
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		handler.log.Error("Failed to generate TOTP secret", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to generate TOTP secret") // Return 500 if secret generation fails
+	}
+
+	_, err = handler.repo.ModifyMFA(user.ID, func(mfa *model.MFA) error {
+		if mfa.Enabled {
+			return errMFAEnabled
+		}
+		mfa.PendingSecret = secret
+		return nil
+	})
+	if errors.Is(err, errMFAEnabled) {
+		return handler.errors.NewBadRequest("Two-factor authentication is already enabled") // Return 400 if MFA is already on
+	}
+	if err != nil {
+		handler.log.Error("Failed to save TOTP secret", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to save TOTP secret") // Return 500 if the secret cannot be saved
+	}
+
+	handler.log.Info("MFA enrollment started", zap.String("userID", user.ID))
+	return ctx.JSON(fiber.Map{
+		"secret":      secret,                                                 // Secret for manual entry in an authenticator app
+		"otpauth_uri": totp.URI(handler.config.MFAIssuer, user.Email, secret), // Payload for a QR code
+	})
+}
+
+// confirmMFAEndpoint turns two-factor authentication on once the user proves their app produces valid codes.
+// The recovery codes are returned only here and only once.
+func (handler *Auth) confirmMFAEndpoint(ctx *fiber.Ctx) error {
+	type confirmMFARequest struct {
+		Code string `json:"code"` // TOTP code from the newly set up authenticator app
+	}
+
+	var req confirmMFARequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+
+	// Extracting the user ID from the JWT token (authenticated user).
+	tokenUserID, _ := ctx.Locals("user_id").(string)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		handler.log.Error("Failed to generate recovery codes", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to generate recovery codes") // Return 500 if code generation fails
+	}
+
+	_, err = handler.repo.ModifyMFA(tokenUserID, func(mfa *model.MFA) error {
+		if mfa.Enabled {
+			return errMFAEnabled
+		}
+		if mfa.PendingSecret == "" {
+			return errMFANotEnrolled
+		}
+		step, ok := totp.Validate(mfa.PendingSecret, req.Code, time.Now())
+		if !ok {
+			return errInvalidMFACode
+		}
+		mfa.Secret = mfa.PendingSecret
+		mfa.PendingSecret = ""
+		mfa.Enabled = true
+		mfa.RecoveryCodes = hashes
+		mfa.LastUsedStep = step
+		mfa.EnabledAt = time.Now()
+		return nil
+	})
+	switch {
+	case errors.Is(err, errMFAEnabled):
+		return handler.errors.NewBadRequest("Two-factor authentication is already enabled") // Return 400 if MFA is already on
+	case errors.Is(err, errMFANotEnrolled):
+		return handler.errors.NewBadRequest("Two-factor enrollment was not started") // Return 400 if there is no secret to confirm
+	case errors.Is(err, errInvalidMFACode):
+		return handler.errors.NewBadRequest("Invalid two-factor code") // Return 400 if the code does not match
+	case err != nil:
+		handler.log.Error("Failed to enable MFA", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to enable two-factor authentication") // Return 500 if the update fails
+	}
+
+	handler.log.Info("MFA enabled", zap.String("userID", tokenUserID))
+	return ctx.JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes, // Shown once, only their hashes are stored
+	})
+}
+
+// disableMFAEndpoint turns two-factor authentication off. It requires the password and a second factor,
+// so a stolen access token alone cannot remove the protection.
+func (handler *Auth) disableMFAEndpoint(ctx *fiber.Ctx) error {
+	type disableMFARequest struct {
+		Password string `json:"password"` // Current password
+		Code     string `json:"code"`     // TOTP code or recovery code
+	}
+
+	var req disableMFARequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+
+	// Extracting the user ID from the JWT token (authenticated user).
+	tokenUserID, _ := ctx.Locals("user_id").(string)
+
+	user, err := handler.repo.FindOneByID(tokenUserID)
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the account is gone, 500 if it cannot be read
+	}
+	if match, _, err := password.VerifyPassword(req.Password, user.Password); err != nil || !match {
+		return handler.errors.NewUnauthorized("Invalid password") // Return 401 if the password is wrong
+	}
+
+	_, err = handler.repo.ModifyMFA(user.ID, func(mfa *model.MFA) error {
+		return useSecondFactor(mfa, req.Code, time.Now())
+	})
+	switch {
+	case errors.Is(err, errMFANotEnabled):
+		return handler.errors.NewBadRequest("Two-factor authentication is not enabled") // Return 400 if MFA is off
+	case errors.Is(err, errInvalidMFACode):
+		return handler.errors.NewUnauthorized("Invalid two-factor code") // Return 401 if the code is wrong or was already used
+	case err != nil:
+		handler.log.Error("Failed to check second factor", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to check second factor") // Return 500 if the check fails
+	}
+
+	if err := handler.repo.DeleteMFA(user.ID); err != nil {
+		handler.log.Error("Failed to disable MFA", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to disable two-factor authentication") // Return 500 if the record cannot be deleted
+	}
+
+	handler.log.Info("MFA disabled", zap.String("userID", user.ID))
+	return ctx.JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// regenerateRecoveryCodesEndpoint replaces all recovery codes, invalidating the old ones.
+func (handler *Auth) regenerateRecoveryCodesEndpoint(ctx *fiber.Ctx) error {
+	type regenerateRequest struct {
+		Code string `json:"code"` // TOTP code or recovery code
+	}
+
+	var req regenerateRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+
+	// Extracting the user ID from the JWT token (authenticated user).
+	tokenUserID, _ := ctx.Locals("user_id").(string)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		handler.log.Error("Failed to generate recovery codes", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to generate recovery codes") // Return 500 if code generation fails
+	}
+
+	_, err = handler.repo.ModifyMFA(tokenUserID, func(mfa *model.MFA) error {
+		if err := useSecondFactor(mfa, req.Code, time.Now()); err != nil {
+			return err
+		}
+		mfa.RecoveryCodes = hashes
+		return nil
+	})
+	switch {
+	case errors.Is(err, errMFANotEnabled):
+		return handler.errors.NewBadRequest("Two-factor authentication is not enabled") // Return 400 if MFA is off
+	case errors.Is(err, errInvalidMFACode):
+		return handler.errors.NewUnauthorized("Invalid two-factor code") // Return 401 if the code is wrong or was already used
+	case err != nil:
+		handler.log.Error("Failed to replace recovery codes", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to replace recovery codes") // Return 500 if the update fails
+	}
+
+	handler.log.Info("MFA recovery codes regenerated", zap.String("userID", tokenUserID))
+	return ctx.JSON(fiber.Map{
+		"recovery_codes": codes, // Shown once, only their hashes are stored
+	})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/totp"
+)
+
+// totpCode returns the code of a secret for a time step.
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatalf("Error generating TOTP code: %v", err)
+	}
+	return code
+}
+
+// testMFA is the second factor of a user. Codes up to one step after the confirmed one are accepted,
+// so the tests do not depend on when the step changes.
+type testMFA struct {
+	secret        string
+	step          int64 // Step of the code used for the confirmation
+	recoveryCodes []string
+}
+
+// enableMFA enrolls a user in two-factor authentication.
+func (s *testServer) enableMFA(t *testing.T, account testAccount) testMFA {
+	t.Helper()
+	resp := s.request(t, fiber.MethodPost, "/auth/mfa/enroll", account.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	mfa := testMFA{secret: resp.body.Get("secret").String(), step: totp.Step(time.Now())}
+
+	resp = s.request(t, fiber.MethodPost, "/auth/mfa/confirm", account.accessToken, fiber.Map{"code": totpCode(t, mfa.secret, mfa.step)})
+	resp.expect(t, fiber.StatusOK, "")
+	for _, code := range resp.body.Get("recovery_codes").Array() {
+		mfa.recoveryCodes = append(mfa.recoveryCodes, code.String())
+	}
+	return mfa
+}
+
+func TestEnrollMFAEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+
+	s.repo.FailCalls("ModifyMFA", errStorage)
+	s.request(t, fiber.MethodPost, "/auth/mfa/enroll", john.accessToken, nil).expect(t, fiber.StatusInternalServerError, "")
+	s.repo.ResetFaults()
+
+	resp := s.request(t, fiber.MethodPost, "/auth/mfa/enroll", john.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	if resp.body.Get("secret").String() == "" || resp.body.Get("otpauth_uri").String() == "" {
+		t.Fatalf("enrollment = %s, want a secret and its URI", resp.body.Raw)
+	}
+
+	// Enrollment is not active until it is confirmed, so the password alone still signs in
+	resp = s.login(t, john.email, testPassword)
+	resp.expect(t, fiber.StatusOK, "")
+	if resp.body.Get("mfa_required").Bool() {
+		t.Fatalf("login requires MFA before the enrollment was confirmed")
+	}
+
+	s.enableMFA(t, john)
+	s.request(t, fiber.MethodPost, "/auth/mfa/enroll", john.accessToken, nil).expect(t, fiber.StatusBadRequest, "")
+}
+
+func TestConfirmMFAEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	s.request(t, fiber.MethodPost, "/auth/mfa/confirm", john.accessToken, fiber.Map{"code": "123456"}).expect(t, fiber.StatusBadRequest, "")
+
+	resp := s.request(t, fiber.MethodPost, "/auth/mfa/enroll", john.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	secret := resp.body.Get("secret").String()
+	step := totp.Step(time.Now())
+
+	testCases := []struct {
+		name       string
+		code       string
+		wantStatus int
+	}{
+		{name: "Wrong Code", code: "000000", wantStatus: fiber.StatusBadRequest},
+		{name: "Current Code", code: totpCode(t, secret, step), wantStatus: fiber.StatusOK},
+		{name: "Already Enabled", code: totpCode(t, secret, step+1), wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := s.request(t, fiber.MethodPost, "/auth/mfa/confirm", john.accessToken, fiber.Map{"code": tc.code})
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusOK && len(resp.body.Get("recovery_codes").Array()) != recoveryCodeCount {
+				t.Fatalf("recovery codes = %s, want %d", resp.body.Get("recovery_codes").Raw, recoveryCodeCount)
+			}
+		})
+	}
+}
+
+func TestLoginMFAEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	mfa := s.enableMFA(t, john)
+	challenge := func() string {
+		resp := s.login(t, john.email, testPassword)
+		resp.expect(t, fiber.StatusOK, "")
+		if !resp.body.Get("mfa_required").Bool() || resp.body.Get("access_token").Exists() {
+			t.Fatalf("login = %s, want an MFA challenge instead of tokens", resp.body.Raw)
+		}
+		return resp.body.Get("mfa_token").String()
+	}
+
+	testCases := []struct {
+		name       string
+		mfaToken   string
+		code       string
+		wantStatus int
+	}{
+		{name: "Missing Code", mfaToken: challenge(), code: "", wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown Challenge", mfaToken: "unknown", code: totpCode(t, mfa.secret, mfa.step+1), wantStatus: fiber.StatusUnauthorized},
+		{name: "Code Used By Confirmation", mfaToken: challenge(), code: totpCode(t, mfa.secret, mfa.step), wantStatus: fiber.StatusUnauthorized},
+		{name: "Next Code", mfaToken: challenge(), code: totpCode(t, mfa.secret, mfa.step+1), wantStatus: fiber.StatusOK},
+		{name: "Recovery Code", mfaToken: challenge(), code: mfa.recoveryCodes[0], wantStatus: fiber.StatusOK},
+		{name: "Used Recovery Code", mfaToken: challenge(), code: mfa.recoveryCodes[0], wantStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := s.request(t, fiber.MethodPost, "/auth/login/mfa", "", fiber.Map{"mfa_token": tc.mfaToken, "code": tc.code})
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusOK && resp.body.Get("access_token").String() == "" {
+				t.Fatalf("login returned no access token: %s", resp.body.Raw)
+			}
+		})
+	}
+
+	// A challenge cannot be answered twice, even after a wrong answer
+	mfaToken := challenge()
+	s.request(t, fiber.MethodPost, "/auth/login/mfa", "", fiber.Map{"mfa_token": mfaToken, "code": "000000"}).expect(t, fiber.StatusUnauthorized, "")
+	s.request(t, fiber.MethodPost, "/auth/login/mfa", "", fiber.Map{"mfa_token": mfaToken, "code": mfa.recoveryCodes[1]}).expect(t, fiber.StatusUnauthorized, "")
+
+	// A suspension while the challenge is open prevents the login
+	mfaToken = challenge()
+	s.request(t, fiber.MethodPost, "/admin/users/"+john.id+"/suspend", s.registerAdmin(t, "admin").accessToken, nil).expect(t, fiber.StatusOK, "")
+	s.request(t, fiber.MethodPost, "/auth/login/mfa", "", fiber.Map{"mfa_token": mfaToken, "code": mfa.recoveryCodes[1]}).expect(t, fiber.StatusForbidden, middleware.CodeAccountSuspended)
+}
+
+func TestLoginMFAThrottle(t *testing.T) {
+	s := newTestServer(t)
+	s.config.LoginThrottle.FreeAttempts = 3
+	s.config.LoginThrottle.LockoutThreshold = 3
+	john := s.register(t, "john")
+	mfa := s.enableMFA(t, john)
+	challenge := func() string {
+		resp := s.login(t, john.email, testPassword)
+		resp.expect(t, fiber.StatusOK, "")
+		return resp.body.Get("mfa_token").String()
+	}
+
+	// The right password does not reset the counter, every challenge answered wrongly counts as a failure
+	open := challenge()
+	for i := 0; i < 3; i++ {
+		s.request(t, fiber.MethodPost, "/auth/login/mfa", "", fiber.Map{"mfa_token": challenge(), "code": "000000"}).expect(t, fiber.StatusUnauthorized, "")
+	}
+
+	// The locked account neither gets new challenges nor completes open ones, even with the right code
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusTooManyRequests, middleware.CodeLoginThrottled)
+	s.request(t, fiber.MethodPost, "/auth/login/mfa", "", fiber.Map{"mfa_token": open, "code": mfa.recoveryCodes[0]}).expect(t, fiber.StatusTooManyRequests, middleware.CodeLoginThrottled)
+}
+
+func TestDisableMFAEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	s.request(t, fiber.MethodPost, "/auth/mfa/disable", john.accessToken, fiber.Map{"password": testPassword, "code": "123456"}).expect(t, fiber.StatusBadRequest, "")
+	recoveryCodes := s.enableMFA(t, john).recoveryCodes
+
+	testCases := []struct {
+		name       string
+		password   string
+		code       string
+		fail       string
+		wantStatus int
+	}{
+		{name: "Wrong Password", password: "wrong password", code: recoveryCodes[0], wantStatus: fiber.StatusUnauthorized},
+		{name: "Wrong Code", password: testPassword, code: "000000", wantStatus: fiber.StatusUnauthorized},
+		{name: "Failed Delete", password: testPassword, code: recoveryCodes[0], fail: "DeleteMFA", wantStatus: fiber.StatusInternalServerError},
+		{name: "Valid Credentials", password: testPassword, code: recoveryCodes[1], wantStatus: fiber.StatusOK},
+		{name: "Already Disabled", password: testPassword, code: recoveryCodes[2], wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodPost, "/auth/mfa/disable", john.accessToken, fiber.Map{"password": tc.password, "code": tc.code})
+			resp.expect(t, tc.wantStatus, "")
+		})
+	}
+
+	// Without a second factor the password signs in again
+	resp := s.login(t, john.email, testPassword)
+	resp.expect(t, fiber.StatusOK, "")
+	if resp.body.Get("access_token").String() == "" {
+		t.Fatalf("login = %s, want tokens after MFA was disabled", resp.body.Raw)
+	}
+}
+
+func TestRegenerateRecoveryCodesEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	s.request(t, fiber.MethodPost, "/auth/mfa/recovery-codes", john.accessToken, fiber.Map{"code": "123456"}).expect(t, fiber.StatusBadRequest, "")
+	mfa := s.enableMFA(t, john)
+
+	s.request(t, fiber.MethodPost, "/auth/mfa/recovery-codes", john.accessToken, fiber.Map{"code": "000000"}).expect(t, fiber.StatusUnauthorized, "")
+	resp := s.request(t, fiber.MethodPost, "/auth/mfa/recovery-codes", john.accessToken, fiber.Map{"code": totpCode(t, mfa.secret, mfa.step+1)})
+	resp.expect(t, fiber.StatusOK, "")
+	newCodes := resp.body.Get("recovery_codes").Array()
+	if len(newCodes) != recoveryCodeCount {
+		t.Fatalf("recovery codes = %s, want %d", resp.body.Get("recovery_codes").Raw, recoveryCodeCount)
+	}
+
+	// Only the new codes are accepted
+	s.request(t, fiber.MethodPost, "/auth/mfa/recovery-codes", john.accessToken, fiber.Map{"code": mfa.recoveryCodes[0]}).expect(t, fiber.StatusUnauthorized, "")
+	s.request(t, fiber.MethodPost, "/auth/mfa/recovery-codes", john.accessToken, fiber.Map{"code": newCodes[0].String()}).expect(t, fiber.StatusOK, "")
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/mailer"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/token"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/validator"
+	"go.uber.org/zap"
+)
+
+// errPasswordChanged aborts a rehash when the password was changed after it was verified.
+var errPasswordChanged = errors.New("password changed concurrently")
+
+// forgotPasswordEndpoint sends a single-use password reset token to the given email address.
+// The response is the same whether or not the address is registered, so accounts cannot be enumerated.
+func (handler *Auth) forgotPasswordEndpoint(ctx *fiber.Ctx) error {
+	type forgotPasswordRequest struct {
+		Email string `json:"email"` // Email address of the account to recover
+	}
+
+	var req forgotPasswordRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+
+	if !handler.validate.ValidateEmailFormat(req.Email) {
+		return handler.errors.NewBadRequest("Invalid email format") // Return 400 if the email is malformed
+	}
+
+	response := fiber.Map{
+		"message": "If the email is registered, a password reset link has been sent",
+	}
+
+	// Unknown addresses get the same answer as known ones
+	user, err := handler.repo.FindOneByEmail(req.Email)
+	if err != nil {
+		handler.log.Info("Password reset requested for unknown email", zap.Error(err))
+		return ctx.Status(fiber.StatusAccepted).JSON(response)
+	}
+
+	// Failures past this point are only logged: an error response would reveal that the address is registered
+	resetToken, err := token.Generate()
+	if err != nil {
+		handler.log.Error("Failed to generate reset token", zap.Error(err))
+		return ctx.Status(fiber.StatusAccepted).JSON(response)
+	}
+	err = handler.repo.SaveOneTimeToken(token.Hash(resetToken), &model.OneTimeToken{
+		Purpose:   model.TokenPurposePasswordReset,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(handler.config.PasswordResetTTL),
+	})
+	if err != nil {
+		handler.log.Error("Failed to save reset token", zap.Error(err))
+		return ctx.Status(fiber.StatusAccepted).JSON(response)
+	}
+
+	// Email the token in the background, so the response time does not depend on the mail server either
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nUse the following link to choose a new password. It expires in %s and can only be used once.\n\n%s\n\nIf you did not request a password reset, you can ignore this email.\n",
+			user.Name, handler.config.PasswordResetTTL, handler.config.link("/reset-password", resetToken)),
+	}
+	go func() {
+		if err := handler.mailer.Send(msg); err != nil {
+			handler.log.Error("Failed to send password reset email", zap.Error(err), zap.String("userID", user.ID))
+			return
+		}
+		handler.log.Info("Password reset email sent", zap.String("userID", user.ID))
+	}()
+
+	return ctx.Status(fiber.StatusAccepted).JSON(response)
+}
+
+// resetPasswordEndpoint sets a new password using a reset token and signs the user out everywhere.
+func (handler *Auth) resetPasswordEndpoint(ctx *fiber.Ctx) error {
+	type resetPasswordRequest struct {
+		Token    string `json:"token"`    // Reset token received by email
+		Password string `json:"password"` // New password
+	}
+
+	var req resetPasswordRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+
+	if req.Token == "" {
+		return handler.errors.NewBadRequest("Reset token is required") // Return 400 if no token is provided
+	}
+
+	// Validate the new password with the same rules as registration
+	if req.Password == "" {
+		return handler.errors.NewValidationError([]validator.FieldError{
+			{Field: "password", Rule: "required", Message: "password is required"},
+		}) // Return 400 if no password is provided
+	}
+	update := &model.User{Password: req.Password}
+	if fieldErrors := handler.validate.ValidateUserUpdate(update); len(fieldErrors) > 0 {
+		return handler.errors.NewValidationError(fieldErrors) // Return 400 if the new password is invalid
+	}
+
+	// Look the token up without using it, so a rejected password can be retried with the same link
+	resetToken, err := handler.repo.FindOneTimeToken(model.TokenPurposePasswordReset, token.Hash(req.Token))
+	if err != nil {
+		handler.log.Error("Invalid reset token", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid or expired reset token") // Return 400 if the token is unknown, used or expired
+	}
+
+	// Check the new password against the policy, which needs the account's username and email address
+	user, err := handler.repo.FindOneByID(resetToken.UserID)
+	if err != nil {
+		handler.log.Error("User of reset token not found", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid or expired reset token") // Return 400 if the account was deleted meanwhile
+	}
+	fieldErrors, err := handler.validate.ValidatePassword(req.Password, user)
+	if err != nil {
+		handler.log.Error("Failed to check password policy", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to check password") // Return 500 if the policy cannot be evaluated
+	}
+	if len(fieldErrors) > 0 {
+		return handler.errors.NewValidationError(fieldErrors) // Return 400 if the new password violates the policy
+	}
+
+	// Consume the token, of two concurrent attempts with the same token only one gets past this point
+	if _, err := handler.repo.ConsumeOneTimeToken(model.TokenPurposePasswordReset, token.Hash(req.Token)); err != nil {
+		handler.log.Error("Failed to consume reset token", zap.Error(err))
+		if errors.Is(err, local.ErrNotFound) {
+			return handler.errors.NewBadRequest("Invalid or expired reset token") // Return 400 if the token was used meanwhile
+		}
+		return handler.errors.NewInternalServerError("Failed to consume reset token") // Return 500 if the token cannot be consumed
+	}
+
+	// Store the new password, the repository hashes it
+	if _, err := handler.repo.UpdateOneByID(resetToken.UserID, update, local.AnyVersion); err != nil {
+		handler.log.Error("Failed to update password", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to update password") // Return 500 if the update fails
+	}
+
+	// Whoever knew the old password must not stay signed in
+	if err := endAllSessions(handler.repo, resetToken.UserID); err != nil {
+		handler.log.Error("Failed to revoke sessions after password reset", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to revoke sessions") // Return 500 if sessions cannot be revoked
+	}
+
+	handler.log.Info("Password reset successfully", zap.String("userID", resetToken.UserID))
+	return ctx.JSON(fiber.Map{
+		"message": "Password reset successfully",
+	})
+}
+
+// upgradePasswordHash replaces an outdated password hash after the password was verified at login.
+// The hash is only replaced if the password was not changed in the meantime. Failures are logged,
+// the old hash keeps working.
+func (handler *Auth) upgradePasswordHash(user *model.User, plain string) {
+	rehashed, err := password.HashPassword(plain)
+	if err != nil {
+		handler.log.Error("Failed to rehash password", zap.Error(err))
+		return
+	}
+	previous := user.Password
+	_, err = handler.repo.ModifyOneByID(user.ID, func(user *model.User) error {
+		if user.Password != previous {
+			return errPasswordChanged
+		}
+		user.Password = rehashed
+		return nil
+	})
+	if err != nil {
+		handler.log.Error("Failed to store rehashed password", zap.Error(err))
+		return
+	}
+	handler.log.Info("Password hash upgraded", zap.String("userID", user.ID))
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+)
+
+const resetSubject = "Reset your password"
+
+func TestForgotPasswordEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+
+	testCases := []struct {
+		name         string
+		email        string
+		fail         string
+		mailErr      error
+		wantStatus   int
+		wantAttempts int // Emails the request tries to send
+		wantMails    int // Reset emails sent to John after the request
+	}{
+		{name: "Invalid Email", email: "john", wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown Email", email: "nobody@example.com", wantStatus: fiber.StatusAccepted},
+		{name: "Failed Token Save", email: john.email, fail: "SaveOneTimeToken", wantStatus: fiber.StatusAccepted},
+		{name: "Failed Email", email: john.email, mailErr: errors.New("smtp down"), wantStatus: fiber.StatusAccepted, wantAttempts: 1},
+		{name: "Registered Email", email: john.email, wantStatus: fiber.StatusAccepted, wantAttempts: 1, wantMails: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			s.mail.failWith(tc.mailErr)
+			defer s.mail.failWith(nil)
+
+			// Failures are not reported to the caller, the response is the same for every address
+			attempts := s.mail.attemptCount()
+			resp := s.request(t, fiber.MethodPost, "/auth/password/forgot", "", fiber.Map{"email": tc.email})
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusAccepted && resp.body.Get("message").String() == "" {
+				t.Fatalf("POST /auth/password/forgot = %s, want the generic message", resp.body.Raw)
+			}
+			s.mail.waitAttempts(t, attempts+tc.wantAttempts)
+			if mails := s.mail.count(john.email, resetSubject); mails != tc.wantMails {
+				t.Fatalf("%d reset emails sent, want %d", mails, tc.wantMails)
+			}
+		})
+	}
+}
+
+func TestResetPasswordEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	const newPassword = "a brand new passphrase"
+	forgot := func() string {
+		attempts := s.mail.attemptCount()
+		s.request(t, fiber.MethodPost, "/auth/password/forgot", "", fiber.Map{"email": john.email}).expect(t, fiber.StatusAccepted, "")
+		s.mail.waitAttempts(t, attempts+1)
+		return s.mail.lastToken(t, john.email, resetSubject)
+	}
+
+	// A failed update has already consumed the token
+	failedToken := forgot()
+	s.repo.FailCalls("UpdateOneByID", errStorage)
+	s.request(t, fiber.MethodPost, "/auth/password/reset", "", fiber.Map{"token": failedToken, "password": newPassword}).expect(t, fiber.StatusInternalServerError, "")
+	s.repo.ResetFaults()
+	resetToken := forgot()
+
+	testCases := []struct {
+		name       string
+		token      string
+		password   string
+		fail       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "Missing Token", token: "", password: newPassword, wantStatus: fiber.StatusBadRequest},
+		{name: "Missing Password", token: resetToken, password: "", wantStatus: fiber.StatusBadRequest, wantCode: middleware.CodeValidationFailed},
+		{name: "Unknown Token", token: "unknown", password: newPassword, wantStatus: fiber.StatusBadRequest},
+		{name: "Consumed Token", token: failedToken, password: newPassword, wantStatus: fiber.StatusBadRequest},
+		{name: "Password Containing Username", token: resetToken, password: "john is my name", wantStatus: fiber.StatusBadRequest, wantCode: middleware.CodeValidationFailed},
+		{name: "Failed Consume", token: resetToken, password: newPassword, fail: "ConsumeOneTimeToken", wantStatus: fiber.StatusInternalServerError},
+		{name: "Valid Token", token: resetToken, password: newPassword, wantStatus: fiber.StatusOK},
+		{name: "Used Token", token: resetToken, password: newPassword, wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodPost, "/auth/password/reset", "", fiber.Map{"token": tc.token, "password": tc.password})
+			resp.expect(t, tc.wantStatus, tc.wantCode)
+		})
+	}
+
+	// The reset signs John out everywhere, only the new password works
+	s.request(t, fiber.MethodGet, "/auth/sessions", john.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+	s.login(t, john.email, newPassword).expect(t, fiber.StatusOK, "")
+}
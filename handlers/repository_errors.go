@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"errors"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+)
+
+// lookupError reports a failed repository lookup: 404 with notFound if the record does not exist and
+// 500 with failure otherwise, so an unreadable database is not mistaken for a missing record.
+func lookupError(appErrors *middleware.AppError, err error, notFound string, failure string) *middleware.Problem {
+	if errors.Is(err, local.ErrNotFound) {
+		return appErrors.NewNotFound(notFound)
+	}
+	return appErrors.NewInternalServerError(failure)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/id"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/token"
+	"go.uber.org/zap"
+)
+
+// newSession describes a brand-new session for the device the request comes from.
+func newSession(ctx *fiber.Ctx, userID string, device string) *model.Session {
+	now := time.Now()
+	return &model.Session{
+		ID:         id.GenerateUUID(),
+		UserID:     userID,
+		Device:     device,
+		UserAgent:  ctx.Get(fiber.HeaderUserAgent),
+		IP:         ctx.IP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+}
+
+// bindTokens attaches a freshly issued token pair to the session, extending its lifetime.
+func bindTokens(session *model.Session, accessToken string, refreshToken string) {
+	session.AccessTokenID = jwt.TokenID(accessToken)
+	session.RefreshTokenHash = token.Hash(refreshToken)
+	session.ExpiresAt = time.Now().Add(jwt.RefreshTokenTTL)
+}
+
+// revokeAccessToken puts an access token on the revocation list until it would have expired anyway.
+func revokeAccessToken(repo local.Repository, tokenID string) error {
+	if tokenID == "" {
+		return nil // No access token was ever issued.
+	}
+	return repo.RevokeAccessToken(tokenID, jwt.AccessTokenTTL)
+}
+
+// endSession signs a single device out: its access token is revoked and the session is deleted.
+func endSession(repo local.Repository, session *model.Session) error {
+	if err := revokeAccessToken(repo, session.AccessTokenID); err != nil {
+		return err
+	}
+	return repo.DeleteSession(session.UserID, session.ID)
+}
+
+// endAllSessions signs a user out on every device and revokes all outstanding access tokens.
+func endAllSessions(repo local.Repository, userID string) error {
+	sessions, err := repo.FindSessionsByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := revokeAccessToken(repo, session.AccessTokenID); err != nil {
+			return err
+		}
+	}
+	return repo.DeleteSessionsByUserID(userID)
+}
+
+// revokeTokenFamily signs out the session a reused refresh token belongs to and records the incident as a security event.
+func (handler *Auth) revokeTokenFamily(ctx *fiber.Ctx, userID string, sessionID string) {
+	handler.log.Warn("Security event: refresh token reuse detected, revoking token family",
+		zap.String("event", "refresh_token_reuse"),
+		zap.String("userID", userID),
+		zap.String("sessionID", sessionID),
+		zap.String("ip", ctx.IP()),
+		zap.String("userAgent", ctx.Get(fiber.HeaderUserAgent)),
+	)
+
+	// The family may already be gone if the reuse was detected before, which is fine.
+	session, err := handler.repo.FindSession(userID, sessionID)
+	if err != nil {
+		handler.log.Info("Token family already revoked", zap.String("sessionID", sessionID), zap.Error(err))
+		return
+	}
+	if err := endSession(handler.repo, session); err != nil {
+		handler.log.Error("Failed to revoke token family", zap.String("sessionID", sessionID), zap.Error(err))
+	}
+}
+
+// ToSessionResponse converts a Session model to a SessionResponse model
+func ToSessionResponse(session *model.Session, currentSessionID string) model.SessionResponse {
+	return model.SessionResponse{
+		ID:         session.ID,
+		Device:     session.Device,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+		Current:    session.ID == currentSessionID,
+	}
+}
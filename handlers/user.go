@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/mailer"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
@@ -18,18 +22,22 @@ type user struct {
 	repo        local.Repository
 	validate    validator.Validate
 	userService services.UserService
+	roles       services.RoleService
 	config      *AppConfig
+	mailer      mailer.Mailer
 	errors      middleware.AppError
 }
 
 // NewUser initializes a new user handler with dependencies.
-func NewUser(log *zap.Logger, repo local.Repository, validate validator.Validate, config *AppConfig, userService services.UserService, errors middleware.AppError) Handler {
+func NewUser(log *zap.Logger, repo local.Repository, validate validator.Validate, config *AppConfig, userService services.UserService, roles services.RoleService, mailer mailer.Mailer, errors middleware.AppError) Handler {
 	return &user{
 		log:         log,
 		repo:        repo,
 		validate:    validate,
 		config:      config,
 		userService: userService,
+		roles:       roles,
+		mailer:      mailer,
 		errors:      errors,
 	}
 }
@@ -41,13 +49,13 @@ func (handler *user) AssignEndpoints(prefix string, router fiber.Router) {
 	// Route for user creation, no JWT middleware here
 	r.Post("create", handler.createEndpoint) // POST /user/create: Creates a new user and returns JWT tokens.
 
-	// Routes that don't require authentication
-	r.Get("/search", handler.findByEmailEndpoint) // GET /user/search: Searches for a user by email.
-	r.Get(":id", handler.getEndpoint)             // GET /user/:id: Retrieves user information by ID.
-	r.Get("/", handler.getAllEndpoint)            // GET /user: Retrieves a list of all users.
-
 	// Routes that require JWT authentication
-	protectedRoutes := r.Group("/", middleware.JWTAuthMiddleware(handler.config.JWTSecret))
+	protectedRoutes := r.Group("/", middleware.JWTAuthMiddleware(handler.config.Keyring, handler.repo))
+
+	// Lookups only show what the caller may see, see Viewer
+	protectedRoutes.Get("/search", handler.findByEmailEndpoint) // GET /user/search: Searches for a user by email.
+	protectedRoutes.Get(":id", handler.getEndpoint)             // GET /user/:id: Retrieves user information by ID.
+	protectedRoutes.Get("/", handler.getAllEndpoint)            // GET /user: Retrieves a list of all users.
 
 	// These routes require the user to be authenticated (JWT)
 	protectedRoutes.Patch("update/:id", handler.updateEndpoint) // PATCH /user/update/:id: Updates user information.
@@ -55,6 +63,7 @@ func (handler *user) AssignEndpoints(prefix string, router fiber.Router) {
 }
 
 // createEndpoint handles user creation and returns JWT tokens upon success.
+// It backs both POST /user/create and POST /auth/register.
 func (handler *user) createEndpoint(c *fiber.Ctx) error {
 	user := new(model.User)
 
@@ -65,11 +74,20 @@ func (handler *user) createEndpoint(c *fiber.Ctx) error {
 	}
 
 	// Validate the user data using the ValidateUser method from the validator
-	isValid, validationErr := handler.validate.ValidateUser(user)
-	if !isValid {
-		handler.log.Error("Validation error", zap.String("error", validationErr))
-		return handler.errors.NewBadRequest("Validation error")
+	if fieldErrors := handler.validate.ValidateUser(user); len(fieldErrors) > 0 {
+		handler.log.Error("Validation error", zap.Any("errors", fieldErrors))
+		return handler.errors.NewValidationError(fieldErrors)
+	}
 
+	// Check the password against the password policy
+	fieldErrors, err := handler.validate.ValidatePassword(user.Password, user)
+	if err != nil {
+		handler.log.Error("Error checking password policy", zap.Error(err))
+		return handler.errors.NewInternalServerError("Error checking password")
+	}
+	if len(fieldErrors) > 0 {
+		handler.log.Error("Validation error", zap.Any("errors", fieldErrors))
+		return handler.errors.NewValidationError(fieldErrors)
 	}
 
 	// Check if email is already taken using the UserService
@@ -79,7 +97,17 @@ func (handler *user) createEndpoint(c *fiber.Ctx) error {
 		return handler.errors.NewInternalServerError("Error checking email")
 	}
 	if emailTaken {
-		return handler.errors.NewBadRequest("Email is taken")
+		return handler.errors.NewConflict("Email is taken").WithCode(middleware.CodeEmailTaken)
+	}
+
+	// Check if username is already taken using the UserService
+	usernameTaken, err := handler.userService.IsUsernameTaken(user.Username)
+	if err != nil {
+		handler.log.Error("Error checking username", zap.Error(err))
+		return handler.errors.NewInternalServerError("Error checking username")
+	}
+	if usernameTaken {
+		return handler.errors.NewConflict("Username is taken").WithCode(middleware.CodeUsernameTaken)
 	}
 
 	// Hash the user's password.
@@ -94,23 +122,45 @@ func (handler *user) createEndpoint(c *fiber.Ctx) error {
 	user.ID = id.GenerateUUID()
 
 	// Assign default role to the new user
-	user.Role = "user" // Default role assigned to new users
-
-	// Use the repository to create a new user
+	user.Role = handler.config.DefaultRole // Default role assigned to new users
+
+	// The address is unconfirmed until its owner follows the verification link
+	user.EmailVerified = false
+	user.PendingEmail = ""
+	user.LockedUntil = time.Time{}
+	user.Suspended = false
+	user.PasswordChangeRequired = false
+	user.CreatedAt = time.Now().UTC()
+	user.DeletedAt = time.Time{}
+
+	// Use the repository to create a new user, it refuses values another sign-up took in the meantime
 	if err := handler.repo.Create(user); err != nil {
+		var conflict *local.ConflictError
+		if errors.As(err, &conflict) {
+			return conflictError(&handler.errors, conflict)
+		}
 		handler.log.Error("Error creating user", zap.Error(err))
 		return handler.errors.NewInternalServerError("Could not create user")
 	}
 
-	// Create JWT token with user ID, username, and role.
-	accessToken, refreshToken, err := jwt.GenerateTokens(user.ID, user.Username, user.Role, handler.config.JWTSecret)
+	// Send the verification link to the new address, the user can ask for another one if this fails
+	if err := sendVerificationEmail(handler.repo, handler.mailer, handler.config, user, user.Email); err != nil {
+		handler.log.Error("Failed to send verification email", zap.Error(err))
+	}
+
+	// Open the first session for the device the user signed up from.
+	session := newSession(c, user.ID, "")
+
+	// Create JWT token with user ID, username, role, and session.
+	accessToken, refreshToken, err := jwt.GenerateTokens(user.ID, user.Username, user.Role, session.ID, handler.config.Keyring)
 	if err != nil {
 		handler.log.Error("Failed to generate tokens", zap.Error(err))
 		return handler.errors.NewInternalServerError("Failed to generate tokens")
 	}
 
-	// Save the refresh token in the database
-	if err := handler.repo.SaveRefreshToken(user.ID, refreshToken); err != nil {
+	// Save the session and its refresh token in the database
+	bindTokens(session, accessToken, refreshToken)
+	if err := handler.repo.SaveSession(session); err != nil {
 		handler.log.Error("Failed to save refresh token", zap.Error(err))
 		return handler.errors.NewInternalServerError("Failed to save refresh token")
 	}
@@ -128,10 +178,22 @@ func (handler *user) getEndpoint(c *fiber.Ctx) error {
 	userID := c.Params("id")
 	handler.log.Info("UserID param:", zap.String("userID", userID))
 
+	// Find out what the caller may see.
+	viewer, err := handler.viewer(c)
+	if err != nil {
+		return err
+	}
+
 	// Get user data from database.
 	user, err := handler.repo.FindOneByID(userID)
 	if err != nil {
 		handler.log.Error("User not found in database", zap.Error(err))
+		return lookupError(&handler.errors, err, "User not found", "Could not fetch user")
+	}
+
+	// Users the caller may not see are reported as missing, so their existence is not revealed.
+	visibility := viewer.Visibility(user)
+	if visibility == HiddenUser {
 		return handler.errors.NewNotFound("User not found")
 	}
 
@@ -141,34 +203,57 @@ func (handler *user) getEndpoint(c *fiber.Ctx) error {
 		return handler.errors.NewInternalServerError("User found but fields are empty")
 	}
 
-	userResponse := ToResponseUser(user)
+	userResponse := ToVisibleUser(user, visibility)
 
 	handler.log.Info("User found:", zap.String("username", user.Username), zap.String("email", user.Email))
 
+	// The version is sent as ETag, so it can be used in If-Match of the next update.
+	c.Set(fiber.HeaderETag, userETag(user))
 	return c.Status(fiber.StatusOK).JSON(userResponse)
 }
 
-// getAllEndpoint retrieves all users from the database.
+// getAllEndpoint retrieves a page of users from the database, filtered and sorted by the query parameters.
 func (handler *user) getAllEndpoint(c *fiber.Ctx) error {
-	// Take users from database
-	users, err := handler.repo.FindAll()
+	// Find out what the caller may see.
+	viewer, err := handler.viewer(c)
+	if err != nil {
+		return err
+	}
+
+	// Listing other users requires at least the users:read permission.
+	if !viewer.ReadProfiles && !viewer.ReadAll {
+		return handler.errors.NewForbidden("You are not authorized to list users")
+	}
+
+	// Reading the paging, filter and sort parameters.
+	query, problem := parseUserQuery(c)
+	if problem != "" {
+		return handler.errors.NewBadRequest(problem)
+	}
+
+	// Role and age are not part of the public profile, so filtering by them would reveal them.
+	if !viewer.ReadAll && (query.Role != "" || query.MinAge > 0 || query.MaxAge > 0) {
+		return handler.errors.NewForbidden("Filtering by role or age requires the users:read:any permission")
+	}
+
+	// Take the page of users from database
+	page, err := handler.repo.FindUsers(query)
+	if errors.Is(err, local.ErrInvalidCursor) {
+		return handler.errors.NewBadRequest("Invalid cursor, it belongs to another sort order")
+	}
 	if err != nil {
 		handler.log.Error("Error fetching users from database", zap.Error(err))
 		return handler.errors.NewInternalServerError("Could not fetch users from database")
 	}
 
 	// If there is no user then empty slice returned
-	if len(users) == 0 {
-		handler.log.Info("No users found in the database")
-		return c.Status(fiber.StatusOK).JSON([]model.UserResponse{})
-	}
-
-	userResponses := make([]model.UserResponse, len(users))
-	for i, user := range users {
-		userResponses[i] = ToResponseUser(user)
+	userResponses := make([]interface{}, 0, len(page.Users))
+	for _, user := range page.Users {
+		userResponses = append(userResponses, ToVisibleUser(user, viewer.Visibility(user)))
 	}
 
-	handler.log.Info("All users fetched successfully")
+	setPageHeaders(c, query, page)
+	handler.log.Info("Users fetched successfully", zap.Int("count", len(userResponses)), zap.Int("total", page.Total))
 	return c.Status(fiber.StatusOK).JSON(userResponses)
 }
 
@@ -181,11 +266,24 @@ func (handler *user) updateEndpoint(c *fiber.Ctx) error {
 	tokenUserID := c.Locals("user_id").(string)
 	handler.log.Info("UserID param:", zap.String("userID", userID))
 
-	// Checking if the user is authorized to update only their own data.
+	// Users may update their own data, updating someone else's requires the users:write:any permission.
 	if tokenUserID != userID {
-		// If the user tries to update someone else's data, return an unauthorized response.
-		return handler.errors.NewUnauthorized("You are not authorized to update this user")
+		allowed, err := hasPermission(handler.roles, c, model.PermissionUsersWriteAny)
+		if err != nil {
+			handler.log.Error("Error checking permissions", zap.Error(err))
+			return handler.errors.NewInternalServerError("Error checking permissions")
+		}
+		if !allowed {
+			// If the user tries to update someone else's data, return a forbidden response.
+			return handler.errors.NewForbidden("You are not authorized to update this user")
+		}
+		handler.log.Info("Updating another user", zap.String("userID", userID), zap.String("actorID", tokenUserID))
+	}
 
+	// A client may ask to update only the version it read, so concurrent changes are not overwritten.
+	expectedVersion, err := parseIfMatch(c.Get(fiber.HeaderIfMatch))
+	if err != nil {
+		return handler.errors.NewBadRequest("If-Match must be a single ETag returned for this user")
 	}
 
 	// Parsing the update data from the request body.
@@ -196,24 +294,174 @@ func (handler *user) updateEndpoint(c *fiber.Ctx) error {
 		return handler.errors.NewBadRequest("Error parsing update data")
 	}
 
-	// Attempting to update the user's data in the database.
-	err := handler.repo.UpdateOneByID(userID, updateData)
+	// Validating the fields being updated with the same rules as registration.
+	if fieldErrors := handler.validate.ValidateUserUpdate(updateData); len(fieldErrors) > 0 {
+		handler.log.Error("Validation error", zap.Any("errors", fieldErrors))
+		return handler.errors.NewValidationError(fieldErrors)
+	}
+
+	// A new password is checked against the policy with the username and email address it will live alongside.
+	if updateData.Password != "" {
+		if err := handler.checkPasswordPolicy(userID, updateData); err != nil {
+			return err
+		}
+	}
+
+	// A new email address only takes effect once it is confirmed, so it is held back as pending.
+	changeEmail := updateData.Email != ""
+	var pendingEmail string
+	if changeEmail {
+		if pendingEmail, err = handler.pendingEmail(userID, updateData.Email); err != nil {
+			return err
+		}
+	}
+	updateData.Email = ""
+
+	// Hashing the new password before the write, because it is slow.
+	var hashedPassword string
+	if updateData.Password != "" {
+		if hashedPassword, err = password.HashPassword(updateData.Password); err != nil {
+			handler.log.Error("Error hashing password", zap.Error(err))
+			return handler.errors.NewInternalServerError("Error updating user")
+		}
+	}
+
+	// Attempting to update the user's data and the pending email address in the database in one write.
+	user, err := handler.repo.ModifyOneByID(userID, func(user *model.User) error {
+		if expectedVersion != local.AnyVersion && user.Version != expectedVersion {
+			return local.ErrStaleVersion
+		}
+		user.UpdateFields(updateData)
+		if hashedPassword != "" {
+			user.Password = hashedPassword
+		}
+		if changeEmail {
+			user.PendingEmail = pendingEmail
+		}
+		return nil
+	})
+	if errors.Is(err, local.ErrStaleVersion) {
+		// If the user changed since the client read it, return a precondition failed response.
+		return handler.errors.NewPreconditionFailed("User was modified since it was read")
+	}
+	var conflict *local.ConflictError
+	if errors.As(err, &conflict) {
+		// If the new username belongs to someone else, return a conflict response.
+		return conflictError(&handler.errors, conflict)
+	}
+	if errors.Is(err, local.ErrNotFound) {
+		// If the user was deleted in the meantime, return a not found response.
+		return handler.errors.NewNotFound("User not found")
+	}
 	if err != nil {
 		// If the update operation fails, return an internal server error response.
 		handler.log.Error("Error updating user", zap.Error(err))
 		return handler.errors.NewInternalServerError("Error updating user")
 	}
 
+	// A password change signs the user out everywhere, including outstanding access tokens.
+	if updateData.Password != "" {
+		if err := endAllSessions(handler.repo, userID); err != nil {
+			handler.log.Error("Error revoking sessions after password change", zap.Error(err))
+			return handler.errors.NewInternalServerError("Error revoking sessions")
+		}
+	}
+
+	// The verification link is only sent once the pending address is stored.
+	if pendingEmail != "" {
+		if err := sendVerificationEmail(handler.repo, handler.mailer, handler.config, user, pendingEmail); err != nil {
+			handler.log.Error("Failed to send verification email", zap.Error(err))
+			return handler.errors.NewInternalServerError("Failed to send verification email")
+		}
+	}
+
 	// Logging the success of the update operation.
 	handler.log.Info("User updated successfully", zap.String("userID", userID))
 
-	// Returning a success response after the update is completed.
+	// Returning a success response after the update is completed, with the tag of the new version.
+	c.Set(fiber.HeaderETag, userETag(user))
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "User updated successfully",
-		"user_id": userID,
+		"message":       "User updated successfully",
+		"user_id":       userID,
+		"pending_email": pendingEmail,
 	})
 }
 
+// conflictError reports a uniqueness conflict of the repository like the checks above do.
+func conflictError(appErrors *middleware.AppError, conflict *local.ConflictError) *middleware.Problem {
+	if conflict.Field == "username" {
+		return appErrors.NewConflict("Username is taken").WithCode(middleware.CodeUsernameTaken)
+	}
+	return appErrors.NewConflict("Email is taken").WithCode(middleware.CodeEmailTaken)
+}
+
+// viewer describes the authenticated caller for the visibility policy.
+func (handler *user) viewer(c *fiber.Ctx) (Viewer, error) {
+	userID, _ := c.Locals("user_id").(string)
+	readAny, err := hasPermission(handler.roles, c, model.PermissionUsersReadAny)
+	if err != nil {
+		handler.log.Error("Error checking permissions", zap.Error(err))
+		return Viewer{}, handler.errors.NewInternalServerError("Error checking permissions")
+	}
+	read, err := hasPermission(handler.roles, c, model.PermissionUsersRead)
+	if err != nil {
+		handler.log.Error("Error checking permissions", zap.Error(err))
+		return Viewer{}, handler.errors.NewInternalServerError("Error checking permissions")
+	}
+	return Viewer{UserID: userID, ReadProfiles: read, ReadAll: readAny}, nil
+}
+
+// checkPasswordPolicy validates the new password of an update against the password policy.
+func (handler *user) checkPasswordPolicy(userID string, updateData *model.User) error {
+	current, err := handler.repo.FindOneByID(userID)
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err))
+		return lookupError(&handler.errors, err, "User not found", "Error finding user")
+	}
+	identity := &model.User{Username: current.Username, Email: current.Email}
+	if updateData.Username != "" {
+		identity.Username = updateData.Username
+	}
+	if updateData.Email != "" {
+		identity.Email = updateData.Email
+	}
+
+	fieldErrors, err := handler.validate.ValidatePassword(updateData.Password, identity)
+	if err != nil {
+		handler.log.Error("Error checking password policy", zap.Error(err))
+		return handler.errors.NewInternalServerError("Error checking password")
+	}
+	if len(fieldErrors) > 0 {
+		handler.log.Error("Validation error", zap.Any("errors", fieldErrors))
+		return handler.errors.NewValidationError(fieldErrors)
+	}
+	return nil
+}
+
+// pendingEmail checks a requested email address and returns the address that will await confirmation,
+// which is empty when the request changes back to the current address and so cancels a pending change.
+func (handler *user) pendingEmail(userID string, email string) (string, error) {
+	user, err := handler.repo.FindOneByID(userID)
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err))
+		return "", lookupError(&handler.errors, err, "User not found", "Error finding user")
+	}
+	if email == user.Email {
+		return "", nil
+	}
+
+	// Checking if the new email address is already taken.
+	emailTaken, err := handler.userService.IsEmailTaken(email)
+	if err != nil {
+		handler.log.Error("Error checking email", zap.Error(err))
+		return "", handler.errors.NewInternalServerError("Error checking email")
+	}
+	if emailTaken {
+		return "", handler.errors.NewConflict("Email is taken").WithCode(middleware.CodeEmailTaken)
+	}
+	return email, nil
+}
+
 // deleteEndpoint allows a user to delete their own account if authorized.
 func (handler *user) deleteEndpoint(c *fiber.Ctx) error {
 	// Receiving the user ID from the request parameters (URL).
@@ -222,10 +470,18 @@ func (handler *user) deleteEndpoint(c *fiber.Ctx) error {
 	// Extracting the user ID from the JWT token (authenticated user).
 	tokenUserID := c.Locals("user_id").(string)
 
-	// Checking if the user is authorized to delete only their own data.
+	// Users may delete themselves, deleting someone else requires the users:delete:any permission.
 	if tokenUserID != userID {
-		// If the user tries to delete someone else's data, return an unauthorized response.
-		return handler.errors.NewUnauthorized("You are not authorized to delete this user")
+		allowed, err := hasPermission(handler.roles, c, model.PermissionUsersDeleteAny)
+		if err != nil {
+			handler.log.Error("Error checking permissions", zap.Error(err))
+			return handler.errors.NewInternalServerError("Error checking permissions")
+		}
+		if !allowed {
+			// If the user tries to delete someone else's data, return a forbidden response.
+			return handler.errors.NewForbidden("You are not authorized to delete this user")
+		}
+		handler.log.Info("Deleting another user", zap.String("userID", userID), zap.String("actorID", tokenUserID))
 	}
 
 	// Checking if the user exists in the database before attempting to delete.
@@ -233,10 +489,11 @@ func (handler *user) deleteEndpoint(c *fiber.Ctx) error {
 	if err != nil {
 		// If the user is not found, return a not found response.
 		handler.log.Error("User not found", zap.Error(err))
-		return handler.errors.NewNotFound("User not found")
+		return lookupError(&handler.errors, err, "User not found", "Error finding user")
 	}
 
-	// Deleting the user from the database.
+	// Deleting the user, which signs it out everywhere and hides it until it is restored or, once the
+	// restore window has passed, purged together with its second factor and login history.
 	if err := handler.repo.DeleteOneByID(userID); err != nil {
 		// If the delete operation fails, return an internal server error response.
 		handler.log.Error("Error deleting user", zap.Error(err))
@@ -248,12 +505,13 @@ func (handler *user) deleteEndpoint(c *fiber.Ctx) error {
 
 	// Returning a success response after the delete is completed.
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "User deleted successfully",
-		"user_id": userID,
+		"message":          "User deleted successfully",
+		"user_id":          userID,
+		"restorable_until": time.Now().UTC().Add(handler.config.UserRestoreWindow),
 	})
 }
 
-// findByEmailEndpoint allows searching for a user by email.
+// findByEmailEndpoint allows searching for a user by email. It requires users:read:any, except for one's own address.
 func (handler *user) findByEmailEndpoint(c *fiber.Ctx) error {
 	// Get query parameter for email
 	email := c.Query("email")
@@ -273,15 +531,28 @@ func (handler *user) findByEmailEndpoint(c *fiber.Ctx) error {
 	// Verifying the email through logging
 	handler.log.Info("Searching for user with email:", zap.String("email", email))
 
+	// Find out what the caller may see.
+	viewer, err := handler.viewer(c)
+	if err != nil {
+		return err
+	}
+
 	// Search the user in the database using UserService
 	user, err := handler.userService.FindByEmail(email)
 	if err != nil {
 		handler.log.Error("User not found by email", zap.String("email", email), zap.Error(err))
+		return lookupError(&handler.errors, err, "User not found", "Could not fetch user")
+	}
+
+	// The email address is not part of the public profile, so only callers who may see it find the user.
+	// Everybody else gets the same answer as for an unknown address, so addresses cannot be mapped to users.
+	visibility := viewer.Visibility(user)
+	if visibility != FullProfile {
 		return handler.errors.NewNotFound("User not found")
 	}
 
 	// User found, create response
-	userResponse := ToResponseUser(user)
+	userResponse := ToVisibleUser(user, visibility)
 
 	handler.log.Info("User found by email", zap.String("email", email))
 	return c.Status(fiber.StatusOK).JSON(userResponse)
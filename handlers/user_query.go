@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// Page sizes of GET /user.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// parseUserQuery reads the paging, filter and sort parameters of GET /user.
+// The returned message explains the first invalid parameter, it is empty when all are valid.
+func parseUserQuery(c *fiber.Ctx) (model.UserQuery, string) {
+	query := model.UserQuery{
+		Role:           c.Query("role"),
+		NamePrefix:     c.Query("name"),
+		UsernamePrefix: c.Query("username"),
+		Cursor:         c.Query("cursor"),
+		Limit:          defaultPageLimit,
+		SortBy:         model.SortByCreatedAt,
+	}
+
+	// Counting visits every user, so the total is only sent with the first page
+	query.CountTotal = query.Cursor == ""
+
+	ints := []struct {
+		name   string
+		target *int
+		min    int
+		max    int
+	}{
+		{name: "limit", target: &query.Limit, min: 1, max: maxPageLimit},
+		{name: "min_age", target: &query.MinAge, min: 0, max: 150},
+		{name: "max_age", target: &query.MaxAge, min: 0, max: 150},
+	}
+	for _, param := range ints {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < param.min || n > param.max {
+			return query, param.name + " must be a number between " + strconv.Itoa(param.min) + " and " + strconv.Itoa(param.max)
+		}
+		*param.target = n
+	}
+	if query.MaxAge > 0 && query.MaxAge < query.MinAge {
+		return query, "max_age must not be less than min_age"
+	}
+
+	// sort=username sorts ascending, sort=-username descending
+	if sort := c.Query("sort"); sort != "" {
+		query.Descending = strings.HasPrefix(sort, "-")
+		query.SortBy = strings.TrimPrefix(sort, "-")
+		switch query.SortBy {
+		case model.SortByCreatedAt, model.SortByUsername, model.SortByLastname:
+		default:
+			return query, "sort must be one of created_at, username or lastname, optionally prefixed with -"
+		}
+	}
+	return query, ""
+}
+
+// setPageHeaders adds the total count, if it was counted, and the Link header (RFC 8288) pointing at the
+// first and next pages.
+func setPageHeaders(c *fiber.Ctx, query model.UserQuery, page *model.UserPage) {
+	if query.CountTotal {
+		c.Set("X-Total-Count", strconv.Itoa(page.Total))
+	}
+
+	links := []string{`<` + pageLink(c, "") + `>; rel="first"`}
+	if page.Next != "" {
+		links = append(links, `<`+pageLink(c, page.Next)+`>; rel="next"`)
+	}
+	c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+}
+
+// pageLink builds the URL of the current request with its cursor replaced, an empty cursor links the first page.
+func pageLink(c *fiber.Ctx, cursor string) string {
+	values, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	values.Del("cursor")
+	if cursor != "" {
+		values.Set("cursor", cursor)
+	}
+	link := c.BaseURL() + c.Path()
+	if encoded := values.Encode(); encoded != "" {
+		link += "?" + encoded
+	}
+	return link
+}
@@ -4,6 +4,57 @@ import (
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
 )
 
+// UserVisibility is how much of a user a viewer may see.
+type UserVisibility int
+
+const (
+	HiddenUser    UserVisibility = iota // The viewer may not see the user at all
+	PublicProfile                       // Only the public profile, see PublicUserResponse
+	FullProfile                         // Every field of UserResponse
+)
+
+// Viewer is the authenticated caller a user is shown to, together with the permissions that decide visibility.
+type Viewer struct {
+	UserID       string // ID of the caller
+	ReadProfiles bool   // users:read, the caller may see public profiles of other users
+	ReadAll      bool   // users:read:any, the caller may see every field of every user
+}
+
+// Visibility is the visibility policy: owners and callers with users:read:any see everything,
+// callers with users:read see the public profile of others, everybody else sees nothing.
+func (viewer Viewer) Visibility(user *model.User) UserVisibility {
+	switch {
+	case viewer.UserID != "" && viewer.UserID == user.ID:
+		return FullProfile
+	case viewer.ReadAll:
+		return FullProfile
+	case viewer.ReadProfiles:
+		return PublicProfile
+	}
+	return HiddenUser
+}
+
+// ToVisibleUser converts a User model to the response matching a visibility. Hidden users convert to nil.
+func ToVisibleUser(user *model.User, visibility UserVisibility) interface{} {
+	switch visibility {
+	case FullProfile:
+		return ToResponseUser(user)
+	case PublicProfile:
+		return ToPublicUser(user)
+	}
+	return nil
+}
+
+// ToPublicUser converts a User model to the public profile other users may see.
+func ToPublicUser(user *model.User) model.PublicUserResponse {
+	return model.PublicUserResponse{
+		ID:       user.ID,
+		Username: user.Username,
+		Name:     user.Name,
+		Lastname: user.Lastname,
+	}
+}
+
 // ToResponseUser converts a User model to a UserResponse model
 func ToResponseUser(user *model.User) model.UserResponse {
 	return model.UserResponse{
@@ -13,6 +64,30 @@ func ToResponseUser(user *model.User) model.UserResponse {
 		Lastname: user.Lastname,
 		Email:    user.Email,
 		Age:      user.Age,
+
+		EmailVerified: user.EmailVerified,
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
+// ToAdminUserResponse converts a User model to the full representation shown to administrators.
+func ToAdminUserResponse(user *model.User) model.AdminUserResponse {
+	return model.AdminUserResponse{
+		ID:       user.ID,
+		Username: user.Username,
+		Name:     user.Name,
+		Lastname: user.Lastname,
+		Email:    user.Email,
+		Age:      user.Age,
+		Role:     user.Role,
+
+		EmailVerified:          user.EmailVerified,
+		PendingEmail:           user.PendingEmail,
+		LockedUntil:            user.LockedUntil,
+		Suspended:              user.Suspended,
+		PasswordChangeRequired: user.PasswordChangeRequired,
+		CreatedAt:              user.CreatedAt,
+		DeletedAt:              user.DeletedAt,
 	}
 }
 
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+func TestViewerVisibility(t *testing.T) {
+	user := &model.User{ID: "user123"}
+
+	testCases := []struct {
+		name   string
+		viewer Viewer
+		want   UserVisibility
+	}{
+		{name: "Owner Without Permissions", viewer: Viewer{UserID: "user123"}, want: FullProfile},
+		{name: "Owner With Read Profiles", viewer: Viewer{UserID: "user123", ReadProfiles: true}, want: FullProfile},
+		{name: "Other With Read All", viewer: Viewer{UserID: "user456", ReadAll: true}, want: FullProfile},
+		{name: "Other With Read Profiles", viewer: Viewer{UserID: "user456", ReadProfiles: true}, want: PublicProfile},
+		{name: "Other Without Permissions", viewer: Viewer{UserID: "user456"}, want: HiddenUser},
+		{name: "Anonymous", viewer: Viewer{}, want: HiddenUser},
+		{name: "Anonymous With Read Profiles", viewer: Viewer{ReadProfiles: true}, want: PublicProfile},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.viewer.Visibility(user); got != tc.want {
+				t.Fatalf("Visibility() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// An empty user ID never matches a user without one
+	if got := (Viewer{}).Visibility(&model.User{}); got != HiddenUser {
+		t.Fatalf("Visibility() of a user without ID = %v, want HiddenUser", got)
+	}
+}
+
+func TestToVisibleUser(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := &model.User{
+		ID: "user123", Username: "john", Email: "john@example.com", Password: "hash", Name: "John", Lastname: "Doe",
+		Age: 30, Role: "admin", EmailVerified: true, CreatedAt: createdAt,
+	}
+
+	testCases := []struct {
+		name       string
+		visibility UserVisibility
+		want       interface{}
+	}{
+		{
+			name:       "Full Profile",
+			visibility: FullProfile,
+			want: model.UserResponse{
+				ID: "user123", Username: "john", Email: "john@example.com", Name: "John", Lastname: "Doe",
+				Age: 30, EmailVerified: true, CreatedAt: createdAt,
+			},
+		},
+		{
+			name:       "Public Profile",
+			visibility: PublicProfile,
+			want:       model.PublicUserResponse{ID: "user123", Username: "john", Name: "John", Lastname: "Doe"},
+		},
+		{
+			name:       "Hidden User",
+			visibility: HiddenUser,
+			want:       nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ToVisibleUser(user, tc.visibility); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("ToVisibleUser() = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+func TestCreateEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	body := fiber.Map{"username": "john", "email": "john@example.com", "name": "John", "lastname": "Doe", "age": 30, "password": testPassword}
+
+	resp := s.request(t, fiber.MethodPost, "/user/create", "", body)
+	resp.expect(t, fiber.StatusCreated, "")
+	if resp.body.Get("id").String() == "" || resp.body.Get("access_token").String() == "" {
+		t.Fatalf("POST /user/create = %s, want the user and its tokens", resp.body.Raw)
+	}
+	if resp.body.Get("password").Exists() {
+		t.Fatalf("POST /user/create returned the password")
+	}
+	s.request(t, fiber.MethodPost, "/user/create", "", body).expect(t, fiber.StatusConflict, middleware.CodeEmailTaken)
+}
+
+func TestGetEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	jane := s.register(t, "jane")
+	admin := s.registerAdmin(t, "admin")
+	if err := s.repo.SaveRole(&model.Role{Name: "guest"}); err != nil {
+		t.Fatalf("SaveRole() error = %v", err)
+	}
+	guest := s.setRole(t, s.register(t, "guest"), "guest")
+
+	testCases := []struct {
+		name       string
+		userID     string
+		token      string
+		fail       string
+		wantStatus int
+		wantEmail  bool // Whether the full profile including the email address is returned
+	}{
+		{name: "Own Profile", userID: john.id, token: john.accessToken, wantStatus: fiber.StatusOK, wantEmail: true},
+		{name: "Public Profile", userID: john.id, token: jane.accessToken, wantStatus: fiber.StatusOK},
+		{name: "Administrator", userID: john.id, token: admin.accessToken, wantStatus: fiber.StatusOK, wantEmail: true},
+		{name: "Without Permission", userID: john.id, token: guest.accessToken, wantStatus: fiber.StatusNotFound},
+		{name: "Unknown User", userID: "unknown", token: admin.accessToken, wantStatus: fiber.StatusNotFound},
+		{name: "Failed Lookup", userID: john.id, token: john.accessToken, fail: "FindOneByID", wantStatus: fiber.StatusInternalServerError},
+		{name: "Failed Permission Check", userID: john.id, token: jane.accessToken, fail: "FindRole", wantStatus: fiber.StatusInternalServerError},
+		{name: "Missing Token", userID: john.id, token: "", wantStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodGet, "/user/"+tc.userID, tc.token, nil)
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus != fiber.StatusOK {
+				return
+			}
+			if resp.body.Get("email").Exists() != tc.wantEmail {
+				t.Fatalf("GET /user/%s = %s, want email %v", tc.userID, resp.body.Raw, tc.wantEmail)
+			}
+			if resp.header.Get(fiber.HeaderETag) == "" {
+				t.Fatalf("GET /user/%s has no ETag", tc.userID)
+			}
+		})
+	}
+}
+
+func TestGetAllEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	s.register(t, "jane")
+	s.register(t, "jack")
+	if err := s.repo.SaveRole(&model.Role{Name: "guest"}); err != nil {
+		t.Fatalf("SaveRole() error = %v", err)
+	}
+	guest := s.setRole(t, s.register(t, "guest"), "guest")
+	admin := s.registerAdmin(t, "admin")
+
+	testCases := []struct {
+		name       string
+		query      string
+		token      string
+		fail       string
+		wantStatus int
+		wantUsers  []string // Usernames on the page, in order
+		wantTotal  int
+		wantNext   bool
+	}{
+		{name: "Sorted By Username", query: "?sort=username", token: john.accessToken, wantStatus: fiber.StatusOK, wantUsers: []string{"admin", "guest", "jack", "jane", "john"}, wantTotal: 5},
+		{name: "Descending", query: "?sort=-username&limit=2", token: john.accessToken, wantStatus: fiber.StatusOK, wantUsers: []string{"john", "jane"}, wantTotal: 5, wantNext: true},
+		{name: "Filtered", query: "?username=ja&sort=username", token: john.accessToken, wantStatus: fiber.StatusOK, wantUsers: []string{"jack", "jane"}, wantTotal: 2},
+		{name: "Role Filter Without Permission", query: "?role=admin", token: john.accessToken, wantStatus: fiber.StatusForbidden},
+		{name: "Age Filter Without Permission", query: "?min_age=30&max_age=30", token: john.accessToken, wantStatus: fiber.StatusForbidden},
+		{name: "Role Filter", query: "?role=admin", token: admin.accessToken, wantStatus: fiber.StatusOK, wantUsers: []string{"admin"}, wantTotal: 1},
+		{name: "Age Filter", query: "?min_age=31&sort=username", token: admin.accessToken, wantStatus: fiber.StatusOK, wantUsers: []string{}, wantTotal: 0},
+		{name: "Invalid Limit", query: "?limit=0", token: john.accessToken, wantStatus: fiber.StatusBadRequest},
+		{name: "Invalid Sort", query: "?sort=age", token: john.accessToken, wantStatus: fiber.StatusBadRequest},
+		{name: "Invalid Cursor", query: "?cursor=invalid", token: john.accessToken, wantStatus: fiber.StatusBadRequest},
+		{name: "Without Permission", query: "", token: guest.accessToken, wantStatus: fiber.StatusForbidden},
+		{name: "Failed Query", query: "", token: john.accessToken, fail: "FindUsers", wantStatus: fiber.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodGet, "/user"+tc.query, tc.token, nil)
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus != fiber.StatusOK {
+				return
+			}
+			var usernames []string
+			for _, user := range resp.body.Array() {
+				usernames = append(usernames, user.Get("username").String())
+			}
+			if len(usernames) != len(tc.wantUsers) {
+				t.Fatalf("GET /user%s = %v, want %v", tc.query, usernames, tc.wantUsers)
+			}
+			for i := range usernames {
+				if usernames[i] != tc.wantUsers[i] {
+					t.Fatalf("GET /user%s = %v, want %v", tc.query, usernames, tc.wantUsers)
+				}
+			}
+			if total := resp.header.Get("X-Total-Count"); total != strconv.Itoa(tc.wantTotal) {
+				t.Fatalf("X-Total-Count = %s, want %d", total, tc.wantTotal)
+			}
+			if hasNext := strings.Contains(resp.header.Get(fiber.HeaderLink), `rel="next"`); hasNext != tc.wantNext {
+				t.Fatalf("Link = %q, want next page %v", resp.header.Get(fiber.HeaderLink), tc.wantNext)
+			}
+		})
+	}
+	// Later pages are not counted again
+	link := s.request(t, fiber.MethodGet, "/user?limit=2", john.accessToken, nil).header.Get(fiber.HeaderLink)
+	next := link[strings.LastIndex(link, "<")+1 : strings.LastIndex(link, ">")]
+	resp := s.request(t, fiber.MethodGet, next[strings.Index(next, "/user"):], john.accessToken, nil)
+	resp.expect(t, fiber.StatusOK, "")
+	if len(resp.body.Array()) != 2 || resp.header.Get("X-Total-Count") != "" {
+		t.Fatalf("second page = %s with X-Total-Count %q, want 2 users and no count", resp.body.Raw, resp.header.Get("X-Total-Count"))
+	}
+}
+
+func TestFindByEmailEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	jane := s.register(t, "jane")
+	admin := s.registerAdmin(t, "admin")
+
+	testCases := []struct {
+		name       string
+		email      string
+		token      string
+		fail       string
+		wantStatus int
+		wantUser   string
+	}{
+		{name: "Missing Email", email: "", token: admin.accessToken, wantStatus: fiber.StatusBadRequest},
+		{name: "Invalid Email", email: "john", token: admin.accessToken, wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown Email", email: "nobody@example.com", token: admin.accessToken, wantStatus: fiber.StatusNotFound},
+		{name: "Failed Lookup", email: jane.email, token: admin.accessToken, fail: "FindOneByEmail", wantStatus: fiber.StatusInternalServerError},
+		{name: "Email Of Another User", email: jane.email, token: john.accessToken, wantStatus: fiber.StatusNotFound},
+		{name: "Own Email", email: john.email, token: john.accessToken, wantStatus: fiber.StatusOK, wantUser: john.username},
+		{name: "Administrator", email: jane.email, token: admin.accessToken, wantStatus: fiber.StatusOK, wantUser: jane.username},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodGet, "/user/search?email="+tc.email, tc.token, nil)
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusOK && resp.body.Get("username").String() != tc.wantUser {
+				t.Fatalf("GET /user/search = %s, want %s", resp.body.Raw, tc.wantUser)
+			}
+		})
+	}
+}
+
+func TestUpdateEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	jane := s.register(t, "jane")
+	admin := s.registerAdmin(t, "admin")
+
+	etag := s.request(t, fiber.MethodGet, "/user/"+john.id, john.accessToken, nil).header.Get(fiber.HeaderETag)
+
+	testCases := []struct {
+		name       string
+		userID     string
+		token      string
+		body       fiber.Map
+		ifMatch    string
+		fail       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "Another User", userID: jane.id, token: john.accessToken, body: fiber.Map{"name": "Janet"}, wantStatus: fiber.StatusForbidden},
+		{name: "Malformed If-Match", userID: john.id, token: john.accessToken, body: fiber.Map{"name": "Johnny"}, ifMatch: "W/" + etag, wantStatus: fiber.StatusBadRequest},
+		{name: "Invalid Age", userID: john.id, token: john.accessToken, body: fiber.Map{"age": 200}, wantStatus: fiber.StatusBadRequest, wantCode: middleware.CodeValidationFailed},
+		{name: "Taken Username", userID: john.id, token: john.accessToken, body: fiber.Map{"username": "jane"}, wantStatus: fiber.StatusConflict, wantCode: middleware.CodeUsernameTaken},
+		{name: "Taken Email", userID: john.id, token: john.accessToken, body: fiber.Map{"email": jane.email}, wantStatus: fiber.StatusConflict, wantCode: middleware.CodeEmailTaken},
+		{name: "Failed Update", userID: john.id, token: john.accessToken, body: fiber.Map{"name": "Johnny"}, fail: "ModifyOneByID", wantStatus: fiber.StatusInternalServerError},
+		{name: "Current Version", userID: john.id, token: john.accessToken, body: fiber.Map{"name": "Johnny"}, ifMatch: etag, wantStatus: fiber.StatusOK},
+		{name: "Stale Version", userID: john.id, token: john.accessToken, body: fiber.Map{"name": "Jonathan"}, ifMatch: etag, wantStatus: fiber.StatusPreconditionFailed},
+		{name: "Administrator", userID: jane.id, token: admin.accessToken, body: fiber.Map{"name": "Janet"}, wantStatus: fiber.StatusOK},
+		{name: "Deleted User", userID: "unknown", token: admin.accessToken, body: fiber.Map{"name": "Nobody"}, wantStatus: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			var headers []string
+			if tc.ifMatch != "" {
+				headers = []string{fiber.HeaderIfMatch, tc.ifMatch}
+			}
+			resp := s.request(t, fiber.MethodPatch, "/user/update/"+tc.userID, tc.token, tc.body, headers...)
+			resp.expect(t, tc.wantStatus, tc.wantCode)
+			if tc.wantStatus == fiber.StatusOK && resp.header.Get(fiber.HeaderETag) == etag {
+				t.Fatalf("ETag unchanged after the update")
+			}
+		})
+	}
+
+	// Changing the password signs the user out everywhere
+	s.request(t, fiber.MethodPatch, "/user/update/"+jane.id, jane.accessToken, fiber.Map{"password": "a brand new passphrase"}).expect(t, fiber.StatusOK, "")
+	s.request(t, fiber.MethodGet, "/user/"+jane.id, jane.accessToken, nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenRevoked)
+	s.login(t, jane.email, "a brand new passphrase").expect(t, fiber.StatusOK, "")
+}
+
+func TestDeleteEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	jane := s.register(t, "jane")
+	admin := s.registerAdmin(t, "admin")
+
+	testCases := []struct {
+		name       string
+		userID     string
+		token      string
+		fail       string
+		wantStatus int
+	}{
+		{name: "Another User", userID: jane.id, token: john.accessToken, wantStatus: fiber.StatusForbidden},
+		{name: "Unknown User", userID: "unknown", token: admin.accessToken, wantStatus: fiber.StatusNotFound},
+		{name: "Failed Delete", userID: john.id, token: john.accessToken, fail: "DeleteOneByID", wantStatus: fiber.StatusInternalServerError},
+		{name: "Own Account", userID: john.id, token: john.accessToken, wantStatus: fiber.StatusOK},
+		{name: "Administrator", userID: jane.id, token: admin.accessToken, wantStatus: fiber.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.fail != "" {
+				s.repo.FailCalls(tc.fail, errStorage)
+				defer s.repo.ResetFaults()
+			}
+			resp := s.request(t, fiber.MethodDelete, "/user/"+tc.userID, tc.token, nil)
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusOK && !resp.body.Get("restorable_until").Exists() {
+				t.Fatalf("DELETE /user/%s = %s, want restorable_until", tc.userID, resp.body.Raw)
+			}
+		})
+	}
+
+	// Deleted users are signed out and hidden
+	s.request(t, fiber.MethodGet, "/user/"+john.id, john.accessToken, nil).expect(t, fiber.StatusUnauthorized, "")
+	s.request(t, fiber.MethodGet, "/user/"+john.id, admin.accessToken, nil).expect(t, fiber.StatusNotFound, "")
+	s.login(t, john.email, testPassword).expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/mailer"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/token"
+	"go.uber.org/zap"
+)
+
+// errEmailNotVerified is returned when the token no longer matches the user's addresses.
+var errEmailNotVerified = errors.New("verification token does not match a current address")
+
+// sendVerificationEmail mails a single-use link confirming that the user owns address.
+// The address travels with the token, so a token only ever confirms the address it was sent to.
+func sendVerificationEmail(repo local.Repository, mail mailer.Mailer, config *AppConfig, user *model.User, address string) error {
+	verificationToken, err := token.Generate()
+	if err != nil {
+		return err
+	}
+	err = repo.SaveOneTimeToken(token.Hash(verificationToken), &model.OneTimeToken{
+		Purpose:   model.TokenPurposeEmailVerification,
+		UserID:    user.ID,
+		Payload:   address,
+		ExpiresAt: time.Now().Add(config.EmailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return mail.Send(mailer.Message{
+		To:      address,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nUse the following link to confirm %s as the email address of your account. It expires in %s and can only be used once.\n\n%s\n\nIf you did not sign up or change your email address, you can ignore this email.\n",
+			user.Name, address, config.EmailVerificationTTL, config.link("/verify-email", verificationToken)),
+	})
+}
+
+// requireVerifiedEmail refuses unverified accounts when the application is configured to.
+func requireVerifiedEmail(config *AppConfig, user *model.User) bool {
+	return config.RequireVerifiedEmail && !user.EmailVerified
+}
+
+// verifyEmailEndpoint confirms an email address using the token sent to it.
+// Confirming a pending address makes it the account's email address.
+func (handler *Auth) verifyEmailEndpoint(ctx *fiber.Ctx) error {
+	type verifyEmailRequest struct {
+		Token string `json:"token"` // Verification token received by email
+	}
+
+	var req verifyEmailRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		handler.log.Error("Failed to parse body", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid request format") // Return 400 if body parsing fails
+	}
+
+	if req.Token == "" {
+		return handler.errors.NewBadRequest("Verification token is required") // Return 400 if no token is provided
+	}
+
+	// Consume the token, a second attempt with the same token fails
+	verificationToken, err := handler.repo.ConsumeOneTimeToken(model.TokenPurposeEmailVerification, token.Hash(req.Token))
+	if err != nil {
+		handler.log.Error("Invalid verification token", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid or expired verification token") // Return 400 if the token is unknown, used or expired
+	}
+	address := verificationToken.Payload
+
+	// A pending address must still be free when it is confirmed
+	user, err := handler.repo.FindOneByID(verificationToken.UserID)
+	if err != nil {
+		handler.log.Error("User of verification token not found", zap.Error(err))
+		return handler.errors.NewBadRequest("Invalid or expired verification token") // Return 400 if the account is gone
+	}
+	if address == user.PendingEmail {
+		emailTaken, err := handler.users.userService.IsEmailTaken(address)
+		if err != nil {
+			handler.log.Error("Error checking email", zap.Error(err))
+			return handler.errors.NewInternalServerError("Error checking email") // Return 500 if the lookup fails
+		}
+		if emailTaken {
+			return handler.errors.NewConflict("Email is taken").WithCode(middleware.CodeEmailTaken) // Return 409 if someone else claimed the address meanwhile
+		}
+	}
+
+	user, err = handler.repo.ModifyOneByID(verificationToken.UserID, func(user *model.User) error {
+		switch address {
+		case user.Email:
+			user.EmailVerified = true
+		case user.PendingEmail:
+			user.Email = address
+			user.PendingEmail = ""
+			user.EmailVerified = true
+		default:
+			return errEmailNotVerified // The address was changed again after this token was sent
+		}
+		return nil
+	})
+	if errors.Is(err, errEmailNotVerified) {
+		return handler.errors.NewBadRequest("Invalid or expired verification token") // Return 400 if the token is stale
+	}
+	var conflict *local.ConflictError
+	if errors.As(err, &conflict) {
+		return handler.errors.NewConflict("Email is taken").WithCode(middleware.CodeEmailTaken) // Return 409 if the address was claimed between the check and the update
+	}
+	if err != nil {
+		handler.log.Error("Failed to verify email", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to verify email") // Return 500 if the update fails
+	}
+
+	handler.log.Info("Email verified", zap.String("userID", user.ID))
+	return ctx.JSON(fiber.Map{
+		"message": "Email verified successfully",
+		"user":    ToResponseUser(user),
+	})
+}
+
+// resendVerificationEndpoint sends a new verification email for the authenticated user's unconfirmed address.
+func (handler *Auth) resendVerificationEndpoint(ctx *fiber.Ctx) error {
+	// Extracting the user ID from the JWT token (authenticated user).
+	tokenUserID, _ := ctx.Locals("user_id").(string)
+
+	user, err := handler.repo.FindOneByID(tokenUserID)
+	if err != nil {
+		handler.log.Error("User not found", zap.Error(err))
+		return lookupError(&handler.errors, err, "User not found", "Failed to find user") // Return 404 if the account is gone, 500 if it cannot be read
+	}
+
+	// A pending change takes precedence over the current address
+	address := user.PendingEmail
+	if address == "" {
+		if user.EmailVerified {
+			return handler.errors.NewBadRequest("Email is already verified") // Return 400 if there is nothing to verify
+		}
+		address = user.Email
+	}
+
+	if err := sendVerificationEmail(handler.repo, handler.mailer, handler.config, user, address); err != nil {
+		handler.log.Error("Failed to send verification email", zap.Error(err))
+		return handler.errors.NewInternalServerError("Failed to send verification email") // Return 500 if the email cannot be sent
+	}
+
+	handler.log.Info("Verification email sent", zap.String("userID", user.ID))
+	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Verification email sent",
+	})
+}
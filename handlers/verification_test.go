@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
+)
+
+const verifySubject = "Verify your email address"
+
+func TestVerifyEmailEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	signUpToken := s.mail.lastToken(t, john.email, verifySubject)
+
+	testCases := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "Missing Token", token: "", wantStatus: fiber.StatusBadRequest},
+		{name: "Unknown Token", token: "unknown", wantStatus: fiber.StatusBadRequest},
+		{name: "Valid Token", token: signUpToken, wantStatus: fiber.StatusOK},
+		{name: "Used Token", token: signUpToken, wantStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := s.request(t, fiber.MethodPost, "/auth/email/verify", "", fiber.Map{"token": tc.token})
+			resp.expect(t, tc.wantStatus, "")
+			if tc.wantStatus == fiber.StatusOK && !resp.body.Get("user.email_verified").Bool() {
+				t.Fatalf("verified user = %s, want email_verified", resp.body.Get("user").Raw)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailChange(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+	jane := s.register(t, "jane")
+
+	// An update that fails leaves the email address as it was and sends no link
+	s.request(t, fiber.MethodPatch, "/user/update/"+john.id, john.accessToken, fiber.Map{"email": "johnny@example.com", "username": "jane"}).expect(t, fiber.StatusConflict, middleware.CodeUsernameTaken)
+	if user, err := s.repo.FindOneByID(john.id); err != nil || user.PendingEmail != "" || user.Version != 1 {
+		t.Fatalf("user after the failed update = %+v, %v, want no pending email and no new version", user, err)
+	}
+	if mails := s.mail.count("johnny@example.com", verifySubject); mails != 0 {
+		t.Fatalf("%d verification mails sent for a failed update", mails)
+	}
+
+	// A new address stays pending until it is confirmed
+	s.request(t, fiber.MethodPatch, "/user/update/"+john.id, john.accessToken, fiber.Map{"email": "johnny@example.com"}).expect(t, fiber.StatusOK, "")
+	changeToken := s.mail.lastToken(t, "johnny@example.com", verifySubject)
+	s.login(t, "johnny@example.com", testPassword).expect(t, fiber.StatusUnauthorized, middleware.CodeInvalidCredentials)
+
+	resp := s.request(t, fiber.MethodPost, "/auth/email/verify", "", fiber.Map{"token": changeToken})
+	resp.expect(t, fiber.StatusOK, "")
+	if email := resp.body.Get("user.email").String(); email != "johnny@example.com" {
+		t.Fatalf("email = %q after confirming the change", email)
+	}
+	s.login(t, "johnny@example.com", testPassword).expect(t, fiber.StatusOK, "")
+
+	// An address claimed by someone else before it is confirmed cannot be confirmed
+	s.request(t, fiber.MethodPatch, "/user/update/"+jane.id, jane.accessToken, fiber.Map{"email": "j@example.com"}).expect(t, fiber.StatusOK, "")
+	claimedToken := s.mail.lastToken(t, "j@example.com", verifySubject)
+	s.request(t, fiber.MethodPatch, "/user/update/"+john.id, john.accessToken, fiber.Map{"email": "j@example.com"}).expect(t, fiber.StatusOK, "")
+	s.request(t, fiber.MethodPost, "/auth/email/verify", "", fiber.Map{"token": s.mail.lastToken(t, "j@example.com", verifySubject)}).expect(t, fiber.StatusOK, "")
+	s.request(t, fiber.MethodPost, "/auth/email/verify", "", fiber.Map{"token": claimedToken}).expect(t, fiber.StatusConflict, middleware.CodeEmailTaken)
+}
+
+func TestResendVerificationEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	john := s.register(t, "john")
+
+	s.repo.FailCalls("SaveOneTimeToken", errStorage)
+	s.request(t, fiber.MethodPost, "/auth/email/resend", john.accessToken, nil).expect(t, fiber.StatusInternalServerError, "")
+	s.repo.ResetFaults()
+
+	s.request(t, fiber.MethodPost, "/auth/email/resend", john.accessToken, nil).expect(t, fiber.StatusAccepted, "")
+	if mails := s.mail.count(john.email, verifySubject); mails != 2 {
+		t.Fatalf("%d verification emails sent, want the sign-up email and the resent one", mails)
+	}
+
+	// Both tokens are valid, but once the address is verified there is nothing left to resend
+	s.request(t, fiber.MethodPost, "/auth/email/verify", "", fiber.Map{"token": s.mail.lastToken(t, john.email, verifySubject)}).expect(t, fiber.StatusOK, "")
+	s.request(t, fiber.MethodPost, "/auth/email/resend", john.accessToken, nil).expect(t, fiber.StatusBadRequest, "")
+	s.request(t, fiber.MethodPost, "/auth/email/resend", "", nil).expect(t, fiber.StatusUnauthorized, middleware.CodeTokenMissing)
+}
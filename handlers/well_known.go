@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type wellKnown struct {
+	log    *zap.Logger // Logger for logging events
+	config *AppConfig  // Application configuration, including the JWT keyring
+}
+
+// NewWellKnown initializes the handler serving discovery documents such as the JWKS.
+func NewWellKnown(log *zap.Logger, config *AppConfig) Handler {
+	return &wellKnown{
+		log:    log,
+		config: config,
+	}
+}
+
+// AssignEndpoints sets up the routes for the discovery documents.
+func (handler *wellKnown) AssignEndpoints(prefix string, router fiber.Router) {
+	r := router.Group(prefix)
+
+	// Route for the public signing keys
+	r.Get("jwks.json", handler.jwksEndpoint) // GET /.well-known/jwks.json: Publishes the keys tokens can be verified with.
+}
+
+// jwksEndpoint returns the public keys of the keyring so other services can verify our tokens.
+func (handler *wellKnown) jwksEndpoint(ctx *fiber.Ctx) error {
+	// Let verifiers cache the document, rotations announce new keys well before they are used
+	ctx.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return ctx.Status(fiber.StatusOK).JSON(handler.config.Keyring.JWKS())
+}
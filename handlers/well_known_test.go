@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+)
+
+func TestJWKSEndpoint(t *testing.T) {
+	s := newTestServer(t)
+
+	// A keyring with only a shared secret has no public keys to publish
+	resp := s.request(t, fiber.MethodGet, "/.well-known/jwks.json", "", nil)
+	resp.expect(t, fiber.StatusOK, "")
+	if keys := resp.body.Get("keys"); !keys.IsArray() || len(keys.Array()) != 0 {
+		t.Fatalf("GET /.well-known/jwks.json = %s, want an empty key set", resp.body.Raw)
+	}
+
+	// An asymmetric key is published without its private part
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(private)
+	if err != nil {
+		t.Fatalf("Error encoding key: %v", err)
+	}
+	key, err := jwt.ParseKeyPEM("ec-1", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParseKeyPEM() error = %v", err)
+	}
+	if err := s.config.Keyring.Add(key); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	resp = s.request(t, fiber.MethodGet, "/.well-known/jwks.json", "", nil)
+	resp.expect(t, fiber.StatusOK, "")
+	keys := resp.body.Get("keys").Array()
+	if len(keys) != 1 || keys[0].Get("kid").String() != "ec-1" || keys[0].Get("kty").String() != "EC" || keys[0].Get("d").Exists() {
+		t.Fatalf("GET /.well-known/jwks.json = %s, want the public EC key", resp.body.Raw)
+	}
+}
@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/id"
+)
+
+type fileMailer struct {
+	dir  string // Directory the .eml files are written to
+	from string // Sender address
+}
+
+// NewFileMailer creates a mailer that writes every message as an .eml file, for development setups.
+func NewFileMailer(dir, from string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to a new file in the mail directory.
+func (m *fileMailer) Send(msg Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), id.GenerateUUID())
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o600)
+}
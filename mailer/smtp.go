@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+type smtpMailer struct {
+	addr string    // host:port of the SMTP server
+	from string    // Sender address
+	auth smtp.Auth // Optional PLAIN authentication
+}
+
+// NewSMTPMailer creates a mailer that delivers through an SMTP server. Authentication is only used
+// when a username is given, so a local sink such as MailHog (localhost:1025) works without credentials.
+func NewSMTPMailer(addr, from, username, password string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{addr: addr, from: from, auth: auth}
+}
+
+// Send delivers the message through the SMTP server.
+func (m *smtpMailer) Send(msg Message) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg))
+}
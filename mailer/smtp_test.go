@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpSink is a minimal local SMTP server that accepts a single message and records its data.
+func smtpSink(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start SMTP sink: %v", err)
+	}
+	received := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 sink ready")
+
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 sink")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, received := smtpSink(t)
+
+	// Send a message to the local sink without authentication
+	m := NewSMTPMailer(addr, "no-reply@example.com", "", "")
+	err := m.Send(Message{To: "user@example.com", Subject: "Reset your password", Body: "token123"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	// Verify the delivered message
+	data := <-received
+	for _, want := range []string{"From: no-reply@example.com", "To: user@example.com", "Subject: Reset your password", "token123"} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("Expected message to contain %q, got %q", want, data)
+		}
+	}
+}
@@ -1,66 +1,136 @@
 package main
 
 import (
+	"context"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/handlers"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/middleware"
-	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/services"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/validator"
 	"go.uber.org/zap"
 	"log"
 	"os"
+	"time"
 )
 
 func main() {
 	// Initialize logger
 	logger, _ := zap.NewDevelopment()
 	errors := middleware.AppError{}
-	// Initialize local repository
-	localDbPath := os.Getenv("LOCAL_DB_PATH")
-	localRepo, err := local.NewBuntRepository(localDbPath)
+	// Initialize local repository on the configured storage backend
+	localRepo, err := newRepository()
 	if err != nil {
-		logger.Fatal("Error creating brand-new bunt local repository", zap.String("local_db_path_env_variable", localDbPath), zap.Error(err))
+		logger.Fatal("Error creating local repository", zap.String("storage_backend_env_variable", os.Getenv("STORAGE_BACKEND")), zap.Error(err))
 	}
 	defer localRepo.Close()
 
-	// Initialize validator
-	validate := validator.NewValidator() // No repository passed
+	// Initialize the password hasher used for new and upgraded hashes
+	hasher, err := newPasswordHasher(logger)
+	if err != nil {
+		logger.Fatal("Error creating password hasher", zap.Error(err))
+	}
+	password.SetDefault(hasher)
+
+	// Initialize validator with the password policy
+	validate := validator.NewValidator(newPasswordPolicy(logger))
 
-	// Load JWT secret from environment variable
+	// Load JWT secret and signing keys from environment variables
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable not set")
+	jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+	if jwtSecret == "" && jwtKeysDir == "" {
+		log.Fatal("JWT_SECRET or JWT_KEYS_DIR environment variable not set")
+	}
+	keyring, err := jwt.LoadKeyring([]byte(jwtSecret), jwtKeysDir, os.Getenv("JWT_ACTIVE_KEY_ID"))
+	if err != nil {
+		logger.Fatal("Error loading JWT keyring", zap.String("jwt_keys_dir_env_variable", jwtKeysDir), zap.Error(err))
 	}
 
-	// Initialize AppConfig with the JWT secret
+	// Initialize AppConfig with the JWT keyring
 	config := &handlers.AppConfig{
-		JWTSecret: []byte(jwtSecret),
+		Keyring:               keyring,
+		PublicURL:             os.Getenv("PUBLIC_URL"),
+		PasswordResetTTL:      envDuration(logger, "PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL:  envDuration(logger, "EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		RequireVerifiedEmail:  envBool(logger, "REQUIRE_VERIFIED_EMAIL", false),
+		MFAIssuer:             envOrDefault("MFA_ISSUER", "Golang Web Application"),
+		LoginThrottle:         newLoginThrottle(logger),
+		DefaultRole:           envOrDefault("DEFAULT_ROLE", model.DefaultRole),
+		LoginHistoryRetention: envDuration(logger, "LOGIN_HISTORY_RETENTION", 90*24*time.Hour),
+		UserRestoreWindow:     envDuration(logger, "USER_RESTORE_WINDOW", 30*24*time.Hour),
+	}
+
+	// Load role definitions, new users must get a defined role
+	roles, err := newRoles(logger)
+	if err != nil {
+		logger.Fatal("Error loading role definitions", zap.String("roles_file_env_variable", os.Getenv("ROLES_FILE")), zap.Error(err))
+	}
+	if !definesRole(roles, config.DefaultRole) {
+		logger.Fatal("Default role is not defined", zap.String("role", config.DefaultRole))
+	}
+
+	// Initialize mailer for emails sent to users
+	mail, err := newMailer(logger)
+	if err != nil {
+		logger.Fatal("Error creating mailer", zap.Error(err))
 	}
 
 	// Initialize UserService
 	userService := services.NewUserService(localRepo) // Create the UserService instance
 
+	// Initialize RoleService and store the configured role definitions
+	roleService := services.NewRoleService(localRepo)
+	if err := roleService.LoadRoles(roles); err != nil {
+		logger.Fatal("Error storing role definitions", zap.Error(err))
+	}
+
+	// Start the worker that permanently removes deleted users once they can no longer be restored
+	purgeContext, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	purgeInterval := envDuration(logger, "USER_PURGE_INTERVAL", time.Hour)
+	if purgeInterval <= 0 {
+		logger.Fatal("User purge interval must be positive", zap.Duration("interval", purgeInterval))
+	}
+	purgeWorker := services.NewPurgeWorker(logger, localRepo, config.UserRestoreWindow, purgeInterval)
+	go purgeWorker.Run(purgeContext)
+
 	// Initialize fiber app
 	app := fiber.New(fiber.Config{
-		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
-			switch e := err.(type) {
-			case *fiber.Error:
-				return ctx.Status(e.Code).JSON(e)
-			}
-			return nil
-		},
-		AppName: "Golang Web Application",
+		ErrorHandler: middleware.ErrorHandler(logger),
+		AppName:      "Golang Web Application",
 	})
 
-	// Initialize auth-handler and pass the config containing JWT secret
-	authHandler := handlers.NewAuth(logger, localRepo, validate, config, errors)
+	// Tag every request with an ID that error responses and logs refer to
+	app.Use(requestid.New())
+
+	// Turn panics into 500 responses instead of dropping the connection
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace: true,
+		StackTraceHandler: func(ctx *fiber.Ctx, e interface{}) {
+			logger.Error("Recovered from panic", zap.Any("panic", e), zap.String("path", ctx.Path()), zap.Stack("stack"))
+		},
+	}))
+
+	// Initialize well-known handler publishing the JWKS
+	wellKnownHandler := handlers.NewWellKnown(logger, config)
+	wellKnownHandler.AssignEndpoints("/.well-known", app)
+
+	// Initialize auth-handler and pass the config containing JWT keyring and userService
+	authHandler := handlers.NewAuth(logger, localRepo, validate, config, userService, roleService, mail, errors)
 	authHandler.AssignEndpoints("auth", app)
 
-	// Initialize user-handler and pass the config containing JWT secret and userService
-	userHandler := handlers.NewUser(logger, localRepo, validate, config, userService, errors)
+	// Initialize user-handler and pass the config containing JWT keyring and userService
+	userHandler := handlers.NewUser(logger, localRepo, validate, config, userService, roleService, mail, errors)
 	userHandler.AssignEndpoints("/user", app)
 
+	// Initialize admin-handler for operations restricted to administrators
+	adminHandler := handlers.NewAdmin(logger, localRepo, config, roleService, errors)
+	adminHandler.AssignEndpoints("/admin", app)
+
 	// Start listening on port 8080
 	if err = app.Listen(":8080"); err != nil {
 		logger.Fatal("Application terminated with an error", zap.Error(err))
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	stderrors "errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.uber.org/zap"
+)
+
+// problemContentType is the media type of RFC 7807 error responses.
+const problemContentType = "application/problem+json"
+
+// ErrorHandler sends every error returned by a handler as application/problem+json. Errors of the
+// repository are mapped by their meaning, anything else, including recovered panics, becomes a 500
+// that is logged with its details but answered without them.
+func ErrorHandler(log *zap.Logger) fiber.ErrorHandler {
+	return func(ctx *fiber.Ctx, err error) error {
+		requestID, _ := ctx.Locals(requestid.ConfigDefault.ContextKey).(string)
+
+		var problem *Problem
+		var fiberError *fiber.Error
+		switch {
+		case stderrors.As(err, &problem):
+		case stderrors.As(err, &fiberError) && fiberError.Code < fiber.StatusInternalServerError:
+			// Errors raised by Fiber itself, e.g. unknown routes or oversized bodies
+			problem = NewProblem(fiberError.Code, codeForStatus(fiberError.Code), fiberError.Message)
+		default:
+			// Never pass on details of unexpected failures
+			problem = errors.NewFromError(err)
+		}
+
+		if problem.Status >= fiber.StatusInternalServerError {
+			log.Error("Request failed", zap.String("requestID", requestID), zap.String("method", ctx.Method()), zap.String("path", ctx.Path()), zap.Error(err))
+		}
+
+		response := *problem // Copy, so errors shared between requests are not modified
+		response.Instance = ctx.Path()
+		response.RequestID = requestID
+		return ctx.Status(response.Status).JSON(response, problemContentType)
+	}
+}
+
+// codeForStatus derives the generic code of an HTTP status from its reason phrase, e.g. "method_not_allowed".
+func codeForStatus(status int) string {
+	var code strings.Builder
+	for _, r := range strings.ToLower(utils.StatusMessage(status)) {
+		switch {
+		case r >= 'a' && r <= 'z':
+			code.WriteRune(r)
+		case r == ' ' || r == '-':
+			code.WriteRune('_')
+		}
+	}
+	if code.Len() == 0 {
+		return CodeInternalError
+	}
+	return code.String()
+}
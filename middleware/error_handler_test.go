@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/validator"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestErrorHandler(t *testing.T) {
+	fieldErrors := []validator.FieldError{{Field: "email", Rule: "required", Message: "email is required"}}
+	shared := errors.NewNotFound("Shared problem")
+
+	testCases := []struct {
+		name       string
+		err        error
+		panicValue interface{}
+		wantStatus int
+		wantCode   string
+		wantDetail string
+		wantErrors []validator.FieldError
+		wantLogged bool
+	}{
+		{name: "Problem", err: errors.NewForbidden("No access"), wantStatus: fiber.StatusForbidden, wantCode: CodeForbidden, wantDetail: "No access"},
+		{name: "Problem With Code", err: errors.NewUnauthorized("Bad token").WithCode(CodeTokenInvalid), wantStatus: fiber.StatusUnauthorized, wantCode: CodeTokenInvalid, wantDetail: "Bad token"},
+		{name: "Wrapped Problem", err: fmt.Errorf("lookup: %w", errors.NewConflict("Taken").WithCode(CodeEmailTaken)), wantStatus: fiber.StatusConflict, wantCode: CodeEmailTaken, wantDetail: "Taken"},
+		{name: "Validation Error", err: errors.NewValidationError(fieldErrors), wantStatus: fiber.StatusBadRequest, wantCode: CodeValidationFailed, wantDetail: "Validation failed", wantErrors: fieldErrors},
+		{name: "Shared Problem", err: shared, wantStatus: fiber.StatusNotFound, wantCode: CodeNotFound, wantDetail: "Shared problem"},
+		{name: "Problem With 500", err: errors.NewInternalServerError("Failed to save"), wantStatus: fiber.StatusInternalServerError, wantCode: CodeInternalError, wantDetail: "Failed to save", wantLogged: true},
+		{name: "Repository Not Found", err: fmt.Errorf("user 1: %w", local.ErrNotFound), wantStatus: fiber.StatusNotFound, wantCode: CodeNotFound, wantDetail: "Not found"},
+		{name: "Repository Conflict", err: fmt.Errorf("email: %w", local.ErrConflict), wantStatus: fiber.StatusConflict, wantCode: CodeConflict, wantDetail: "Conflict"},
+		{name: "Repository Invalid Data", err: local.ErrInvalidData, wantStatus: fiber.StatusBadRequest, wantCode: CodeBadRequest, wantDetail: "Invalid data"},
+		{name: "Unexpected Error", err: stderrors.New("dial tcp 10.0.0.1:5432: connection refused"), wantStatus: fiber.StatusInternalServerError, wantCode: CodeInternalError, wantDetail: "Internal server error", wantLogged: true},
+		{name: "Fiber Error", err: fiber.ErrRequestEntityTooLarge, wantStatus: fiber.StatusRequestEntityTooLarge, wantCode: "request_entity_too_large", wantDetail: "Request Entity Too Large"},
+		{name: "Fiber Server Error", err: fiber.NewError(fiber.StatusServiceUnavailable, "upstream details"), wantStatus: fiber.StatusInternalServerError, wantCode: CodeInternalError, wantDetail: "Internal server error", wantLogged: true},
+		{name: "Panic", panicValue: "nil map", wantStatus: fiber.StatusInternalServerError, wantCode: CodeInternalError, wantDetail: "Internal server error", wantLogged: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.ErrorLevel)
+			app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler(zap.New(core))})
+			app.Use(requestid.New())
+			app.Use(recover.New())
+			app.Get("/fail", func(ctx *fiber.Ctx) error {
+				if tc.panicValue != nil {
+					panic(tc.panicValue)
+				}
+				return tc.err
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/fail", nil))
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			var problem Problem
+			if err := json.Unmarshal(body, &problem); err != nil {
+				t.Fatalf("Error decoding %s: %v", body, err)
+			}
+
+			if resp.StatusCode != tc.wantStatus || problem.Status != tc.wantStatus {
+				t.Fatalf("status = %d, body status = %d, want %d", resp.StatusCode, problem.Status, tc.wantStatus)
+			}
+			if contentType := resp.Header.Get(fiber.HeaderContentType); !strings.HasPrefix(contentType, problemContentType) {
+				t.Fatalf("Content-Type = %q, want %q", contentType, problemContentType)
+			}
+			if problem.Code != tc.wantCode || problem.Detail != tc.wantDetail || problem.Type != "about:blank" {
+				t.Fatalf("problem = %s, want code %q and detail %q", body, tc.wantCode, tc.wantDetail)
+			}
+			if problem.Instance != "/fail" || problem.RequestID == "" || problem.RequestID != resp.Header.Get(fiber.HeaderXRequestID) {
+				t.Fatalf("problem = %s, want the path and the request ID %q", body, resp.Header.Get(fiber.HeaderXRequestID))
+			}
+			if !reflect.DeepEqual(problem.Errors, tc.wantErrors) {
+				t.Fatalf("errors = %v, want %v", problem.Errors, tc.wantErrors)
+			}
+			if logged := logs.Len() > 0; logged != tc.wantLogged {
+				t.Fatalf("logged = %v, want %v", logged, tc.wantLogged)
+			}
+		})
+	}
+
+	// Responses are built from copies, the shared problem keeps no request details
+	if shared.Instance != "" || shared.RequestID != "" {
+		t.Fatalf("shared problem was modified: %+v", shared)
+	}
+}
+
+func TestCodeForStatus(t *testing.T) {
+	testCases := []struct {
+		status int
+		want   string
+	}{
+		{status: fiber.StatusNotFound, want: CodeNotFound},
+		{status: fiber.StatusMethodNotAllowed, want: "method_not_allowed"},
+		{status: fiber.StatusTooManyRequests, want: CodeTooManyRequests},
+		{status: fiber.StatusTeapot, want: "im_a_teapot"},
+		{status: 599, want: CodeInternalError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.status), func(t *testing.T) {
+			if got := codeForStatus(tc.status); got != tc.want {
+				t.Fatalf("codeForStatus(%d) = %q, want %q", tc.status, got, tc.want)
+			}
+		})
+	}
+}
@@ -1,39 +1,132 @@
 package middleware
 
 import (
+	stderrors "errors"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/validator"
+)
+
+// Stable error codes clients can rely on. Every status has a generic code derived from its reason phrase,
+// handlers may set a more specific one.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodePreconditionFailed = "precondition_failed"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeInternalError      = "internal_server_error"
+
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeLoginThrottled     = "login_throttled"
+	CodeAccountSuspended   = "account_suspended"
+	CodeEmailNotVerified   = "email_not_verified"
+	CodeEmailTaken         = "email_taken"
+	CodeUsernameTaken      = "username_taken"
+	CodeTokenMissing       = "token_missing"
+	CodeTokenInvalid       = "token_invalid"
+	CodeTokenRevoked       = "token_revoked"
+	CodeMissingPermission  = "missing_permission"
 )
 
+// Problem is an error response following RFC 7807, sent as application/problem+json
+type Problem struct {
+	Type      string                 `json:"type"`                 // URI identifying the problem type, "about:blank" for plain HTTP errors
+	Title     string                 `json:"title"`                // Short summary of the problem type
+	Status    int                    `json:"status"`               // HTTP status code
+	Detail    string                 `json:"detail,omitempty"`     // Explanation of this occurrence
+	Instance  string                 `json:"instance,omitempty"`   // Path of the request that failed
+	Code      string                 `json:"code"`                 // Stable machine-readable error code
+	RequestID string                 `json:"request_id,omitempty"` // ID of the request, also sent in the X-Request-ID header
+	Errors    []validator.FieldError `json:"errors,omitempty"`     // Invalid fields of a validation error
+}
+
+// Error implements the error interface
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+// WithCode replaces the generic code of the problem with a more specific one
+func (p *Problem) WithCode(code string) *Problem {
+	p.Code = code
+	return p
+}
+
+// NewProblem returns a problem with a custom status, code and message
+func NewProblem(status int, code string, message string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  utils.StatusMessage(status),
+		Status: status,
+		Detail: message,
+		Code:   code,
+	}
+}
+
 type AppError struct{}
 
 // NewBadRequest returns a 400 Bad Request error with a custom message
-func (e *AppError) NewBadRequest(message string) *fiber.Error {
-	return &fiber.Error{
-		Code:    fiber.StatusBadRequest,
-		Message: message,
-	}
+func (e *AppError) NewBadRequest(message string) *Problem {
+	return NewProblem(fiber.StatusBadRequest, CodeBadRequest, message)
 }
 
 // NewUnauthorized returns a 401 Unauthorized error with a custom message
-func (e *AppError) NewUnauthorized(message string) *fiber.Error {
-	return &fiber.Error{
-		Code:    fiber.StatusUnauthorized,
-		Message: message,
-	}
+func (e *AppError) NewUnauthorized(message string) *Problem {
+	return NewProblem(fiber.StatusUnauthorized, CodeUnauthorized, message)
 }
 
 // NewInternalServerError returns a 500 Internal Server Error with a custom message
-func (e *AppError) NewInternalServerError(message string) *fiber.Error {
-	return &fiber.Error{
-		Code:    fiber.StatusInternalServerError,
-		Message: message,
-	}
+func (e *AppError) NewInternalServerError(message string) *Problem {
+	return NewProblem(fiber.StatusInternalServerError, CodeInternalError, message)
+}
+
+// NewForbidden returns a 403 Forbidden error with a custom message
+func (e *AppError) NewForbidden(message string) *Problem {
+	return NewProblem(fiber.StatusForbidden, CodeForbidden, message)
+}
+
+// NewConflict returns a 409 Conflict error with a custom message
+func (e *AppError) NewConflict(message string) *Problem {
+	return NewProblem(fiber.StatusConflict, CodeConflict, message)
+}
+
+// NewPreconditionFailed returns a 412 Precondition Failed error with a custom message
+func (e *AppError) NewPreconditionFailed(message string) *Problem {
+	return NewProblem(fiber.StatusPreconditionFailed, CodePreconditionFailed, message)
+}
+
+// NewTooManyRequests returns a 429 Too Many Requests error with a custom message
+func (e *AppError) NewTooManyRequests(message string) *Problem {
+	return NewProblem(fiber.StatusTooManyRequests, CodeTooManyRequests, message)
 }
 
 // NewNotFound returns a 404 Not Found error with a custom message
-func (e *AppError) NewNotFound(message string) *fiber.Error {
-	return &fiber.Error{
-		Code:    fiber.StatusNotFound,
-		Message: message,
+func (e *AppError) NewNotFound(message string) *Problem {
+	return NewProblem(fiber.StatusNotFound, CodeNotFound, message)
+}
+
+// NewFromError maps a domain error of the repository to the HTTP error it is reported with. Errors
+// without a domain meaning are reported as 500 Internal Server Error, without revealing their details
+func (e *AppError) NewFromError(err error) *Problem {
+	switch {
+	case stderrors.Is(err, local.ErrNotFound):
+		return e.NewNotFound("Not found")
+	case stderrors.Is(err, local.ErrConflict):
+		return e.NewConflict("Conflict")
+	case stderrors.Is(err, local.ErrInvalidData):
+		return e.NewBadRequest("Invalid data")
 	}
+	return e.NewInternalServerError("Internal server error")
+}
+
+// NewValidationError returns a 400 Bad Request error carrying the per-field validation errors
+func (e *AppError) NewValidationError(fieldErrors []validator.FieldError) *Problem {
+	problem := NewProblem(fiber.StatusBadRequest, CodeValidationFailed, "Validation failed")
+	problem.Errors = fieldErrors
+	return problem
 }
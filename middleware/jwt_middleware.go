@@ -1,42 +1,58 @@
 package middleware
 
 import (
-	"github.com/dgrijalva/jwt-go"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
 )
 
 var errors *AppError
 
 // JWTAuthMiddleware verifies JWT token and authorizes users for protected routes
-func JWTAuthMiddleware(jwtSecret []byte) fiber.Handler {
+func JWTAuthMiddleware(keyring *jwt.Keyring, revocations local.RevocationStore) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// JWT validation logic using jwtSecret
+		// JWT validation logic using the keyring
 		tokenString := c.Get("Authorization")
 		if tokenString == "" {
-			return errors.NewUnauthorized("Unauthorized, no token provided")
+			return errors.NewUnauthorized("Unauthorized, no token provided").WithCode(CodeTokenMissing)
 		}
 
 		// Remove "Bearer " prefix
-		tokenString = tokenString[len("Bearer "):]
+		tokenString, ok := strings.CutPrefix(tokenString, "Bearer ")
+		if !ok {
+			return errors.NewUnauthorized("Unauthorized, invalid token").WithCode(CodeTokenInvalid)
+		}
 
-		// Parse token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		// Parse and validate token against the key named in its header
+		claims, err := keyring.Parse(tokenString)
+		if err != nil {
+			return errors.NewUnauthorized("Unauthorized, invalid token").WithCode(CodeTokenInvalid)
+		}
 
-		// Validate token
-		if err != nil || !token.Valid {
-			return errors.NewUnauthorized("Unauthorized, invalid token")
+		// Refresh tokens are only accepted by the refresh endpoint
+		if typ, _ := claims["typ"].(string); typ != jwt.TokenTypeAccess {
+			return errors.NewUnauthorized("Unauthorized, invalid token").WithCode(CodeTokenInvalid)
 		}
 
-		// Extract claims and proceed
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return errors.NewUnauthorized("Unauthorized, invalid token")
+		// Reject tokens that were revoked by logout, account deletion or a password change
+		tokenID, _ := claims["jti"].(string)
+		if tokenID == "" {
+			return errors.NewUnauthorized("Unauthorized, invalid token").WithCode(CodeTokenInvalid)
+		}
+		revoked, err := revocations.IsAccessTokenRevoked(tokenID)
+		if err != nil {
+			return errors.NewInternalServerError("Could not verify token")
+		}
+		if revoked {
+			return errors.NewUnauthorized("Unauthorized, token has been revoked").WithCode(CodeTokenRevoked)
 		}
 
 		c.Locals("user_id", claims["user_id"])
 		c.Locals("role", claims["role"])
+		c.Locals("session_id", claims["session_id"])
+		c.Locals("token_id", tokenID)
 
 		return c.Next()
 	}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// PermissionChecker tells whether a role grants a permission.
+type PermissionChecker interface {
+	HasPermission(role string, permission string) (bool, error)
+}
+
+// RequirePermission only lets requests through whose role grants all of the given permissions.
+// It must run after JWTAuthMiddleware, which puts the role of the token into the context.
+func RequirePermission(checker PermissionChecker, permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("role").(string)
+		for _, permission := range permissions {
+			granted, err := checker.HasPermission(role, permission)
+			if err != nil {
+				return errors.NewInternalServerError("Could not check permissions")
+			}
+			if !granted {
+				return errors.NewForbidden("Forbidden, missing permission " + permission).WithCode(CodeMissingPermission)
+			}
+		}
+		return c.Next()
+	}
+}
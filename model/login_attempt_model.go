@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// LoginAttempts counts the failed logins of an account or a client IP within the failure window.
+type LoginAttempts struct {
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"` // Zero unless the failures reached the lockout threshold
+	ExpiresAt     time.Time `json:"expires_at"`   // The counter is forgotten after this time
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Outcomes of a login attempt recorded in the login history.
+const (
+	LoginSucceeded           = "succeeded"
+	LoginInvalidPassword     = "invalid_password"
+	LoginThrottled           = "throttled"
+	LoginEmailNotVerified    = "email_not_verified"
+	LoginSuspended           = "suspended"
+	LoginMFAChallenged       = "mfa_challenged"
+	LoginInvalidSecondFactor = "invalid_second_factor"
+)
+
+// LoginEvent is a single login attempt on an existing account.
+type LoginEvent struct {
+	UserID    string    `json:"user_id"`
+	Outcome   string    `json:"outcome"` // One of the Login* outcomes
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Device    string    `json:"device,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// MFA holds a user's TOTP second factor. The secret has to be stored as is to compute codes,
+// recovery codes are stored as hashes only.
+type MFA struct {
+	UserID        string    `json:"user_id"`
+	Secret        string    `json:"secret,omitempty"`         // Confirmed TOTP secret, set once enrollment is confirmed
+	PendingSecret string    `json:"pending_secret,omitempty"` // Secret handed out on enrollment, awaiting a first valid code
+	Enabled       bool      `json:"enabled"`
+	RecoveryCodes []string  `json:"recovery_codes,omitempty"` // Hashes of the unused recovery codes
+	LastUsedStep  int64     `json:"last_used_step"`           // Time step of the last accepted code, older codes are refused
+	EnabledAt     time.Time `json:"enabled_at"`
+}
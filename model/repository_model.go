@@ -1,23 +1,74 @@
 package model
 
+import "time"
+
+// Roles a user can have.
+const (
+	DefaultRole = "user"  // Role assigned to every newly registered user
+	AdminRole   = "admin" // Role allowed to manage other users
+)
+
 type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username" validate:"required,min=3,max=32,username_format"`
+	Email    string `json:"email" validate:"required,max=254,email_format"`
+	Password string `json:"password" validate:"required"` // Length and strength are checked by the password policy
+	Name     string `json:"name" validate:"required,max=64"`
+	Lastname string `json:"lastname" validate:"required,max=64"`
+	Age      int    `json:"age" validate:"gte=0,lte=150"`
+	Role     string `json:"role"`
+
+	EmailVerified bool   `json:"email_verified"`          // Whether the owner of Email confirmed it
+	PendingEmail  string `json:"pending_email,omitempty"` // Requested new email, applied once confirmed
+
+	LockedUntil time.Time `json:"locked_until"` // Logins are refused until this time after too many failed attempts
+
+	Suspended              bool `json:"suspended"`                // Suspended accounts cannot sign in until an administrator lifts the suspension
+	PasswordChangeRequired bool `json:"password_change_required"` // Set with a temporary password, cleared once the user picks their own
+
+	CreatedAt time.Time `json:"created_at"` // Registration time, zero for accounts created before it was recorded
+	DeletedAt time.Time `json:"deleted_at"` // Deletion time, zero for active accounts; deleted accounts are kept until purged
+
+	Version int64 `json:"version"` // Incremented by the repository on every write, used for optimistic concurrency
+}
+
+type UserResponse struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
-	Password string `json:"password"`
 	Name     string `json:"name"`
 	Lastname string `json:"lastname"`
 	Age      int    `json:"age"`
-	Role     string `json:"role"`
+
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
-type UserResponse struct {
+// PublicUserResponse is the public profile of a user, shown to other authenticated users.
+type PublicUserResponse struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Lastname string `json:"lastname"`
+}
+
+// AdminUserResponse is the full representation of a user shown to administrators.
+type AdminUserResponse struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Name     string `json:"name"`
 	Lastname string `json:"lastname"`
 	Age      int    `json:"age"`
+	Role     string `json:"role"`
+
+	EmailVerified          bool      `json:"email_verified"`
+	PendingEmail           string    `json:"pending_email,omitempty"`
+	LockedUntil            time.Time `json:"locked_until"`
+	Suspended              bool      `json:"suspended"`
+	PasswordChangeRequired bool      `json:"password_change_required"`
+	CreatedAt              time.Time `json:"created_at"`
+	DeletedAt              time.Time `json:"deleted_at"`
 }
 
 // Display the response in order for Create function.
@@ -31,6 +82,11 @@ type CreateUserResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// IsDeleted reports whether the user was deleted and is only kept for a possible restore.
+func (u *User) IsDeleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
 // Update user function encapsulation.
 // UpdateFields updates non-zero fields of the current user with the update data
 func (u *User) UpdateFields(updateData *User) {
@@ -51,5 +107,6 @@ func (u *User) UpdateFields(updateData *User) {
 	}
 	if updateData.Password != "" {
 		u.Password = updateData.Password // Hashing will still be done in the caller function
+		u.PasswordChangeRequired = false // The user picked their own password
 	}
 }
@@ -0,0 +1,32 @@
+package model
+
+// Permissions a role can grant.
+const (
+	PermissionUsersRead      = "users:read"       // Read the public profiles of other users
+	PermissionUsersReadAny   = "users:read:any"   // Read every field of any user, e.g. email addresses
+	PermissionUsersWriteAny  = "users:write:any"  // Update any user, not only oneself
+	PermissionUsersDeleteAny = "users:delete:any" // Delete any user, not only oneself
+	PermissionUsersManage    = "users:manage"     // Use the administration API, e.g. unlock accounts
+)
+
+// Role is a named set of permissions. The role of a user is carried in the "role" claim of their access token.
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// DefaultRoles are used when no role definitions are configured.
+var DefaultRoles = []Role{
+	{Name: DefaultRole, Permissions: []string{PermissionUsersRead}},
+	{Name: AdminRole, Permissions: []string{PermissionUsersRead, PermissionUsersReadAny, PermissionUsersWriteAny, PermissionUsersDeleteAny, PermissionUsersManage}},
+}
+
+// Has reports whether the role grants a permission.
+func (r *Role) Has(permission string) bool {
+	for _, granted := range r.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// Session represents a single signed-in device of a user and the refresh token currently bound to it.
+// A session is also a refresh token family: every token rotated out of it stays linked to the session,
+// so presenting one of them again revokes the whole family.
+type Session struct {
+	ID               string    `json:"id"`
+	UserID           string    `json:"user_id"`
+	Device           string    `json:"device"`
+	UserAgent        string    `json:"user_agent"`
+	IP               string    `json:"ip"`
+	CreatedAt        time.Time `json:"created_at"`
+	LastUsedAt       time.Time `json:"last_used_at"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshTokenHash string    `json:"refresh_token_hash"`
+	AccessTokenID    string    `json:"access_token_id"` // jti of the only access token currently valid for the session
+	Generation       int       `json:"generation"`      // Number of times the refresh token has been rotated
+}
+
+// SessionResponse is the representation of a session returned to its owner.
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
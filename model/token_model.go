@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Purposes of one-time tokens.
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification" // Payload is the address being verified
+	TokenPurposeMFAChallenge      = "mfa_challenge"      // Payload is the device name given on login
+)
+
+// OneTimeToken is a single-use, expiring token sent to a user out of band, e.g. by email.
+// Only the hash of the token itself is stored.
+type OneTimeToken struct {
+	Purpose   string    `json:"purpose"`
+	UserID    string    `json:"user_id"`
+	Payload   string    `json:"payload,omitempty"` // Purpose specific data bound to the token
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,29 @@
+package model
+
+// Fields users can be sorted by.
+const (
+	SortByCreatedAt = "created_at"
+	SortByUsername  = "username"
+	SortByLastname  = "lastname"
+)
+
+// UserQuery selects a page of users. Zero values leave a filter out.
+type UserQuery struct {
+	Role           string // Only users with exactly this role
+	MinAge         int    // Only users at least this old
+	MaxAge         int    // Only users at most this old, 0 for no upper bound
+	NamePrefix     string // Only users whose name or lastname starts with this, ignoring case
+	UsernamePrefix string // Only users whose username starts with this, ignoring case
+	SortBy         string // One of the SortBy* fields, SortByCreatedAt by default
+	Descending     bool   // Sort in descending order
+	Cursor         string // Opaque position returned as UserPage.Next, empty for the first page
+	Limit          int    // Maximum number of users on the page
+	CountTotal     bool   // Count every match into UserPage.Total, which costs a pass over all users
+}
+
+// UserPage is one page of users matching a UserQuery.
+type UserPage struct {
+	Users []*User
+	Total int    // Number of users matching the filters across all pages, 0 unless CountTotal was set
+	Next  string // Cursor of the next page, empty on the last page
+}
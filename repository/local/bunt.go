@@ -2,15 +2,39 @@ package local
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/tidwall/buntdb"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"golang.org/x/text/unicode/norm"
 )
 
 // BuntImpl struct that holds the database instance
 type BuntImpl struct {
-	DB *buntdb.DB // BuntDB instance for database operations
+	DB       *buntdb.DB    // BuntDB instance for database operations
+	cleanups []userCleanup // Run by PurgeDeletedUsers for every purged user, in registration order
+}
+
+// buntCleanupTx passes the transaction of a BuntDB purge to the user cleanups.
+type buntCleanupTx struct {
+	tx *buntdb.Tx
+}
+
+func (buntCleanupTx) backend() string { return "BuntDB" }
+
+// BuntUserCleanup adapts a cleanup of BuntDB records. It fails if the purge runs on another backend.
+func BuntUserCleanup(cleanup func(tx *buntdb.Tx, userID string) error) UserCleanup {
+	return func(tx UserCleanupTx, userID string) error {
+		buntTx, ok := tx.(buntCleanupTx)
+		if !ok {
+			return unsupportedCleanupTx("BuntDB", tx)
+		}
+		return cleanup(buntTx.tx, userID)
+	}
 }
 
 // NewBuntRepository initializes a new BuntDB repository.
@@ -21,59 +45,268 @@ func NewBuntRepository(dbPath string) (Repository, error) {
 		return nil, err // Return error if the database cannot be opened.
 	}
 
+	// Make sure the lookup keys match the stored users.
+	if err := rebuildUserLookups(db); err != nil {
+		db.Close()
+		return nil, err // Return error if the lookup keys cannot be rebuilt.
+	}
+
+	// Create the indexes users are sorted and paged by.
+	if err := createUserIndexes(db); err != nil {
+		db.Close()
+		return nil, err // Return error if the indexes cannot be created.
+	}
+
+	// Every subsystem that keeps records of a user removes them when the user is purged.
+	repo := &BuntImpl{DB: db}
+	repo.RegisterUserCleanup("sessions", BuntUserCleanup(deleteUserSessions))
+	repo.RegisterUserCleanup("one-time tokens", BuntUserCleanup(deleteUserOneTimeTokens))
+	repo.RegisterUserCleanup("mfa", BuntUserCleanup(deleteMFA))
+	repo.RegisterUserCleanup("login events", BuntUserCleanup(deleteLoginEvents))
+
 	// Return a new instance of BuntImpl with the open database.
-	return &BuntImpl{DB: db}, nil
+	return repo, nil
 }
 
-// Create saves a new user to the database.
-func (repo *BuntImpl) Create(user *model.User) error {
-	return repo.DB.Update(func(tx *buntdb.Tx) error {
-		// Convert user struct to JSON format for storage.
-		userJSON, err := json.Marshal(user)
+// RegisterUserCleanup adds the cleanup of a subsystem to every later purge of a user. Cleanups have to be
+// registered before the repository is used concurrently.
+func (repo *BuntImpl) RegisterUserCleanup(name string, cleanup UserCleanup) {
+	repo.cleanups = append(repo.cleanups, userCleanup{name: name, cleanup: cleanup})
+}
+
+// deleteKeys removes every key matching pattern, or only those whose value match accepts if match is set.
+func deleteKeys(tx *buntdb.Tx, pattern string, match func(value string) bool) error {
+	// Collect the keys first, buntdb does not allow deleting while iterating.
+	var keys []string
+	if err := tx.AscendKeys(pattern, func(key, value string) bool {
+		if match == nil || match(value) {
+			keys = append(keys, key)
+		}
+		return true // Continue iterating.
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := tx.Delete(key); err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// userKey builds the key a user is stored under.
+func userKey(userID string) string {
+	return fmt.Sprintf("user:%s", userID)
+}
+
+// emailKey builds the lookup key that maps an email address to the ID of its user.
+func emailKey(email string) string {
+	return fmt.Sprintf("email:%s", NormalizeIdentifier(email))
+}
+
+// usernameKey builds the lookup key that maps a username to the ID of its user.
+func usernameKey(username string) string {
+	return fmt.Sprintf("username:%s", NormalizeIdentifier(username))
+}
+
+// NormalizeIdentifier folds an email address or username so that values differing only in case,
+// surrounding spaces or Unicode representation (e.g. "ﬁ" and "fi") map to the same lookup key.
+func NormalizeIdentifier(value string) string {
+	return strings.ToLower(norm.NFKC.String(strings.TrimSpace(value)))
+}
+
+// getUser reads a user within a transaction. Deleted users are reported as missing.
+func getUser(tx *buntdb.Tx, userID string) (*model.User, error) {
+	user, err := getStoredUser(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDeleted() {
+		return nil, buntdb.ErrNotFound // Return not found, deleted users only exist for RestoreOneByID and the purge.
+	}
+	return user, nil
+}
+
+// getStoredUser reads a user within a transaction, including users that were deleted but not yet purged.
+func getStoredUser(tx *buntdb.Tx, userID string) (*model.User, error) {
+	val, err := tx.Get(userKey(userID))
+	if err != nil {
+		return nil, err // Return error if the user is not found.
+	}
+	var user model.User
+	if err := json.Unmarshal([]byte(val), &user); err != nil {
+		return nil, err // Return error if the user data is corrupted.
+	}
+	return &user, nil
+}
+
+// AnyVersion makes UpdateOneByID update a user whatever its current version is.
+const AnyVersion int64 = -1
+
+// setUser writes a user and moves its email and username lookup keys along within the same transaction.
+// previous is the stored version of the user, nil when the user is new. Every write increments the version.
+func setUser(tx *buntdb.Tx, user *model.User, previous *model.User) error {
+	user.Version = 1
+	if previous != nil {
+		user.Version = previous.Version + 1
+		if err := deleteUserLookups(tx, previous); err != nil {
+			return err
+		}
+	}
+
+	// Convert user struct to JSON format for storage.
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		return err // Return error if JSON marshaling fails.
+	}
+	if _, _, err := tx.Set(userKey(user.ID), string(userJSON), nil); err != nil {
+		return err // Return error if the user cannot be saved.
+	}
+
+	return setUserLookups(tx, user) // Return any error encountered during save.
+}
+
+// setUserLookups points the email and username lookup keys at a user. Empty values are not looked up.
+// A key that already points at another user fails with a ConflictError, which rolls the transaction back.
+func setUserLookups(tx *buntdb.Tx, user *model.User) error {
+	for _, lookup := range userLookups(user) {
+		owner, err := tx.Get(lookup.key)
+		if err == nil && owner != user.ID {
+			return &ConflictError{Field: lookup.field} // Return error if another user has this value.
+		}
+		if err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		if _, _, err := tx.Set(lookup.key, user.ID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userLookup is a lookup key together with the user field it is built from.
+type userLookup struct {
+	field string
+	key   string
+}
+
+// userLookups returns the lookup keys of a user's non-empty email and username.
+func userLookups(user *model.User) []userLookup {
+	var lookups []userLookup
+	if user.Email != "" {
+		lookups = append(lookups, userLookup{field: "email", key: emailKey(user.Email)})
+	}
+	if user.Username != "" {
+		lookups = append(lookups, userLookup{field: "username", key: usernameKey(user.Username)})
+	}
+	return lookups
+}
+
+// deleteUserLookups removes the email and username lookup keys of a user, unless they point at another user.
+func deleteUserLookups(tx *buntdb.Tx, user *model.User) error {
+	for _, lookup := range userLookups(user) {
+		owner, err := tx.Get(lookup.key)
+		if err == buntdb.ErrNotFound || (err == nil && owner != user.ID) {
+			continue
+		}
 		if err != nil {
-			return err // Return error if JSON marshaling fails.
+			return err
+		}
+		if _, err := tx.Delete(lookup.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildUserLookups recreates every email and username lookup key from the stored users, so the keys
+// are correct for databases written before they existed or by an older version. Where older data holds
+// duplicates, the first user in key order keeps the lookup key.
+func rebuildUserLookups(db *buntdb.DB) error {
+	return db.Update(func(tx *buntdb.Tx) error {
+		var stale []string
+		for _, pattern := range []string{emailKey("*"), usernameKey("*")} {
+			if err := tx.AscendKeys(pattern, func(key, value string) bool {
+				stale = append(stale, key)
+				return true // Continue iterating.
+			}); err != nil {
+				return err
+			}
+		}
+		for _, key := range stale {
+			if _, err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		var users []*model.User
+		if err := tx.AscendKeys(userKey("*"), func(key, value string) bool {
+			var user model.User
+			if err := json.Unmarshal([]byte(value), &user); err == nil {
+				users = append(users, &user)
+			}
+			return true // Continue iterating.
+		}); err != nil {
+			return err
+		}
+		for _, user := range users {
+			var conflict *ConflictError
+			if err := setUserLookups(tx, user); err != nil && !errors.As(err, &conflict) {
+				return err
+			}
 		}
+		return nil
+	})
+}
 
-		// Save the user data to the database.
-		_, _, err = tx.Set(fmt.Sprintf("user:%s", user.ID), string(userJSON), nil)
-		return err // Return any error encountered during save.
+// Create saves a new user to the database together with its lookup keys.
+func (repo *BuntImpl) Create(user *model.User) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		return setUser(tx, user, nil)
 	})
 }
 
 // FindOneByID retrieves a user by their unique ID from the database.
 func (repo *BuntImpl) FindOneByID(userID string) (*model.User, error) {
-	var user model.User
+	var user *model.User
 	err := repo.DB.View(func(tx *buntdb.Tx) error {
-		// Retrieve the user data from the database.
-		val, err := tx.Get(fmt.Sprintf("user:%s", userID))
-		if err != nil {
-			return err // Return error if the user is not found.
-		}
-		// Unmarshal the JSON data into the user struct.
-		return json.Unmarshal([]byte(val), &user)
+		var err error
+		user, err = getUser(tx, userID)
+		return err
 	})
 	if err != nil {
-		return nil, err // Return error if fetching or unmarshalling fails.
+		return nil, notFound(err, "user") // Return error if fetching or unmarshalling fails.
 	}
-	return &user, nil // Return the retrieved user.
+	return user, nil // Return the retrieved user.
 }
 
-// FindAll retrieves all users from the database.
+// FindAll retrieves all users from the database, except deleted ones.
 func (repo *BuntImpl) FindAll() ([]*model.User, error) {
+	return repo.findAllUsers(func(user *model.User) bool {
+		return !user.IsDeleted()
+	})
+}
+
+// FindDeletedUsers retrieves the users that were deleted but not yet purged.
+func (repo *BuntImpl) FindDeletedUsers() ([]*model.User, error) {
+	return repo.findAllUsers(func(user *model.User) bool {
+		return user.IsDeleted()
+	})
+}
+
+// findAllUsers retrieves every stored user that match accepts.
+func (repo *BuntImpl) findAllUsers(match func(user *model.User) bool) ([]*model.User, error) {
 	var users []*model.User // Slice to hold all users
 
 	err := repo.DB.View(func(tx *buntdb.Tx) error {
-		// Iterate through all user records in the database.
-		err := tx.Ascend("", func(key, value string) bool {
-			if len(key) > 5 && key[:5] == "user:" {
-				var user model.User
-				if err := json.Unmarshal([]byte(value), &user); err == nil {
-					users = append(users, &user) // Append found users to the slice.
-				}
+		// Iterate through the user records only.
+		return tx.AscendKeys(userKey("*"), func(key, value string) bool {
+			var user model.User
+			if err := json.Unmarshal([]byte(value), &user); err == nil && match(&user) {
+				users = append(users, &user) // Append found users to the slice.
 			}
 			return true // Continue iteration.
 		})
-		return err // Return any error encountered during iteration.
 	})
 
 	if err != nil {
@@ -82,129 +315,186 @@ func (repo *BuntImpl) FindAll() ([]*model.User, error) {
 	return users, nil // Return the slice of users.
 }
 
-// UpdateOneByID updates user data for a given user ID.
-func (repo *BuntImpl) UpdateOneByID(userID string, updateData *model.User) error {
-	// Get current user from the database.
-	user, err := repo.FindOneByID(userID)
-	if err != nil {
-		return err // Return error if user not found.
+// UpdateOneByID updates user data for a given user ID within a single transaction and returns the updated user.
+// Unless expectedVersion is AnyVersion, the update fails with ErrStaleVersion if the user was changed since that version.
+func (repo *BuntImpl) UpdateOneByID(userID string, updateData *model.User, expectedVersion int64) (*model.User, error) {
+	// Hashing the password if it's changed, outside the transaction because it is slow.
+	var hashedPassword string
+	if updateData.Password != "" {
+		var err error
+		if hashedPassword, err = password.HashPassword(updateData.Password); err != nil {
+			return nil, fmt.Errorf("password hash error: %v", err) // Return error if password hashing fails.
+		}
 	}
 
-	// Update the desired fields in the user struct.
-	user.UpdateFields(updateData)
-
-	// Hashing the password if it's changed.
-	if updateData.Password != "" {
-		hashedPassword, err := password.HashPassword(updateData.Password)
+	// Save the updated user data to the database.
+	var user model.User
+	err := repo.DB.Update(func(tx *buntdb.Tx) error {
+		// Get current user from the database.
+		previous, err := getUser(tx, userID)
 		if err != nil {
-			return fmt.Errorf("password hash error: %v", err) // Return error if password hashing fails.
+			return notFound(err, "user") // Return error if user not found.
+		}
+		if expectedVersion != AnyVersion && previous.Version != expectedVersion {
+			return ErrStaleVersion // Return error if someone else changed the user in the meantime.
 		}
-		user.Password = hashedPassword // Update user password.
-	}
 
-	// Marshal the updated user data into JSON format.
-	userJSON, err := json.Marshal(user)
+		// Update the desired fields in the user struct.
+		user = *previous
+		user.UpdateFields(updateData)
+		if hashedPassword != "" {
+			user.Password = hashedPassword // Update user password.
+		}
+
+		return setUser(tx, &user, previous) // Return any error encountered during save.
+	})
 	if err != nil {
-		return fmt.Errorf("update user data JSON error: %v", err) // Return error if marshaling fails.
+		return nil, err // Return error if the update fails.
 	}
+	return &user, nil // Return the updated user.
+}
 
-	// Save the updated user data to the database.
-	err = repo.DB.Update(func(tx *buntdb.Tx) error {
-		_, _, err := tx.Set(fmt.Sprintf("user:%s", userID), string(userJSON), nil)
-		return err // Return any error encountered during save.
-	})
+// ModifyOneByID reads a user, applies modify and writes the result back within a single transaction.
+// If modify returns an error nothing is written and the error is returned.
+func (repo *BuntImpl) ModifyOneByID(userID string, modify func(user *model.User) error) (*model.User, error) {
+	var user model.User
+	err := repo.DB.Update(func(tx *buntdb.Tx) error {
+		// Retrieve the user data from the database.
+		previous, err := getUser(tx, userID)
+		if err != nil {
+			return notFound(err, "user") // Return error if the user is not found.
+		}
+
+		// Apply the requested changes.
+		user = *previous
+		if err := modify(&user); err != nil {
+			return err
+		}
 
-	return err // Return any error from the update operation.
+		// Save the modified user data to the database.
+		return setUser(tx, &user, previous)
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return &user, nil // Return the modified user.
 }
 
-// DeleteOneByID removes a user from the database by their ID.
+// DeleteOneByID soft deletes a user: it is marked as deleted, signed out and hidden from every lookup,
+// but kept together with its lookup keys, so nobody else can take its email address or username until
+// PurgeDeletedUsers removes it for good. Until then RestoreOneByID brings it back.
 func (repo *BuntImpl) DeleteOneByID(userID string) error {
-	// Delete user from the database by ID.
-	err := repo.DB.Update(func(tx *buntdb.Tx) error {
-		_, err := tx.Delete(fmt.Sprintf("user:%s", userID))
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		previous, err := getUser(tx, userID)
 		if err != nil {
-			return fmt.Errorf("user not found or error deleting user: %w", err) // Return error if user not found or delete fails.
+			return notFound(err, "user") // Return error if user not found or already deleted.
+		}
+
+		// End every session and drop pending links, a restored user signs in again.
+		if err := deleteUserSessions(tx, userID); err != nil {
+			return fmt.Errorf("error deleting sessions of user: %w", err)
+		}
+		if err := deleteUserOneTimeTokens(tx, userID); err != nil {
+			return fmt.Errorf("error deleting one-time tokens of user: %w", err)
 		}
-		return nil // Return nil if successful.
-	})
 
-	return err // Return any error from the delete operation.
+		user := *previous
+		user.DeletedAt = time.Now().UTC()
+		return setUser(tx, &user, previous) // Return any error encountered during save.
+	})
 }
 
-// FindOneByEmail retrieves a user by their email address from the database.
-func (repo *BuntImpl) FindOneByEmail(email string) (*model.User, error) {
+// RestoreOneByID undoes the deletion of a user that was deleted after deletedAfter. Users that are not
+// deleted, or were deleted before deletedAfter, are reported as not found.
+func (repo *BuntImpl) RestoreOneByID(userID string, deletedAfter time.Time) (*model.User, error) {
 	var user model.User
+	err := repo.DB.Update(func(tx *buntdb.Tx) error {
+		previous, err := getStoredUser(tx, userID)
+		if err != nil {
+			return notFound(err, "user") // Return error if the user is not found.
+		}
+		if !previous.IsDeleted() || previous.DeletedAt.Before(deletedAfter) {
+			return fmt.Errorf("deleted user %w", ErrNotFound) // Return error if there is nothing to restore.
+		}
 
-	// Search the user in db with email
-	err := repo.DB.View(func(tx *buntdb.Tx) error {
-		// Iterate all the users in the database.
-		err := tx.Ascend("", func(key, value string) bool {
-			if len(key) > 5 && key[:5] == "user:" {
-				var u model.User
-				if err := json.Unmarshal([]byte(value), &u); err == nil {
-					if u.Email == email {
-						user = u
-						return false // Stop iteration when user found
-					}
-				}
-			}
-			return true // Continue iteration.
-		})
-		return err // Return any error encountered during iteration.
+		user = *previous
+		user.DeletedAt = time.Time{}
+		return setUser(tx, &user, previous)
 	})
+	if err != nil {
+		return nil, err // Return error if the restore fails.
+	}
+	return &user, nil // Return the restored user.
+}
 
+// PurgeDeletedUsers permanently removes the users deleted before deletedBefore, with their lookup keys and,
+// through the registered cleanups, every record tied to them. Each user is removed in its own transaction,
+// so a failing cleanup keeps only that user. It returns how many users were removed.
+func (repo *BuntImpl) PurgeDeletedUsers(deletedBefore time.Time) (int, error) {
+	expired, err := repo.findAllUsers(func(user *model.User) bool {
+		return user.IsDeleted() && user.DeletedAt.Before(deletedBefore)
+	})
 	if err != nil {
-		return nil, err // Return error if fetching fails.
+		return 0, err // Return error if the users cannot be read.
 	}
 
-	// If user is not found, return nil
-	if user.ID == "" {
-		return nil, fmt.Errorf("user not found") // Return error if user ID is empty.
+	purged := 0
+	for _, user := range expired {
+		err := repo.DB.Update(func(tx *buntdb.Tx) error {
+			return repo.purgeUser(tx, user.ID, deletedBefore)
+		})
+		if err != nil {
+			return purged, fmt.Errorf("error purging user %s: %w", user.ID, err) // Return error if a user cannot be removed.
+		}
+		purged++
 	}
+	return purged, nil // Return the number of removed users.
+}
 
-	return &user, nil // Return the found user.
+// purgeUser removes a user deleted before deletedBefore, its lookup keys and the records of every registered
+// cleanup. A user restored in the meantime is left alone.
+func (repo *BuntImpl) purgeUser(tx *buntdb.Tx, userID string, deletedBefore time.Time) error {
+	user, err := getStoredUser(tx, userID)
+	if err != nil {
+		return notFound(err, "user") // Return error if the user is not found.
+	}
+	if !user.IsDeleted() || !user.DeletedAt.Before(deletedBefore) {
+		return nil // Restored since it was found, nothing to purge.
+	}
+	if err := deleteUserLookups(tx, user); err != nil {
+		return err
+	}
+	if _, err := tx.Delete(userKey(userID)); err != nil {
+		return fmt.Errorf("error deleting user: %w", err) // Return error if the delete fails.
+	}
+	return runUserCleanups(repo.cleanups, buntCleanupTx{tx: tx}, userID)
 }
 
-// SaveRefreshToken stores a user's refresh token in the database.
-func (repo *BuntImpl) SaveRefreshToken(UserID string, refreshToken string) error {
-	return repo.DB.Update(func(tx *buntdb.Tx) error {
-		key := fmt.Sprintf("refresh_token:%s", UserID)
-		_, _, err := tx.Set(key, refreshToken, nil)
-		return err // Return any error encountered during save.
-	})
+// FindOneByEmail retrieves a user by their email address through its lookup key.
+func (repo *BuntImpl) FindOneByEmail(email string) (*model.User, error) {
+	return repo.findOneByLookup(emailKey(email))
+}
+
+// FindOneByUsername retrieves a user by their username through its lookup key.
+func (repo *BuntImpl) FindOneByUsername(username string) (*model.User, error) {
+	return repo.findOneByLookup(usernameKey(username))
 }
 
-// FindRefreshToken retrieves a user ID based on the provided refresh token.
-func (repo *BuntImpl) FindRefreshToken(token string) (string, error) {
-	var userID string
+// findOneByLookup resolves a lookup key to its user with two point reads instead of a scan.
+func (repo *BuntImpl) findOneByLookup(key string) (*model.User, error) {
+	var user *model.User
 	err := repo.DB.View(func(tx *buntdb.Tx) error {
-		// Iterate over keys that start with "refresh_token:"
-		err := tx.Ascend("", func(key, value string) bool {
-			if key[:14] == "refresh_token:" && value == token {
-				// Extract the userID from the key
-				userID = key[len("refresh_token:"):]
-				return false // Stop iteration once the token is found
-			}
-			return true // Continue iteration.
-		})
-		return err // Return any error encountered during iteration.
+		userID, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		user, err = getUser(tx, userID)
+		return err
 	})
 	if err != nil {
-		return "", err // Return error if fetching fails.
+		return nil, notFound(err, "user") // Return error if no user has this email or username or fetching fails.
 	}
-	if userID == "" {
-		return "", fmt.Errorf("refresh token not found") // Return error if user ID is empty.
-	}
-	return userID, nil // Return the found user ID.
-}
-
-// DeleteRefreshToken removes a user's refresh token from the database.
-func (repo *BuntImpl) DeleteRefreshToken(userID string) error {
-	return repo.DB.Update(func(tx *buntdb.Tx) error {
-		key := fmt.Sprintf("refresh_token:%s", userID)
-		_, err := tx.Delete(key)
-		return err // Return any error encountered during delete.
-	})
+	return user, nil // Return the found user.
 }
 
 // Close closes the database connection.
@@ -0,0 +1,86 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// loginAttemptsKey builds the key the failed logins of an account or IP are counted under.
+func loginAttemptsKey(key string) string {
+	return fmt.Sprintf("login_attempts:%s", key)
+}
+
+// FindLoginAttempts retrieves the failed login counter for a key. Unknown keys have no failures.
+func (repo *BuntImpl) FindLoginAttempts(key string) (*model.LoginAttempts, error) {
+	var attempts model.LoginAttempts
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(loginAttemptsKey(key))
+		if err == buntdb.ErrNotFound {
+			return nil // No failures within the window.
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(val), &attempts)
+	})
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return &attempts, nil
+}
+
+// ModifyLoginAttempts reads the failed login counter for a key, applies modify and writes it back
+// within a single transaction, so concurrent failures are all counted. The counter expires at its ExpiresAt.
+func (repo *BuntImpl) ModifyLoginAttempts(key string, modify func(attempts *model.LoginAttempts) error) (*model.LoginAttempts, error) {
+	var attempts model.LoginAttempts
+	err := repo.DB.Update(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(loginAttemptsKey(key))
+		if err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal([]byte(val), &attempts); err != nil {
+				return err // Return error if the stored counter is corrupted.
+			}
+		}
+
+		// Apply the requested changes.
+		if err := modify(&attempts); err != nil {
+			return err
+		}
+
+		attemptsJSON, err := json.Marshal(&attempts)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+		ttl := time.Until(attempts.ExpiresAt)
+		if ttl <= 0 {
+			_, err := tx.Delete(loginAttemptsKey(key))
+			if err == buntdb.ErrNotFound {
+				return nil
+			}
+			return err // An expired counter is simply forgotten.
+		}
+		_, _, err = tx.Set(loginAttemptsKey(key), string(attemptsJSON), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return err // Return any error encountered during save.
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return &attempts, nil
+}
+
+// ResetLoginAttempts forgets the failed logins of a key, e.g. after a successful login or an unlock.
+func (repo *BuntImpl) ResetLoginAttempts(key string) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(loginAttemptsKey(key))
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,72 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// loginEventKey builds the key a login event is stored under. The zero padded timestamp keeps the
+// events of a user in chronological order, the sequence number those that share a timestamp.
+func loginEventKey(userID string, at time.Time, seq int) string {
+	return fmt.Sprintf("login_event:%s:%020d:%06d", userID, at.UnixNano(), seq)
+}
+
+// newLoginEventKey returns the first free key for a login event, so that events with the same timestamp
+// are all kept, in the order they were saved.
+func newLoginEventKey(userID string, at time.Time, exists func(key string) bool) string {
+	seq := 0
+	for exists(loginEventKey(userID, at, seq)) {
+		seq++
+	}
+	return loginEventKey(userID, at, seq)
+}
+
+// SaveLoginEvent appends an event to the login history of a user. It is forgotten after ttl.
+func (repo *BuntImpl) SaveLoginEvent(event *model.LoginEvent, ttl time.Duration) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+		key := newLoginEventKey(event.UserID, event.CreatedAt, func(key string) bool {
+			_, err := tx.Get(key)
+			return err == nil
+		})
+		_, _, err = tx.Set(key, string(eventJSON), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return err // Return any error encountered during save.
+	})
+}
+
+// FindLoginEvents retrieves the most recent login events of a user, newest first. A limit of 0 returns all of them.
+func (repo *BuntImpl) FindLoginEvents(userID string, limit int) ([]*model.LoginEvent, error) {
+	var events []*model.LoginEvent
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		return tx.DescendKeys(fmt.Sprintf("login_event:%s:*", userID), func(key, value string) bool {
+			var event model.LoginEvent
+			if err := json.Unmarshal([]byte(value), &event); err == nil {
+				events = append(events, &event)
+			}
+			return limit <= 0 || len(events) < limit // Stop once the limit is reached.
+		})
+	})
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return events, nil
+}
+
+// DeleteLoginEvents removes the login history of a user.
+func (repo *BuntImpl) DeleteLoginEvents(userID string) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		return deleteLoginEvents(tx, userID)
+	})
+}
+
+// deleteLoginEvents removes the login history of a user within a transaction.
+func deleteLoginEvents(tx *buntdb.Tx, userID string) error {
+	return deleteKeys(tx, fmt.Sprintf("login_event:%s:*", userID), nil)
+}
@@ -0,0 +1,82 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/buntdb"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// mfaKey builds the key the second factor of a user is stored under.
+func mfaKey(userID string) string {
+	return fmt.Sprintf("mfa:%s", userID)
+}
+
+// FindMFA retrieves the second factor of a user. A user without a second factor gets a disabled record.
+func (repo *BuntImpl) FindMFA(userID string) (*model.MFA, error) {
+	mfa := model.MFA{UserID: userID}
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(mfaKey(userID))
+		if err == buntdb.ErrNotFound {
+			return nil // No second factor was ever enrolled.
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(val), &mfa)
+	})
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return &mfa, nil
+}
+
+// ModifyMFA reads the second factor of a user, applies modify and writes the result back within a single transaction.
+// A user without a second factor starts from an empty record. If modify returns an error nothing is written.
+func (repo *BuntImpl) ModifyMFA(userID string, modify func(mfa *model.MFA) error) (*model.MFA, error) {
+	mfa := model.MFA{UserID: userID}
+	err := repo.DB.Update(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(mfaKey(userID))
+		if err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal([]byte(val), &mfa); err != nil {
+				return err // Return error if the stored record is corrupted.
+			}
+		}
+
+		// Apply the requested changes.
+		if err := modify(&mfa); err != nil {
+			return err
+		}
+
+		mfaJSON, err := json.Marshal(&mfa)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+		_, _, err = tx.Set(mfaKey(userID), string(mfaJSON), nil)
+		return err // Return any error encountered during save.
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return &mfa, nil
+}
+
+// DeleteMFA removes the second factor of a user. Deleting a missing record is not an error.
+func (repo *BuntImpl) DeleteMFA(userID string) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		return deleteMFA(tx, userID)
+	})
+}
+
+// deleteMFA removes the second factor of a user within a transaction.
+func deleteMFA(tx *buntdb.Tx, userID string) error {
+	_, err := tx.Delete(mfaKey(userID))
+	if err == buntdb.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package local
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// revokedTokenKey builds the key marking an access token as revoked.
+func revokedTokenKey(tokenID string) string {
+	return fmt.Sprintf("revoked_token:%s", tokenID)
+}
+
+// RevokeAccessToken adds an access token to the revocation list. The entry expires after ttl,
+// which should be at least the remaining lifetime of the token.
+func (repo *BuntImpl) RevokeAccessToken(tokenID string, ttl time.Duration) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		return revokeAccessToken(tx, tokenID, ttl)
+	})
+}
+
+// revokeAccessToken adds an access token to the revocation list within a transaction.
+func revokeAccessToken(tx *buntdb.Tx, tokenID string, ttl time.Duration) error {
+	_, _, err := tx.Set(revokedTokenKey(tokenID), time.Now().UTC().Format(time.RFC3339), &buntdb.SetOptions{Expires: true, TTL: ttl})
+	return err // Return any error encountered during save.
+}
+
+// IsAccessTokenRevoked reports whether an access token is on the revocation list.
+func (repo *BuntImpl) IsAccessTokenRevoked(tokenID string) (bool, error) {
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(revokedTokenKey(tokenID))
+		return err
+	})
+	if err == buntdb.ErrNotFound {
+		return false, nil // Token was never revoked or the entry already expired together with the token.
+	}
+	if err != nil {
+		return false, err // Return error if the lookup fails.
+	}
+	return true, nil
+}
@@ -0,0 +1,71 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/buntdb"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// roleKey builds the key a role definition is stored under.
+func roleKey(name string) string {
+	return fmt.Sprintf("role:%s", name)
+}
+
+// SaveRole stores a role definition, replacing an existing role of the same name.
+func (repo *BuntImpl) SaveRole(role *model.Role) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		roleJSON, err := json.Marshal(role)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+		_, _, err = tx.Set(roleKey(role.Name), string(roleJSON), nil)
+		return err // Return any error encountered during save.
+	})
+}
+
+// FindRole retrieves a role definition by its name.
+func (repo *BuntImpl) FindRole(name string) (*model.Role, error) {
+	var role model.Role
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(roleKey(name))
+		if err != nil {
+			return notFound(err, "role")
+		}
+		return json.Unmarshal([]byte(val), &role)
+	})
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return &role, nil
+}
+
+// FindAllRoles retrieves every stored role definition.
+func (repo *BuntImpl) FindAllRoles() ([]*model.Role, error) {
+	var roles []*model.Role
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(roleKey("*"), func(key, value string) bool {
+			var role model.Role
+			if err := json.Unmarshal([]byte(value), &role); err == nil {
+				roles = append(roles, &role)
+			}
+			return true // Continue iterating.
+		})
+	})
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return roles, nil
+}
+
+// DeleteRole removes a role definition. Deleting a missing role is not an error.
+func (repo *BuntImpl) DeleteRole(name string) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(roleKey(name))
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+}
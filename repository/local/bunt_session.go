@@ -0,0 +1,272 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+)
+
+// ErrRefreshTokenReused is returned when a session is rotated with a refresh token that is no longer its current one.
+var ErrRefreshTokenReused = errors.New("refresh token already rotated")
+
+// sessionKey builds the key a session is stored under; sessions are grouped by user so they can be listed with a pattern.
+func sessionKey(userID, sessionID string) string {
+	return fmt.Sprintf("session:%s:%s", userID, sessionID)
+}
+
+// refreshTokenKey builds the lookup key that maps a refresh token hash to its session.
+func refreshTokenKey(refreshTokenHash string) string {
+	return fmt.Sprintf("refresh_token:%s", refreshTokenHash)
+}
+
+// rotatedTokenKey builds the key that remembers which session a rotated refresh token hash belonged to.
+func rotatedTokenKey(refreshTokenHash string) string {
+	return fmt.Sprintf("rotated_token:%s", refreshTokenHash)
+}
+
+// sessionOptions makes session records expire together with their refresh token.
+func sessionOptions(session *model.Session) *buntdb.SetOptions {
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		ttl = time.Second // Already expired sessions are kept just long enough to be cleaned up.
+	}
+	return &buntdb.SetOptions{Expires: true, TTL: ttl}
+}
+
+// SaveSession creates or replaces a session and keeps its refresh token lookup key in sync.
+func (repo *BuntImpl) SaveSession(session *model.Session) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		key := sessionKey(session.UserID, session.ID)
+
+		// Drop the lookup key of the previous refresh token if the session is being rotated.
+		if val, err := tx.Get(key); err == nil {
+			var previous model.Session
+			if err := json.Unmarshal([]byte(val), &previous); err == nil && previous.RefreshTokenHash != session.RefreshTokenHash {
+				if _, err := tx.Delete(refreshTokenKey(previous.RefreshTokenHash)); err != nil && err != buntdb.ErrNotFound {
+					return err // Return error if the old lookup key cannot be removed.
+				}
+			}
+		} else if err != buntdb.ErrNotFound {
+			return err // Return error if the session cannot be read.
+		}
+
+		// Convert session struct to JSON format for storage.
+		sessionJSON, err := json.Marshal(session)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+
+		opts := sessionOptions(session)
+		if _, _, err := tx.Set(key, string(sessionJSON), opts); err != nil {
+			return err // Return error if the session cannot be saved.
+		}
+
+		// Store the lookup key used by refresh and logout.
+		_, _, err = tx.Set(refreshTokenKey(session.RefreshTokenHash), fmt.Sprintf("%s:%s", session.UserID, session.ID), opts)
+		return err // Return any error encountered during save.
+	})
+}
+
+// RotateSession binds a new refresh token to the session, provided the session is still bound to previousRefreshTokenHash.
+// The previous token is remembered as rotated until it would have expired, so that replaying it can be detected.
+func (repo *BuntImpl) RotateSession(session *model.Session, previousRefreshTokenHash string) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		key := sessionKey(session.UserID, session.ID)
+
+		// Read the stored session to make sure nobody rotated it in the meantime.
+		val, err := tx.Get(key)
+		if err != nil {
+			return notFound(err, "session") // Return error if the session is gone.
+		}
+		var current model.Session
+		if err := json.Unmarshal([]byte(val), &current); err != nil {
+			return err // Return error if the session data is corrupted.
+		}
+		if current.RefreshTokenHash != previousRefreshTokenHash {
+			return ErrRefreshTokenReused // The presented token was already rotated by another request.
+		}
+
+		// Move the previous token from the active lookup to the rotated tokens of the family.
+		if _, err := tx.Delete(refreshTokenKey(previousRefreshTokenHash)); err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		ref := fmt.Sprintf("%s:%s", session.UserID, session.ID)
+		if _, _, err := tx.Set(rotatedTokenKey(previousRefreshTokenHash), ref, sessionOptions(&current)); err != nil {
+			return err // Return error if the rotated token cannot be recorded.
+		}
+
+		// Convert session struct to JSON format for storage.
+		sessionJSON, err := json.Marshal(session)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+		opts := sessionOptions(session)
+		if _, _, err := tx.Set(key, string(sessionJSON), opts); err != nil {
+			return err // Return error if the session cannot be saved.
+		}
+		_, _, err = tx.Set(refreshTokenKey(session.RefreshTokenHash), ref, opts)
+		return err // Return any error encountered during save.
+	})
+}
+
+// FindRotatedRefreshToken resolves a refresh token hash that has already been rotated to the session family it belonged to.
+func (repo *BuntImpl) FindRotatedRefreshToken(refreshTokenHash string) (string, string, error) {
+	var ref string
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		var err error
+		ref, err = tx.Get(rotatedTokenKey(refreshTokenHash))
+		return notFound(err, "rotated refresh token") // Return error if the token was never rotated.
+	})
+	if err != nil {
+		return "", "", err
+	}
+	userID, sessionID, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", "", fmt.Errorf("malformed rotated token reference: %q", ref)
+	}
+	return userID, sessionID, nil // Return the owner of the token family.
+}
+
+// FindSession retrieves a single session of a user.
+func (repo *BuntImpl) FindSession(userID string, sessionID string) (*model.Session, error) {
+	var session model.Session
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		// Retrieve the session data from the database.
+		val, err := tx.Get(sessionKey(userID, sessionID))
+		if err != nil {
+			return notFound(err, "session") // Return error if the session is not found.
+		}
+		return json.Unmarshal([]byte(val), &session)
+	})
+	if err != nil {
+		return nil, err // Return error if fetching or unmarshalling fails.
+	}
+	return &session, nil // Return the found session.
+}
+
+// FindSessionByRefreshToken retrieves the session currently bound to the given refresh token hash.
+func (repo *BuntImpl) FindSessionByRefreshToken(refreshTokenHash string) (*model.Session, error) {
+	var session model.Session
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		// Resolve the refresh token hash to its session key.
+		ref, err := tx.Get(refreshTokenKey(refreshTokenHash))
+		if err != nil {
+			return notFound(err, "refresh token") // Return error if the refresh token is unknown.
+		}
+		userID, sessionID, ok := strings.Cut(ref, ":")
+		if !ok {
+			return fmt.Errorf("malformed refresh token reference: %q", ref)
+		}
+
+		// Retrieve the session data from the database.
+		val, err := tx.Get(sessionKey(userID, sessionID))
+		if err != nil {
+			return notFound(err, "session") // Return error if the session is gone.
+		}
+		return json.Unmarshal([]byte(val), &session)
+	})
+	if err != nil {
+		return nil, err // Return error if fetching or unmarshalling fails.
+	}
+	return &session, nil // Return the found session.
+}
+
+// FindSessionsByUserID retrieves all active sessions of a user.
+func (repo *BuntImpl) FindSessionsByUserID(userID string) ([]*model.Session, error) {
+	sessions := []*model.Session{}
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		// Iterate over the sessions of the given user only.
+		return tx.AscendKeys(sessionKey(userID, "*"), func(key, value string) bool {
+			var session model.Session
+			if err := json.Unmarshal([]byte(value), &session); err == nil {
+				sessions = append(sessions, &session) // Append found sessions to the slice.
+			}
+			return true // Continue iteration.
+		})
+	})
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return sessions, nil // Return the slice of sessions.
+}
+
+// DeleteSession removes a single session and its refresh token lookup key.
+func (repo *BuntImpl) DeleteSession(userID string, sessionID string) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		return deleteSession(tx, sessionKey(userID, sessionID))
+	})
+}
+
+// DeleteSessionsByUserID removes every session of a user, signing them out on all devices.
+func (repo *BuntImpl) DeleteSessionsByUserID(userID string) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		return deleteSessionsByUserID(tx, userID)
+	})
+}
+
+// deleteSessionsByUserID removes every session of a user within a transaction.
+func deleteSessionsByUserID(tx *buntdb.Tx, userID string) error {
+	// Collect the keys first, buntdb does not allow deleting while iterating.
+	var keys []string
+	if err := tx.AscendKeys(sessionKey(userID, "*"), func(key, value string) bool {
+		keys = append(keys, key)
+		return true // Continue iteration.
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := deleteSession(tx, key); err != nil {
+			return err // Return error if any session cannot be deleted.
+		}
+	}
+	return nil
+}
+
+// deleteUserSessions signs a deleted user out everywhere: the access token of every session is revoked,
+// the sessions and their refresh token lookup keys are removed, and so are the rotated tokens of their families.
+func deleteUserSessions(tx *buntdb.Tx, userID string) error {
+	var accessTokenIDs []string
+	if err := tx.AscendKeys(sessionKey(userID, "*"), func(key, value string) bool {
+		var session model.Session
+		if err := json.Unmarshal([]byte(value), &session); err == nil && session.AccessTokenID != "" {
+			accessTokenIDs = append(accessTokenIDs, session.AccessTokenID)
+		}
+		return true // Continue iteration.
+	}); err != nil {
+		return err
+	}
+	for _, tokenID := range accessTokenIDs {
+		if err := revokeAccessToken(tx, tokenID, jwt.AccessTokenTTL); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteSessionsByUserID(tx, userID); err != nil {
+		return err
+	}
+	return deleteKeys(tx, rotatedTokenKey("*"), func(value string) bool {
+		return strings.HasPrefix(value, userID+":")
+	})
+}
+
+// deleteSession removes the session stored under key together with its refresh token lookup key.
+func deleteSession(tx *buntdb.Tx, key string) error {
+	val, err := tx.Delete(key)
+	if err != nil {
+		return notFound(err, "session") // Return error if the session is not found.
+	}
+	var session model.Session
+	if err := json.Unmarshal([]byte(val), &session); err != nil {
+		return err // Return error if the session data is corrupted.
+	}
+	if _, err := tx.Delete(refreshTokenKey(session.RefreshTokenHash)); err != nil && err != buntdb.ErrNotFound {
+		return err // Return any error other than a missing lookup key.
+	}
+	return nil
+}
@@ -1,337 +1,152 @@
 package local
 
 import (
-	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"errors"
+	"fmt"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
 )
 
-func TestCreateUser(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test.db")
-	defer os.Remove("./test.db")
+func TestUserLookupKeys(t *testing.T) {
+	os.Setenv("LOCAL_DB_PATH", "./test_user_lookups.db")
+	defer os.Remove("./test_user_lookups.db")
 
 	repo, err := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
 	if err != nil {
-		t.Fatalf("Failed to create repository: %v", err)
+		t.Fatalf("Error creating repository: %v", err)
 	}
-
-	testCases := []struct {
-		name    string
-		user    model.User
-		wantErr bool
-	}{
-		{
-			name:    "Valid User",
-			user:    model.User{ID: "1", Username: "testuser", Email: "test@example.com", Password: "password123", Name: "Test", Lastname: "User", Age: 25},
-			wantErr: false,
-		},
-		{
-			name:    "Empty Fields",
-			user:    model.User{ID: "2", Username: "", Email: "", Password: "", Name: "", Lastname: "", Age: 0},
-			wantErr: false,
-		},
+	if err := repo.Create(&model.User{ID: "1", Username: "john", Email: "john@example.com"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			err := repo.Create(&tc.user)
-			if (err != nil) != tc.wantErr {
-				t.Fatalf("Create() error = %v, wantErr = %v", err, tc.wantErr)
-			}
-		})
+	// Changing email and username moves the lookup keys in the same transaction
+	if _, err := repo.UpdateOneByID("1", &model.User{Email: "johnny@example.com"}, AnyVersion); err != nil {
+		t.Fatalf("UpdateOneByID() error = %v", err)
+	}
+	if _, err := repo.ModifyOneByID("1", func(user *model.User) error {
+		user.Username = "johnny"
+		return nil
+	}); err != nil {
+		t.Fatalf("ModifyOneByID() error = %v", err)
 	}
-}
-
-func TestFindOneByID(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test_find.db")
-	defer os.Remove("./test_find.db")
-
-	repo, _ := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-
-	// Set up a known user
-	_ = repo.Create(&model.User{ID: "123", Email: "test@example.com"})
 
 	testCases := []struct {
 		name    string
-		userID  string
+		find    func() (*model.User, error)
 		wantErr bool
 	}{
-		{
-			name:    "User Exists",
-			userID:  "123",
-			wantErr: false,
-		},
-		{
-			name:    "User Does Not Exist",
-			userID:  "999",
-			wantErr: true,
-		},
+		{name: "New Email", find: func() (*model.User, error) { return repo.FindOneByEmail("johnny@example.com") }},
+		{name: "Old Email", find: func() (*model.User, error) { return repo.FindOneByEmail("john@example.com") }, wantErr: true},
+		{name: "New Username", find: func() (*model.User, error) { return repo.FindOneByUsername("johnny") }},
+		{name: "Old Username", find: func() (*model.User, error) { return repo.FindOneByUsername("john") }, wantErr: true},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := repo.FindOneByID(tc.userID)
+			user, err := tc.find()
 			if (err != nil) != tc.wantErr {
-				t.Fatalf("FindOneByID() error = %v, wantErr = %v", err, tc.wantErr)
+				t.Fatalf("find error = %v, wantErr = %v", err, tc.wantErr)
 			}
-		})
-	}
-}
-func TestUpdateUser(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test_update.db")
-	defer os.Remove("./test_update.db")
-
-	repo, _ := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-
-	// Setup: Create user
-	_ = repo.Create(&model.User{ID: "123", Email: "old@example.com", Password: "oldpass"})
-
-	testCases := []struct {
-		name       string
-		userID     string
-		updateData model.User
-		wantErr    bool
-	}{
-		{
-			name:       "Update Email and Password",
-			userID:     "123",
-			updateData: model.User{Email: "new@example.com", Password: "newpass"},
-			wantErr:    false,
-		},
-		{
-			name:       "Update Non-Existent User",
-			userID:     "999",
-			updateData: model.User{Email: "new@example.com"},
-			wantErr:    true,
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			err := repo.UpdateOneByID(tc.userID, &tc.updateData)
-			if (err != nil) != tc.wantErr {
-				t.Fatalf("UpdateOneByID() error = %v, wantErr = %v", err, tc.wantErr)
+			if err == nil && user.ID != "1" {
+				t.Fatalf("find returned user %s, want 1", user.ID)
 			}
 		})
 	}
-}
-func TestDeleteUser(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test_delete.db")
-	defer os.Remove("./test_delete.db")
 
-	repo, _ := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-
-	// Setup: Create user
-	_ = repo.Create(&model.User{ID: "123"})
-
-	testCases := []struct {
-		name    string
-		userID  string
-		wantErr bool
-	}{
-		{
-			name:    "Delete Existing User",
-			userID:  "123",
-			wantErr: false,
-		},
-		{
-			name:    "Delete Non-Existent User",
-			userID:  "999",
-			wantErr: true,
-		},
+	// Reopening rebuilds the lookup keys from the stored users, deleting the user hides it
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
 	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			err := repo.DeleteOneByID(tc.userID)
-			if (err != nil) != tc.wantErr {
-				t.Fatalf("DeleteOneByID() error = %v, wantErr = %v", err, tc.wantErr)
-			}
-		})
+	if repo, err = NewBuntRepository(os.Getenv("LOCAL_DB_PATH")); err != nil {
+		t.Fatalf("Error reopening repository: %v", err)
 	}
-}
-func TestFindOneByEmail(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test_find_email.db")
-	defer os.Remove("./test_find_email.db")
-
-	repo, _ := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-
-	// Setup: Create user
-	_ = repo.Create(&model.User{ID: "123", Email: "test@example.com"})
-
-	testCases := []struct {
-		name    string
-		email   string
-		wantErr bool
-	}{
-		{
-			name:    "Find Existing User by Email",
-			email:   "test@example.com",
-			wantErr: false,
-		},
-		{
-			name:    "Find Non-Existent User by Email",
-			email:   "nonexistent@example.com",
-			wantErr: true,
-		},
+	if _, err := repo.FindOneByEmail("johnny@example.com"); err != nil {
+		t.Fatalf("FindOneByEmail() after reopening error = %v", err)
 	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			_, err := repo.FindOneByEmail(tc.email)
-			if (err != nil) != tc.wantErr {
-				t.Fatalf("FindOneByEmail() error = %v, wantErr = %v", err, tc.wantErr)
-			}
-		})
+	if err := repo.DeleteOneByID("1"); err != nil {
+		t.Fatalf("DeleteOneByID() error = %v", err)
 	}
-}
-
-func TestFindAll(t *testing.T) {
-	// Set up environment for test
-	os.Setenv("LOCAL_DB_PATH", "./test_find_all.db")
-	defer os.Remove("./test_find_all.db")
-
-	// Create a new repository
-	repo, err := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-	if err != nil {
-		t.Fatalf("Error creating repository: %v", err)
-	}
-
-	// Add multiple users to the repository
-	users := []*model.User{
-		{ID: "1", Username: "user1", Email: "user1@example.com"},
-		{ID: "2", Username: "user2", Email: "user2@example.com"},
-		{ID: "3", Username: "user3", Email: "user3@example.com"},
+	if _, err := repo.FindOneByEmail("johnny@example.com"); err == nil {
+		t.Fatalf("FindOneByEmail() found a deleted user")
 	}
+}
 
-	// Insert users into the database
-	for _, user := range users {
-		err := repo.Create(user)
-		if err != nil {
-			t.Fatalf("Error creating user %s: %v", user.Username, err)
-		}
+func TestBuntErrors(t *testing.T) {
+	// ErrNotFound still matches BuntDB's own error
+	if !errors.Is(ErrNotFound, buntdb.ErrNotFound) {
+		t.Fatalf("ErrNotFound does not wrap buntdb.ErrNotFound")
 	}
+}
 
-	// Call the FindAll function
-	foundUsers, err := repo.FindAll()
+// benchmarkRepository fills an in-memory repository with n users and a session for each.
+func benchmarkRepository(b *testing.B, n int) Repository {
+	repo, err := NewBuntRepository(":memory:")
 	if err != nil {
-		t.Fatalf("Error finding all users: %v", err)
+		b.Fatalf("Error creating repository: %v", err)
 	}
-
-	// Verify that all users are retrieved
-	if len(foundUsers) != len(users) {
-		t.Fatalf("Expected %d users, found %d", len(users), len(foundUsers))
-	}
-
-	// Map the found users by ID for easier comparison
-	foundUserMap := make(map[string]*model.User)
-	for _, user := range foundUsers {
-		foundUserMap[user.ID] = user
-	}
-
-	// Check that all created users are found
-	for _, user := range users {
-		foundUser, exists := foundUserMap[user.ID]
-		if !exists {
-			t.Fatalf("User with ID %s not found", user.ID)
+	for i := 0; i < n; i++ {
+		user := &model.User{ID: fmt.Sprintf("id%d", i), Username: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i)}
+		if err := repo.Create(user); err != nil {
+			b.Fatalf("Create() error = %v", err)
 		}
-		if foundUser.Username != user.Username || foundUser.Email != user.Email {
-			t.Fatalf("Expected user %v, found %v", user, foundUser)
+		session := &model.Session{ID: fmt.Sprintf("session%d", i), UserID: user.ID, RefreshTokenHash: fmt.Sprintf("hash%d", i), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := repo.SaveSession(session); err != nil {
+			b.Fatalf("SaveSession() error = %v", err)
 		}
 	}
+	return repo
 }
 
-func TestSaveRefreshToken(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test_refresh_token.db")
-	defer os.Remove("./test_refresh_token.db")
-
-	repo, err := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-	if err != nil {
-		t.Fatalf("Error creating repository: %v", err)
-	}
-
-	// Save a refresh token for a user
-	err = repo.SaveRefreshToken("user123", "token123")
-	if err != nil {
-		t.Fatalf("Error saving refresh token: %v", err)
-	}
-
-	// Verify that the token was saved correctly
-	tokenOwner, err := repo.FindRefreshToken("token123")
-	if err != nil {
-		t.Fatalf("Error finding refresh token: %v", err)
-	}
-	if tokenOwner != "user123" {
-		t.Fatalf("Expected userID 'user123', got '%s'", tokenOwner)
+// The lookups below should take about the same time for every user count, a scan would grow linearly.
+
+func BenchmarkFindOneByEmail(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("users=%d", n), func(b *testing.B) {
+			repo := benchmarkRepository(b, n)
+			defer repo.Close()
+			email := fmt.Sprintf("user%d@example.com", n-1) // The last user, the worst case of a scan
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.FindOneByEmail(email); err != nil {
+					b.Fatalf("FindOneByEmail() error = %v", err)
+				}
+			}
+		})
 	}
 }
 
-func TestFindRefreshToken(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test_find_refresh_token.db")
-	defer os.Remove("./test_find_refresh_token.db")
-
-	repo, err := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-	if err != nil {
-		t.Fatalf("Error creating repository: %v", err)
-	}
-
-	// Save a refresh token
-	_ = repo.SaveRefreshToken("user123", "token123")
-
-	testCases := []struct {
-		name       string
-		token      string
-		wantUserID string
-		wantErr    bool
-	}{
-		{
-			name:       "Token Exists",
-			token:      "token123",
-			wantUserID: "user123",
-			wantErr:    false,
-		},
-		{
-			name:       "Token Does Not Exist",
-			token:      "token999",
-			wantUserID: "",
-			wantErr:    true,
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			userID, err := repo.FindRefreshToken(tc.token)
-			if (err != nil) != tc.wantErr {
-				t.Fatalf("FindRefreshToken() error = %v, wantErr = %v", err, tc.wantErr)
-			}
-			if userID != tc.wantUserID {
-				t.Fatalf("FindRefreshToken() userID = %v, want %v", userID, tc.wantUserID)
+func BenchmarkFindOneByUsername(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("users=%d", n), func(b *testing.B) {
+			repo := benchmarkRepository(b, n)
+			defer repo.Close()
+			username := fmt.Sprintf("user%d", n-1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.FindOneByUsername(username); err != nil {
+					b.Fatalf("FindOneByUsername() error = %v", err)
+				}
 			}
 		})
 	}
 }
 
-func TestDeleteRefreshToken(t *testing.T) {
-	os.Setenv("LOCAL_DB_PATH", "./test_delete_refresh_token.db")
-	defer os.Remove("./test_delete_refresh_token.db")
-
-	repo, err := NewBuntRepository(os.Getenv("LOCAL_DB_PATH"))
-	if err != nil {
-		t.Fatalf("Error creating repository: %v", err)
-	}
-
-	// Save a refresh token
-	_ = repo.SaveRefreshToken("user123", "token123")
-
-	// Delete the refresh token
-	err = repo.DeleteRefreshToken("user123")
-	if err != nil {
-		t.Fatalf("Error deleting refresh token: %v", err)
-	}
-
-	// Verify that the token was deleted
-	_, err = repo.FindRefreshToken("token123")
-	if err == nil {
-		t.Fatalf("Expected error finding deleted refresh token, got nil")
+func BenchmarkFindSessionByRefreshToken(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("users=%d", n), func(b *testing.B) {
+			repo := benchmarkRepository(b, n)
+			defer repo.Close()
+			hash := fmt.Sprintf("hash%d", n-1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.FindSessionByRefreshToken(hash); err != nil {
+					b.Fatalf("FindSessionByRefreshToken() error = %v", err)
+				}
+			}
+		})
 	}
 }
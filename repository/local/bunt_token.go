@@ -0,0 +1,79 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// oneTimeTokenKey builds the key a one-time token is stored under.
+func oneTimeTokenKey(purpose, tokenHash string) string {
+	return fmt.Sprintf("one_time_token:%s:%s", purpose, tokenHash)
+}
+
+// SaveOneTimeToken stores a one-time token by its hash until it expires.
+func (repo *BuntImpl) SaveOneTimeToken(tokenHash string, token *model.OneTimeToken) error {
+	return repo.DB.Update(func(tx *buntdb.Tx) error {
+		// Convert token struct to JSON format for storage.
+		tokenJSON, err := json.Marshal(token)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+		ttl := time.Until(token.ExpiresAt)
+		if ttl <= 0 {
+			return fmt.Errorf("one-time token already expired: %w", ErrInvalidData)
+		}
+		_, _, err = tx.Set(oneTimeTokenKey(token.Purpose, tokenHash), string(tokenJSON), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return err // Return any error encountered during save.
+	})
+}
+
+// deleteUserOneTimeTokens removes the one-time tokens issued to a user, e.g. pending password resets.
+// Tokens are keyed by their hash, so every token is checked.
+func deleteUserOneTimeTokens(tx *buntdb.Tx, userID string) error {
+	return deleteKeys(tx, oneTimeTokenKey("*", "*"), func(value string) bool {
+		var token model.OneTimeToken
+		return json.Unmarshal([]byte(value), &token) == nil && token.UserID == userID
+	})
+}
+
+// FindOneTimeToken retrieves a one-time token without using it up.
+func (repo *BuntImpl) FindOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error) {
+	var token model.OneTimeToken
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(oneTimeTokenKey(purpose, tokenHash))
+		if err != nil {
+			return notFound(err, "one-time token") // Return error if the token is unknown, used or expired.
+		}
+		return json.Unmarshal([]byte(val), &token)
+	})
+	if err != nil {
+		return nil, err // Return error if the lookup fails.
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, fmt.Errorf("one-time token %w", ErrNotFound) // Expired tokens behave like unknown ones.
+	}
+	return &token, nil // Return the found token.
+}
+
+// ConsumeOneTimeToken retrieves and deletes a one-time token in the same transaction, so it can only be used once.
+func (repo *BuntImpl) ConsumeOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error) {
+	var token model.OneTimeToken
+	err := repo.DB.Update(func(tx *buntdb.Tx) error {
+		val, err := tx.Delete(oneTimeTokenKey(purpose, tokenHash))
+		if err != nil {
+			return notFound(err, "one-time token") // Return error if the token is unknown, used or expired.
+		}
+		return json.Unmarshal([]byte(val), &token)
+	})
+	if err != nil {
+		return nil, err // Return error if consuming fails.
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, fmt.Errorf("one-time token %w", ErrNotFound) // Expired tokens behave like unknown ones.
+	}
+	return &token, nil // Return the consumed token.
+}
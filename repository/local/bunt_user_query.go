@@ -0,0 +1,196 @@
+package local
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/gjson"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// ErrInvalidCursor is returned for page cursors that were not issued for the same sort order.
+var ErrInvalidCursor = fmt.Errorf("invalid page cursor: %w", ErrInvalidData)
+
+// userIndexes maps every sortable field to the BuntDB index ordering users by it.
+var userIndexes = map[string]string{
+	model.SortByCreatedAt: "users_by_created_at",
+	model.SortByUsername:  "users_by_username",
+	model.SortByLastname:  "users_by_lastname",
+}
+
+// createUserIndexes creates the indexes users are sorted and paged by. Existing records are indexed on creation.
+func createUserIndexes(db *buntdb.DB) error {
+	if err := db.CreateIndex(userIndexes[model.SortByCreatedAt], "user:*", indexJSONTime("created_at")); err != nil {
+		return err
+	}
+	if err := db.CreateIndex(userIndexes[model.SortByUsername], "user:*", buntdb.IndexJSON("username")); err != nil {
+		return err
+	}
+	return db.CreateIndex(userIndexes[model.SortByLastname], "user:*", buntdb.IndexJSON("lastname"))
+}
+
+// indexJSONTime orders JSON documents by an RFC 3339 time field. Comparing the strings would misorder
+// timestamps whose fractional seconds have different lengths.
+func indexJSONTime(path string) func(a, b string) bool {
+	return func(a, b string) bool {
+		return gjson.Get(a, path).Time().Before(gjson.Get(b, path).Time())
+	}
+}
+
+// userCursor is the position after the last user of a page: the sort value and key of that user.
+type userCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	Key        string `json:"k"`
+}
+
+// encode makes the cursor opaque to clients.
+func (c userCursor) encode() string {
+	cursorJSON, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cursorJSON)
+}
+
+// decodeUserCursor reads a cursor and checks it belongs to the query's sort order.
+func decodeUserCursor(encoded string, sortBy string, descending bool) (*userCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.SortBy != sortBy || cursor.Descending != descending || cursor.Key == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// matchesUserQuery applies the filters of a query to a stored user document. Deleted users never match.
+func matchesUserQuery(value string, query *model.UserQuery) bool {
+	fields := gjson.GetMany(value, "role", "age", "name", "lastname", "username", "deleted_at")
+	if !fields[5].Time().IsZero() {
+		return false
+	}
+	if query.Role != "" && fields[0].String() != query.Role {
+		return false
+	}
+	age := int(fields[1].Int())
+	if age < query.MinAge || (query.MaxAge > 0 && age > query.MaxAge) {
+		return false
+	}
+	if query.NamePrefix != "" && !hasPrefixFold(fields[2].String(), query.NamePrefix) && !hasPrefixFold(fields[3].String(), query.NamePrefix) {
+		return false
+	}
+	if query.UsernamePrefix != "" && !hasPrefixFold(fields[4].String(), query.UsernamePrefix) {
+		return false
+	}
+	return true
+}
+
+// hasPrefixFold reports whether s starts with prefix, ignoring case.
+func hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// FindUsers returns a page of users matching the query. The walk seeks to the cursor in the index of the
+// sort field and stops after the page, so a page costs the same wherever it is. Pages continue after the
+// cursor, so users created or deleted between requests neither shift nor repeat entries. Total is only
+// counted when the query asks for it, which visits every user.
+func (repo *BuntImpl) FindUsers(query model.UserQuery) (*model.UserPage, error) {
+	if query.SortBy == "" {
+		query.SortBy = model.SortByCreatedAt
+	}
+	index, ok := userIndexes[query.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q: %w", query.SortBy, ErrInvalidData)
+	}
+
+	if query.Limit < 1 {
+		return nil, fmt.Errorf("page limit must be positive: %w", ErrInvalidData)
+	}
+
+	var cursor *userCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeUserCursor(query.Cursor, query.SortBy, query.Descending); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &model.UserPage{Users: []*model.User{}}
+	err := repo.DB.View(func(tx *buntdb.Tx) error {
+		if query.CountTotal {
+			err := tx.Ascend(index, func(key, value string) bool {
+				if matchesUserQuery(value, &query) {
+					page.Total++
+				}
+				return true // Continue iterating.
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		less, err := tx.GetLess(index)
+		if err != nil {
+			return err
+		}
+
+		// The walk starts at the users sharing the cursor's sort value, those not after its key in the
+		// sort order were on an earlier page
+		var pivot string
+		if cursor != nil {
+			pivot = sortDocument(query.SortBy, cursor.Value)
+		}
+		after := func(key, value string) bool {
+			if cursor == nil {
+				return true
+			}
+			if query.Descending {
+				return less(value, pivot) || key < cursor.Key
+			}
+			return less(pivot, value) || key > cursor.Key
+		}
+
+		var lastKey, lastValue string
+		iterator := func(key, value string) bool {
+			if !after(key, value) || !matchesUserQuery(value, &query) {
+				return true // Continue iterating.
+			}
+			if len(page.Users) == query.Limit {
+				// Another match follows the full page, so there is a next page starting after its last user
+				page.Next = userCursor{SortBy: query.SortBy, Descending: query.Descending, Value: gjson.Get(lastValue, query.SortBy).String(), Key: lastKey}.encode()
+				return false
+			}
+			var user model.User
+			if err := json.Unmarshal([]byte(value), &user); err == nil {
+				page.Users = append(page.Users, &user)
+				lastKey, lastValue = key, value
+			}
+			return true
+		}
+
+		switch {
+		case cursor == nil && query.Descending:
+			return tx.Descend(index, iterator)
+		case cursor == nil:
+			return tx.Ascend(index, iterator)
+		case query.Descending:
+			return tx.DescendLessOrEqual(index, pivot, iterator)
+		default:
+			return tx.AscendGreaterOrEqual(index, pivot, iterator)
+		}
+	})
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return page, nil
+}
+
+// sortDocument builds a JSON document holding only the sort field, for comparisons with the index's less function.
+func sortDocument(sortBy string, value string) string {
+	doc, _ := json.Marshal(map[string]string{sortBy: value})
+	return string(doc)
+}
@@ -0,0 +1,47 @@
+package local
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Errors of the repository. Callers check them with errors.Is, the returned errors add context to them.
+var (
+	// ErrNotFound is returned when a record does not exist. It wraps buntdb.ErrNotFound, so checks against
+	// BuntDB's own error keep working.
+	ErrNotFound = fmt.Errorf("%w", buntdb.ErrNotFound)
+	// ErrConflict is returned when a write would break a uniqueness constraint, see ConflictError, or
+	// collides with a concurrent write, see ErrStaleVersion.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalidData is returned when the repository is asked to work with data it cannot accept.
+	ErrInvalidData = errors.New("invalid data")
+	// ErrStaleVersion is returned when a record was changed since the version the caller expected.
+	ErrStaleVersion = fmt.Errorf("stale version: %w", ErrConflict)
+)
+
+// ConflictError is returned when a user would take an email address or username another user already has.
+type ConflictError struct {
+	Field string // "email" or "username"
+}
+
+// Error implements the error interface
+func (e *ConflictError) Error() string {
+	return e.Field + " is already taken"
+}
+
+// Unwrap makes a ConflictError match ErrConflict.
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// notFound turns BuntDB's missing key error and database/sql's missing row error into ErrNotFound, naming
+// the kind of record that is missing. Other errors are returned unchanged.
+func notFound(err error, record string) error {
+	if err == buntdb.ErrNotFound || err == sql.ErrNoRows {
+		return fmt.Errorf("%s %w", record, ErrNotFound)
+	}
+	return err
+}
@@ -0,0 +1,451 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+)
+
+// MemoryImpl keeps every record in memory, for tests and local development. It is safe for concurrent use.
+// A write works on a copy of the records that replaces them only once the write succeeded, which makes writes
+// atomic like the transactions of the other backends, at a cost that grows with the number of records.
+// Errors and latency can be injected into its methods, see FailCalls and DelayCalls.
+type MemoryImpl struct {
+	mu       sync.RWMutex
+	data     *MemoryTx     // Current records, never changed once published
+	cleanups []userCleanup // Run by PurgeDeletedUsers for every purged user, in registration order
+	faults   memoryFaults  // Errors and latency injected into the methods
+}
+
+// MemoryTx holds the records of a MemoryImpl as seen by a single read or write.
+type MemoryTx struct {
+	users         map[string]model.User          // By ID, including users that were deleted but not yet purged
+	sessions      map[string]model.Session       // By sessionKey
+	rotatedTokens map[string]memoryRotatedToken  // By refresh token hash
+	oneTimeTokens map[string]model.OneTimeToken  // By oneTimeTokenKey
+	revokedTokens map[string]time.Time           // Expiry by access token ID
+	mfa           map[string]model.MFA           // By user ID
+	loginAttempts map[string]model.LoginAttempts // By key
+	loginEvents   map[string]memoryLoginEvent    // By loginEventKey
+	roles         map[string]model.Role          // By name
+}
+
+func (*MemoryTx) backend() string { return "memory" }
+
+// MemoryUserCleanup adapts a cleanup of in-memory records. It fails if the purge runs on another backend.
+func MemoryUserCleanup(cleanup func(tx *MemoryTx, userID string) error) UserCleanup {
+	return func(tx UserCleanupTx, userID string) error {
+		memoryTx, ok := tx.(*MemoryTx)
+		if !ok {
+			return unsupportedCleanupTx("memory", tx)
+		}
+		return cleanup(memoryTx, userID)
+	}
+}
+
+// NewMemoryRepository initializes an empty in-memory repository. Unlike the other constructors it returns
+// the implementation, so that tests can inject faults.
+func NewMemoryRepository() *MemoryImpl {
+	repo := &MemoryImpl{data: (&MemoryTx{}).clone(time.Now())}
+
+	// Every subsystem that keeps records of a user removes them when the user is purged.
+	repo.RegisterUserCleanup("sessions", MemoryUserCleanup((*MemoryTx).deleteUserSessions))
+	repo.RegisterUserCleanup("one-time tokens", MemoryUserCleanup((*MemoryTx).deleteUserOneTimeTokens))
+	repo.RegisterUserCleanup("mfa", MemoryUserCleanup((*MemoryTx).deleteMFA))
+	repo.RegisterUserCleanup("login events", MemoryUserCleanup((*MemoryTx).deleteLoginEvents))
+	return repo
+}
+
+// RegisterUserCleanup adds the cleanup of a subsystem to every later purge of a user. Cleanups have to be
+// registered before the repository is used concurrently.
+func (repo *MemoryImpl) RegisterUserCleanup(name string, cleanup UserCleanup) {
+	repo.cleanups = append(repo.cleanups, userCleanup{name: name, cleanup: cleanup})
+}
+
+// view runs fn on the current records, which fn must not change.
+func (repo *MemoryImpl) view(fn func(tx *MemoryTx) error) error {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return fn(repo.data)
+}
+
+// update runs fn on a copy of the records and publishes the copy if fn succeeds. Writes run one at a time.
+func (repo *MemoryImpl) update(fn func(tx *MemoryTx) error) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	tx := repo.data.clone(time.Now())
+	if err := fn(tx); err != nil {
+		return err // Nothing is published, the write is rolled back.
+	}
+	repo.data = tx
+	return nil
+}
+
+// clone copies the records, leaving out those that expired before now.
+func (tx *MemoryTx) clone(now time.Time) *MemoryTx {
+	c := &MemoryTx{
+		users:         make(map[string]model.User, len(tx.users)),
+		sessions:      make(map[string]model.Session, len(tx.sessions)),
+		rotatedTokens: make(map[string]memoryRotatedToken, len(tx.rotatedTokens)),
+		oneTimeTokens: make(map[string]model.OneTimeToken, len(tx.oneTimeTokens)),
+		revokedTokens: make(map[string]time.Time, len(tx.revokedTokens)),
+		mfa:           make(map[string]model.MFA, len(tx.mfa)),
+		loginAttempts: make(map[string]model.LoginAttempts, len(tx.loginAttempts)),
+		loginEvents:   make(map[string]memoryLoginEvent, len(tx.loginEvents)),
+		roles:         make(map[string]model.Role, len(tx.roles)),
+	}
+	for id, user := range tx.users {
+		c.users[id] = user
+	}
+	for key, session := range tx.sessions {
+		if session.ExpiresAt.After(now) {
+			c.sessions[key] = session
+		}
+	}
+	for hash, rotated := range tx.rotatedTokens {
+		if rotated.expiresAt.After(now) {
+			c.rotatedTokens[hash] = rotated
+		}
+	}
+	for key, token := range tx.oneTimeTokens {
+		if token.ExpiresAt.After(now) {
+			c.oneTimeTokens[key] = token
+		}
+	}
+	for tokenID, expiresAt := range tx.revokedTokens {
+		if expiresAt.After(now) {
+			c.revokedTokens[tokenID] = expiresAt
+		}
+	}
+	for userID, mfa := range tx.mfa {
+		c.mfa[userID] = mfa
+	}
+	for key, attempts := range tx.loginAttempts {
+		if attempts.ExpiresAt.After(now) {
+			c.loginAttempts[key] = attempts
+		}
+	}
+	for key, event := range tx.loginEvents {
+		if event.expiresAt.After(now) {
+			c.loginEvents[key] = event
+		}
+	}
+	for name, role := range tx.roles {
+		c.roles[name] = role
+	}
+	return c
+}
+
+// storedUser reads a user, including users that were deleted but not yet purged.
+func (tx *MemoryTx) storedUser(userID string) (*model.User, error) {
+	user, ok := tx.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user %w", ErrNotFound) // Return error if the user is not found.
+	}
+	return &user, nil
+}
+
+// user reads a user. Deleted users are reported as missing.
+func (tx *MemoryTx) user(userID string) (*model.User, error) {
+	user, err := tx.storedUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDeleted() {
+		return nil, fmt.Errorf("user %w", ErrNotFound) // Return not found, deleted users only exist for RestoreOneByID and the purge.
+	}
+	return user, nil
+}
+
+// lookupOwner returns the ID of the stored user a lookup key belongs to. Deleted users keep their lookup keys.
+func (tx *MemoryTx) lookupOwner(key string) (string, bool) {
+	for id, user := range tx.users {
+		for _, lookup := range userLookups(&user) {
+			if lookup.key == key {
+				return id, true
+			}
+		}
+	}
+	return "", false
+}
+
+// setUser writes a user whose email address and username no other user has. previous is the stored version
+// of the user, nil when the user is new. Every write increments the version.
+func (tx *MemoryTx) setUser(user *model.User, previous *model.User) error {
+	user.Version = 1
+	if previous != nil {
+		user.Version = previous.Version + 1
+	}
+	for _, lookup := range userLookups(user) {
+		if owner, ok := tx.lookupOwner(lookup.key); ok && owner != user.ID {
+			return &ConflictError{Field: lookup.field} // Return error if another user has this value.
+		}
+	}
+	tx.users[user.ID] = *user
+	return nil
+}
+
+// Create saves a new user.
+func (repo *MemoryImpl) Create(user *model.User) error {
+	if err := repo.faults.inject("Create"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		return tx.setUser(user, nil)
+	})
+}
+
+// FindOneByID retrieves a user by their unique ID.
+func (repo *MemoryImpl) FindOneByID(userID string) (*model.User, error) {
+	if err := repo.faults.inject("FindOneByID"); err != nil {
+		return nil, err
+	}
+	var user *model.User
+	err := repo.view(func(tx *MemoryTx) error {
+		var err error
+		user, err = tx.user(userID)
+		return err
+	})
+	if err != nil {
+		return nil, err // Return error if the user is not found.
+	}
+	return user, nil // Return the retrieved user.
+}
+
+// FindAll retrieves all users, except deleted ones, ordered by ID.
+func (repo *MemoryImpl) FindAll() ([]*model.User, error) {
+	if err := repo.faults.inject("FindAll"); err != nil {
+		return nil, err
+	}
+	return repo.findAllUsers(func(user *model.User) bool {
+		return !user.IsDeleted()
+	}), nil
+}
+
+// FindDeletedUsers retrieves the users that were deleted but not yet purged.
+func (repo *MemoryImpl) FindDeletedUsers() ([]*model.User, error) {
+	if err := repo.faults.inject("FindDeletedUsers"); err != nil {
+		return nil, err
+	}
+	return repo.findAllUsers(func(user *model.User) bool {
+		return user.IsDeleted()
+	}), nil
+}
+
+// findAllUsers retrieves every stored user that match accepts, ordered by ID.
+func (repo *MemoryImpl) findAllUsers(match func(user *model.User) bool) []*model.User {
+	var users []*model.User
+	_ = repo.view(func(tx *MemoryTx) error {
+		for _, user := range tx.users {
+			user := user
+			if match(&user) {
+				users = append(users, &user)
+			}
+		}
+		return nil
+	})
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users
+}
+
+// UpdateOneByID updates user data for a given user ID and returns the updated user. Unless expectedVersion
+// is AnyVersion, the update fails with ErrStaleVersion if the user was changed since that version.
+func (repo *MemoryImpl) UpdateOneByID(userID string, updateData *model.User, expectedVersion int64) (*model.User, error) {
+	if err := repo.faults.inject("UpdateOneByID"); err != nil {
+		return nil, err
+	}
+
+	// Hashing the password if it's changed, outside the write because it is slow.
+	var hashedPassword string
+	if updateData.Password != "" {
+		var err error
+		if hashedPassword, err = password.HashPassword(updateData.Password); err != nil {
+			return nil, fmt.Errorf("password hash error: %v", err) // Return error if password hashing fails.
+		}
+	}
+
+	var user model.User
+	err := repo.update(func(tx *MemoryTx) error {
+		previous, err := tx.user(userID)
+		if err != nil {
+			return err // Return error if user not found.
+		}
+		if expectedVersion != AnyVersion && previous.Version != expectedVersion {
+			return ErrStaleVersion // Return error if someone else changed the user in the meantime.
+		}
+
+		// Update the desired fields in the user struct.
+		user = *previous
+		user.UpdateFields(updateData)
+		if hashedPassword != "" {
+			user.Password = hashedPassword // Update user password.
+		}
+		return tx.setUser(&user, previous)
+	})
+	if err != nil {
+		return nil, err // Return error if the update fails.
+	}
+	return &user, nil // Return the updated user.
+}
+
+// ModifyOneByID reads a user, applies modify and writes the result back in a single write.
+// If modify returns an error nothing is written and the error is returned.
+func (repo *MemoryImpl) ModifyOneByID(userID string, modify func(user *model.User) error) (*model.User, error) {
+	if err := repo.faults.inject("ModifyOneByID"); err != nil {
+		return nil, err
+	}
+	var user model.User
+	err := repo.update(func(tx *MemoryTx) error {
+		previous, err := tx.user(userID)
+		if err != nil {
+			return err // Return error if the user is not found.
+		}
+
+		// Apply the requested changes.
+		user = *previous
+		if err := modify(&user); err != nil {
+			return err
+		}
+		return tx.setUser(&user, previous)
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return &user, nil // Return the modified user.
+}
+
+// DeleteOneByID soft deletes a user: it is marked as deleted, signed out and hidden from every lookup, but
+// keeps its email address and username until PurgeDeletedUsers removes it for good. Until then
+// RestoreOneByID brings it back.
+func (repo *MemoryImpl) DeleteOneByID(userID string) error {
+	if err := repo.faults.inject("DeleteOneByID"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		previous, err := tx.user(userID)
+		if err != nil {
+			return err // Return error if user not found or already deleted.
+		}
+
+		// End every session and drop pending links, a restored user signs in again.
+		if err := tx.deleteUserSessions(userID); err != nil {
+			return fmt.Errorf("error deleting sessions of user: %w", err)
+		}
+		if err := tx.deleteUserOneTimeTokens(userID); err != nil {
+			return fmt.Errorf("error deleting one-time tokens of user: %w", err)
+		}
+
+		user := *previous
+		user.DeletedAt = time.Now().UTC()
+		return tx.setUser(&user, previous)
+	})
+}
+
+// RestoreOneByID undoes the deletion of a user that was deleted after deletedAfter. Users that are not
+// deleted, or were deleted before deletedAfter, are reported as not found.
+func (repo *MemoryImpl) RestoreOneByID(userID string, deletedAfter time.Time) (*model.User, error) {
+	if err := repo.faults.inject("RestoreOneByID"); err != nil {
+		return nil, err
+	}
+	var user model.User
+	err := repo.update(func(tx *MemoryTx) error {
+		previous, err := tx.storedUser(userID)
+		if err != nil {
+			return err // Return error if the user is not found.
+		}
+		if !previous.IsDeleted() || previous.DeletedAt.Before(deletedAfter) {
+			return fmt.Errorf("deleted user %w", ErrNotFound) // Return error if there is nothing to restore.
+		}
+
+		user = *previous
+		user.DeletedAt = time.Time{}
+		return tx.setUser(&user, previous)
+	})
+	if err != nil {
+		return nil, err // Return error if the restore fails.
+	}
+	return &user, nil // Return the restored user.
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before deletedBefore and, through the registered
+// cleanups, every record tied to them. Each user is removed in its own write, so a failing cleanup keeps
+// only that user. It returns how many users were removed.
+func (repo *MemoryImpl) PurgeDeletedUsers(deletedBefore time.Time) (int, error) {
+	if err := repo.faults.inject("PurgeDeletedUsers"); err != nil {
+		return 0, err
+	}
+	expired := repo.findAllUsers(func(user *model.User) bool {
+		return user.IsDeleted() && user.DeletedAt.Before(deletedBefore)
+	})
+
+	purged := 0
+	for _, user := range expired {
+		err := repo.update(func(tx *MemoryTx) error {
+			return repo.purgeUser(tx, user.ID, deletedBefore)
+		})
+		if err != nil {
+			return purged, fmt.Errorf("error purging user %s: %w", user.ID, err) // Return error if a user cannot be removed.
+		}
+		purged++
+	}
+	return purged, nil // Return the number of removed users.
+}
+
+// purgeUser removes a user deleted before deletedBefore and the records of every registered cleanup.
+// A user restored in the meantime is left alone.
+func (repo *MemoryImpl) purgeUser(tx *MemoryTx, userID string, deletedBefore time.Time) error {
+	user, err := tx.storedUser(userID)
+	if err != nil {
+		return err // Return error if the user is not found.
+	}
+	if !user.IsDeleted() || !user.DeletedAt.Before(deletedBefore) {
+		return nil // Restored since it was found, nothing to purge.
+	}
+	delete(tx.users, userID)
+	return runUserCleanups(repo.cleanups, tx, userID)
+}
+
+// FindOneByEmail retrieves a user by their email address.
+func (repo *MemoryImpl) FindOneByEmail(email string) (*model.User, error) {
+	if err := repo.faults.inject("FindOneByEmail"); err != nil {
+		return nil, err
+	}
+	return repo.findOneByLookup(emailKey(email))
+}
+
+// FindOneByUsername retrieves a user by their username.
+func (repo *MemoryImpl) FindOneByUsername(username string) (*model.User, error) {
+	if err := repo.faults.inject("FindOneByUsername"); err != nil {
+		return nil, err
+	}
+	return repo.findOneByLookup(usernameKey(username))
+}
+
+// findOneByLookup resolves a lookup key to its user.
+func (repo *MemoryImpl) findOneByLookup(key string) (*model.User, error) {
+	var user *model.User
+	err := repo.view(func(tx *MemoryTx) error {
+		userID, ok := tx.lookupOwner(key)
+		if !ok {
+			return fmt.Errorf("user %w", ErrNotFound)
+		}
+		var err error
+		user, err = tx.user(userID)
+		return err
+	})
+	if err != nil {
+		return nil, err // Return error if no user has this email or username.
+	}
+	return user, nil // Return the found user.
+}
+
+// Close releases nothing, the records are dropped with the repository.
+func (repo *MemoryImpl) Close() error {
+	return repo.faults.inject("Close")
+}
@@ -0,0 +1,257 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// memoryLoginEvent is a login event together with the time it is forgotten.
+type memoryLoginEvent struct {
+	event     model.LoginEvent
+	expiresAt time.Time
+}
+
+// copyMFA returns a copy of a second factor that shares no recovery codes with the original.
+func copyMFA(mfa model.MFA) *model.MFA {
+	mfa.RecoveryCodes = append([]string(nil), mfa.RecoveryCodes...)
+	return &mfa
+}
+
+// FindMFA retrieves the second factor of a user. A user without a second factor gets a disabled record.
+func (repo *MemoryImpl) FindMFA(userID string) (*model.MFA, error) {
+	if err := repo.faults.inject("FindMFA"); err != nil {
+		return nil, err
+	}
+	mfa := model.MFA{UserID: userID}
+	_ = repo.view(func(tx *MemoryTx) error {
+		if stored, ok := tx.mfa[userID]; ok {
+			mfa = stored
+		}
+		return nil
+	})
+	return copyMFA(mfa), nil
+}
+
+// ModifyMFA reads the second factor of a user, applies modify and writes the result back in a single write.
+// A user without a second factor starts from an empty record. If modify returns an error nothing is written.
+func (repo *MemoryImpl) ModifyMFA(userID string, modify func(mfa *model.MFA) error) (*model.MFA, error) {
+	if err := repo.faults.inject("ModifyMFA"); err != nil {
+		return nil, err
+	}
+	mfa := &model.MFA{UserID: userID}
+	err := repo.update(func(tx *MemoryTx) error {
+		if stored, ok := tx.mfa[userID]; ok {
+			mfa = copyMFA(stored)
+		}
+
+		// Apply the requested changes.
+		if err := modify(mfa); err != nil {
+			return err
+		}
+		tx.mfa[userID] = *copyMFA(*mfa)
+		return nil
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return mfa, nil
+}
+
+// DeleteMFA removes the second factor of a user. Deleting a missing record is not an error.
+func (repo *MemoryImpl) DeleteMFA(userID string) error {
+	if err := repo.faults.inject("DeleteMFA"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		return tx.deleteMFA(userID)
+	})
+}
+
+// deleteMFA removes the second factor of a user within a write.
+func (tx *MemoryTx) deleteMFA(userID string) error {
+	delete(tx.mfa, userID)
+	return nil
+}
+
+// FindLoginAttempts retrieves the failed login counter for a key. Unknown keys have no failures.
+func (repo *MemoryImpl) FindLoginAttempts(key string) (*model.LoginAttempts, error) {
+	if err := repo.faults.inject("FindLoginAttempts"); err != nil {
+		return nil, err
+	}
+	var attempts model.LoginAttempts
+	_ = repo.view(func(tx *MemoryTx) error {
+		if stored, ok := tx.loginAttempts[key]; ok && stored.ExpiresAt.After(time.Now()) {
+			attempts = stored
+		}
+		return nil
+	})
+	return &attempts, nil
+}
+
+// ModifyLoginAttempts reads the failed login counter for a key, applies modify and writes it back in a
+// single write, so concurrent failures are all counted. The counter expires at its ExpiresAt.
+func (repo *MemoryImpl) ModifyLoginAttempts(key string, modify func(attempts *model.LoginAttempts) error) (*model.LoginAttempts, error) {
+	if err := repo.faults.inject("ModifyLoginAttempts"); err != nil {
+		return nil, err
+	}
+	var attempts model.LoginAttempts
+	err := repo.update(func(tx *MemoryTx) error {
+		attempts = tx.loginAttempts[key] // Expired counters were left out of the write.
+
+		// Apply the requested changes.
+		if err := modify(&attempts); err != nil {
+			return err
+		}
+		if !attempts.ExpiresAt.After(time.Now()) {
+			delete(tx.loginAttempts, key) // An expired counter is simply forgotten.
+			return nil
+		}
+		tx.loginAttempts[key] = attempts
+		return nil
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return &attempts, nil
+}
+
+// ResetLoginAttempts forgets the failed logins of a key, e.g. after a successful login or an unlock.
+func (repo *MemoryImpl) ResetLoginAttempts(key string) error {
+	if err := repo.faults.inject("ResetLoginAttempts"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		delete(tx.loginAttempts, key)
+		return nil
+	})
+}
+
+// SaveLoginEvent appends an event to the login history of a user. It is forgotten after ttl.
+func (repo *MemoryImpl) SaveLoginEvent(event *model.LoginEvent, ttl time.Duration) error {
+	if err := repo.faults.inject("SaveLoginEvent"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		key := newLoginEventKey(event.UserID, event.CreatedAt, func(key string) bool {
+			_, ok := tx.loginEvents[key]
+			return ok
+		})
+		tx.loginEvents[key] = memoryLoginEvent{event: *event, expiresAt: time.Now().Add(ttl)}
+		return nil
+	})
+}
+
+// FindLoginEvents retrieves the most recent login events of a user, newest first. A limit of 0 returns all of them.
+func (repo *MemoryImpl) FindLoginEvents(userID string, limit int) ([]*model.LoginEvent, error) {
+	if err := repo.faults.inject("FindLoginEvents"); err != nil {
+		return nil, err
+	}
+	var keys []string
+	var events []*model.LoginEvent
+	_ = repo.view(func(tx *MemoryTx) error {
+		now := time.Now()
+		for key, stored := range tx.loginEvents {
+			if stored.event.UserID == userID && stored.expiresAt.After(now) {
+				keys = append(keys, key)
+			}
+		}
+		// The keys of a user sort chronologically, see loginEventKey
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+		if limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+		}
+		for _, key := range keys {
+			event := tx.loginEvents[key].event
+			events = append(events, &event)
+		}
+		return nil
+	})
+	return events, nil
+}
+
+// DeleteLoginEvents removes the login history of a user.
+func (repo *MemoryImpl) DeleteLoginEvents(userID string) error {
+	if err := repo.faults.inject("DeleteLoginEvents"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		return tx.deleteLoginEvents(userID)
+	})
+}
+
+// deleteLoginEvents removes the login history of a user within a write.
+func (tx *MemoryTx) deleteLoginEvents(userID string) error {
+	for key, stored := range tx.loginEvents {
+		if stored.event.UserID == userID {
+			delete(tx.loginEvents, key)
+		}
+	}
+	return nil
+}
+
+// copyRole returns a copy of a role definition that shares no permissions with the original.
+func copyRole(role model.Role) *model.Role {
+	role.Permissions = append([]string(nil), role.Permissions...)
+	return &role
+}
+
+// SaveRole stores a role definition, replacing an existing role of the same name.
+func (repo *MemoryImpl) SaveRole(role *model.Role) error {
+	if err := repo.faults.inject("SaveRole"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		tx.roles[role.Name] = *copyRole(*role)
+		return nil
+	})
+}
+
+// FindRole retrieves a role definition by its name.
+func (repo *MemoryImpl) FindRole(name string) (*model.Role, error) {
+	if err := repo.faults.inject("FindRole"); err != nil {
+		return nil, err
+	}
+	var role *model.Role
+	_ = repo.view(func(tx *MemoryTx) error {
+		if stored, ok := tx.roles[name]; ok {
+			role = copyRole(stored)
+		}
+		return nil
+	})
+	if role == nil {
+		return nil, fmt.Errorf("role %w", ErrNotFound)
+	}
+	return role, nil
+}
+
+// FindAllRoles retrieves every stored role definition, ordered by name.
+func (repo *MemoryImpl) FindAllRoles() ([]*model.Role, error) {
+	if err := repo.faults.inject("FindAllRoles"); err != nil {
+		return nil, err
+	}
+	var roles []*model.Role
+	_ = repo.view(func(tx *MemoryTx) error {
+		for _, role := range tx.roles {
+			roles = append(roles, copyRole(role))
+		}
+		return nil
+	})
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles, nil
+}
+
+// DeleteRole removes a role definition. Deleting a missing role is not an error.
+func (repo *MemoryImpl) DeleteRole(name string) error {
+	if err := repo.faults.inject("DeleteRole"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		delete(tx.roles, name)
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package local
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// memoryFaults are the errors and latency injected into the methods of a MemoryImpl, by method name.
+type memoryFaults struct {
+	mu     sync.Mutex
+	errors map[string]error
+	delays map[string]time.Duration
+}
+
+// checkRepositoryMethod panics if method is not a method of the Repository interface, so a misspelled
+// name fails the test instead of injecting nothing.
+func checkRepositoryMethod(method string) {
+	if _, ok := reflect.TypeOf((*Repository)(nil)).Elem().MethodByName(method); !ok {
+		panic(fmt.Sprintf("local: %q is not a Repository method", method))
+	}
+}
+
+// FailCalls makes every later call of the named Repository method return err without touching the records,
+// e.g. FailCalls("SaveSession", errors.New("disk full")). A nil err lets the method succeed again.
+func (repo *MemoryImpl) FailCalls(method string, err error) {
+	checkRepositoryMethod(method)
+	repo.faults.mu.Lock()
+	defer repo.faults.mu.Unlock()
+	if repo.faults.errors == nil {
+		repo.faults.errors = map[string]error{}
+	}
+	if err == nil {
+		delete(repo.faults.errors, method)
+		return
+	}
+	repo.faults.errors[method] = err
+}
+
+// DelayCalls makes every later call of the named Repository method wait d before it runs, e.g. to widen the
+// window for concurrent requests. A zero d removes the delay.
+func (repo *MemoryImpl) DelayCalls(method string, d time.Duration) {
+	checkRepositoryMethod(method)
+	repo.faults.mu.Lock()
+	defer repo.faults.mu.Unlock()
+	if repo.faults.delays == nil {
+		repo.faults.delays = map[string]time.Duration{}
+	}
+	if d <= 0 {
+		delete(repo.faults.delays, method)
+		return
+	}
+	repo.faults.delays[method] = d
+}
+
+// ResetFaults removes every injected error and delay.
+func (repo *MemoryImpl) ResetFaults() {
+	repo.faults.mu.Lock()
+	defer repo.faults.mu.Unlock()
+	repo.faults.errors = nil
+	repo.faults.delays = nil
+}
+
+// inject waits for the delay of a method and returns its injected error, if any.
+func (f *memoryFaults) inject(method string) error {
+	f.mu.Lock()
+	delay, err := f.delays[method], f.errors[method]
+	f.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+)
+
+// memoryRotatedToken is the session family a rotated refresh token belonged to.
+type memoryRotatedToken struct {
+	userID    string
+	sessionID string
+	expiresAt time.Time
+}
+
+// session reads a session that has not expired.
+func (tx *MemoryTx) session(userID string, sessionID string) (*model.Session, error) {
+	session, ok := tx.sessions[sessionKey(userID, sessionID)]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("session %w", ErrNotFound) // Return error if the session is not found.
+	}
+	return &session, nil
+}
+
+// SaveSession creates or replaces a session. Its refresh token is looked up through the session,
+// so replacing it also drops the lookup of the previous one.
+func (repo *MemoryImpl) SaveSession(session *model.Session) error {
+	if err := repo.faults.inject("SaveSession"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		tx.sessions[sessionKey(session.UserID, session.ID)] = *session
+		return nil
+	})
+}
+
+// RotateSession binds a new refresh token to the session, provided the session is still bound to previousRefreshTokenHash.
+// The previous token is remembered as rotated until it would have expired, so that replaying it can be detected.
+func (repo *MemoryImpl) RotateSession(session *model.Session, previousRefreshTokenHash string) error {
+	if err := repo.faults.inject("RotateSession"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		// Read the stored session to make sure nobody rotated it in the meantime.
+		current, err := tx.session(session.UserID, session.ID)
+		if err != nil {
+			return err // Return error if the session is gone.
+		}
+		if current.RefreshTokenHash != previousRefreshTokenHash {
+			return ErrRefreshTokenReused // The presented token was already rotated by another request.
+		}
+
+		// Remember the previous token as rotated out of the family.
+		tx.rotatedTokens[previousRefreshTokenHash] = memoryRotatedToken{userID: session.UserID, sessionID: session.ID, expiresAt: current.ExpiresAt}
+		tx.sessions[sessionKey(session.UserID, session.ID)] = *session
+		return nil
+	})
+}
+
+// FindRotatedRefreshToken resolves a refresh token hash that has already been rotated to the session family it belonged to.
+func (repo *MemoryImpl) FindRotatedRefreshToken(refreshTokenHash string) (string, string, error) {
+	if err := repo.faults.inject("FindRotatedRefreshToken"); err != nil {
+		return "", "", err
+	}
+	var rotated memoryRotatedToken
+	err := repo.view(func(tx *MemoryTx) error {
+		var ok bool
+		if rotated, ok = tx.rotatedTokens[refreshTokenHash]; !ok || !rotated.expiresAt.After(time.Now()) {
+			return fmt.Errorf("rotated refresh token %w", ErrNotFound) // Return error if the token was never rotated.
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return rotated.userID, rotated.sessionID, nil // Return the owner of the token family.
+}
+
+// FindSession retrieves a single session of a user.
+func (repo *MemoryImpl) FindSession(userID string, sessionID string) (*model.Session, error) {
+	if err := repo.faults.inject("FindSession"); err != nil {
+		return nil, err
+	}
+	var session *model.Session
+	err := repo.view(func(tx *MemoryTx) error {
+		var err error
+		session, err = tx.session(userID, sessionID)
+		return err
+	})
+	if err != nil {
+		return nil, err // Return error if the session is not found.
+	}
+	return session, nil // Return the found session.
+}
+
+// FindSessionByRefreshToken retrieves the session currently bound to the given refresh token hash.
+func (repo *MemoryImpl) FindSessionByRefreshToken(refreshTokenHash string) (*model.Session, error) {
+	if err := repo.faults.inject("FindSessionByRefreshToken"); err != nil {
+		return nil, err
+	}
+	var found *model.Session
+	_ = repo.view(func(tx *MemoryTx) error {
+		now := time.Now()
+		for _, session := range tx.sessions {
+			if session.RefreshTokenHash == refreshTokenHash && session.ExpiresAt.After(now) {
+				found = &session
+				break
+			}
+		}
+		return nil
+	})
+	if found == nil {
+		return nil, fmt.Errorf("refresh token %w", ErrNotFound) // Return error if the refresh token is unknown.
+	}
+	return found, nil // Return the found session.
+}
+
+// FindSessionsByUserID retrieves all active sessions of a user, ordered by ID.
+func (repo *MemoryImpl) FindSessionsByUserID(userID string) ([]*model.Session, error) {
+	if err := repo.faults.inject("FindSessionsByUserID"); err != nil {
+		return nil, err
+	}
+	sessions := []*model.Session{}
+	_ = repo.view(func(tx *MemoryTx) error {
+		now := time.Now()
+		for _, session := range tx.sessions {
+			session := session
+			if session.UserID == userID && session.ExpiresAt.After(now) {
+				sessions = append(sessions, &session)
+			}
+		}
+		return nil
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil // Return the slice of sessions.
+}
+
+// DeleteSession removes a single session.
+func (repo *MemoryImpl) DeleteSession(userID string, sessionID string) error {
+	if err := repo.faults.inject("DeleteSession"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		if _, err := tx.session(userID, sessionID); err != nil {
+			return err // Return error if the session is not found.
+		}
+		delete(tx.sessions, sessionKey(userID, sessionID))
+		return nil
+	})
+}
+
+// DeleteSessionsByUserID removes every session of a user, signing them out on all devices.
+func (repo *MemoryImpl) DeleteSessionsByUserID(userID string) error {
+	if err := repo.faults.inject("DeleteSessionsByUserID"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		tx.deleteSessionsByUserID(userID)
+		return nil
+	})
+}
+
+// deleteSessionsByUserID removes every session of a user within a write.
+func (tx *MemoryTx) deleteSessionsByUserID(userID string) {
+	for key, session := range tx.sessions {
+		if session.UserID == userID {
+			delete(tx.sessions, key)
+		}
+	}
+}
+
+// deleteUserSessions signs a deleted user out everywhere: the access token of every session is revoked,
+// the sessions are removed, and so are the rotated tokens of their families.
+func (tx *MemoryTx) deleteUserSessions(userID string) error {
+	for _, session := range tx.sessions {
+		if session.UserID == userID && session.AccessTokenID != "" {
+			tx.revokeAccessToken(session.AccessTokenID, jwt.AccessTokenTTL)
+		}
+	}
+	tx.deleteSessionsByUserID(userID)
+	for hash, rotated := range tx.rotatedTokens {
+		if rotated.userID == userID {
+			delete(tx.rotatedTokens, hash)
+		}
+	}
+	return nil
+}
+
+// RevokeAccessToken adds an access token to the revocation list. The entry expires after ttl,
+// which should be at least the remaining lifetime of the token.
+func (repo *MemoryImpl) RevokeAccessToken(tokenID string, ttl time.Duration) error {
+	if err := repo.faults.inject("RevokeAccessToken"); err != nil {
+		return err
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		tx.revokeAccessToken(tokenID, ttl)
+		return nil
+	})
+}
+
+// revokeAccessToken adds an access token to the revocation list within a write.
+func (tx *MemoryTx) revokeAccessToken(tokenID string, ttl time.Duration) {
+	tx.revokedTokens[tokenID] = time.Now().Add(ttl)
+}
+
+// IsAccessTokenRevoked reports whether an access token is on the revocation list.
+func (repo *MemoryImpl) IsAccessTokenRevoked(tokenID string) (bool, error) {
+	if err := repo.faults.inject("IsAccessTokenRevoked"); err != nil {
+		return false, err
+	}
+	var revoked bool
+	_ = repo.view(func(tx *MemoryTx) error {
+		expiresAt, ok := tx.revokedTokens[tokenID]
+		revoked = ok && expiresAt.After(time.Now())
+		return nil
+	})
+	return revoked, nil
+}
@@ -0,0 +1,138 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+func TestMemoryFailCalls(t *testing.T) {
+	repo := NewMemoryRepository()
+	if err := repo.Create(&model.User{ID: "1", Username: "john", Email: "john@example.com"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	errDisk := errors.New("disk full")
+
+	testCases := []struct {
+		name   string
+		method string
+		call   func() error
+	}{
+		{name: "Write", method: "SaveSession", call: func() error {
+			return repo.SaveSession(&model.Session{UserID: "1", ID: "s1", ExpiresAt: time.Now().Add(time.Hour)})
+		}},
+		{name: "Read", method: "FindOneByID", call: func() error {
+			_, err := repo.FindOneByID("1")
+			return err
+		}},
+		{name: "Modify", method: "ModifyOneByID", call: func() error {
+			_, err := repo.ModifyOneByID("1", func(user *model.User) error {
+				user.Name = "John"
+				return nil
+			})
+			return err
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo.FailCalls(tc.method, errDisk)
+			if err := tc.call(); !errors.Is(err, errDisk) {
+				t.Fatalf("%s() error = %v, want injected error", tc.method, err)
+			}
+			repo.FailCalls(tc.method, nil)
+			if err := tc.call(); err != nil {
+				t.Fatalf("%s() error = %v after removing the fault", tc.method, err)
+			}
+		})
+	}
+
+	// A failed call leaves the records untouched, other methods keep working
+	repo.FailCalls("UpdateOneByID", errDisk)
+	if _, err := repo.UpdateOneByID("1", &model.User{Name: "Johnny"}, AnyVersion); !errors.Is(err, errDisk) {
+		t.Fatalf("UpdateOneByID() error = %v, want injected error", err)
+	}
+	user, err := repo.FindOneByID("1")
+	if err != nil || user.Name != "John" {
+		t.Fatalf("FindOneByID() = %v, %v, want unchanged user", user, err)
+	}
+
+	repo.ResetFaults()
+	if _, err := repo.UpdateOneByID("1", &model.User{Name: "Johnny"}, AnyVersion); err != nil {
+		t.Fatalf("UpdateOneByID() error = %v after ResetFaults()", err)
+	}
+}
+
+func TestMemoryDelayCalls(t *testing.T) {
+	repo := NewMemoryRepository()
+	repo.DelayCalls("FindAll", 50*time.Millisecond)
+
+	start := time.Now()
+	if _, err := repo.FindAll(); err != nil {
+		t.Fatalf("FindAll() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("FindAll() took %v, want at least the injected delay", elapsed)
+	}
+
+	repo.DelayCalls("FindAll", 0)
+	start = time.Now()
+	if _, err := repo.FindAll(); err != nil {
+		t.Fatalf("FindAll() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Fatalf("FindAll() took %v after removing the delay", elapsed)
+	}
+}
+
+func TestMemoryUnknownMethod(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("FailCalls() accepted a method the Repository does not have")
+		}
+	}()
+	NewMemoryRepository().FailCalls("SaveSesion", errors.New("typo"))
+}
+
+func TestMemoryConcurrentWrites(t *testing.T) {
+	repo := NewMemoryRepository()
+	if err := repo.Create(&model.User{ID: "1", Username: "john", Email: "john@example.com"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// Concurrent modifications are all applied, concurrent creations of the same email address conflict
+	const writers = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := repo.ModifyOneByID("1", func(user *model.User) error {
+				user.Age++
+				return nil
+			}); err != nil {
+				t.Errorf("ModifyOneByID() error = %v", err)
+			}
+			if err := repo.Create(&model.User{ID: fmt.Sprint("new", i), Email: "jane@example.com"}); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	user, err := repo.FindOneByID("1")
+	if err != nil || user.Age != writers {
+		t.Fatalf("FindOneByID() = %v, %v, want age %d", user, err, writers)
+	}
+	if created != 1 {
+		t.Fatalf("%d users created with the same email, want 1", created)
+	}
+}
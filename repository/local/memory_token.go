@@ -0,0 +1,72 @@
+package local
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// SaveOneTimeToken stores a one-time token by its hash until it expires.
+func (repo *MemoryImpl) SaveOneTimeToken(tokenHash string, token *model.OneTimeToken) error {
+	if err := repo.faults.inject("SaveOneTimeToken"); err != nil {
+		return err
+	}
+	if !token.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("one-time token already expired: %w", ErrInvalidData)
+	}
+	return repo.update(func(tx *MemoryTx) error {
+		tx.oneTimeTokens[oneTimeTokenKey(token.Purpose, tokenHash)] = *token
+		return nil
+	})
+}
+
+// deleteUserOneTimeTokens removes the one-time tokens issued to a user, e.g. pending password resets.
+func (tx *MemoryTx) deleteUserOneTimeTokens(userID string) error {
+	for key, token := range tx.oneTimeTokens {
+		if token.UserID == userID {
+			delete(tx.oneTimeTokens, key)
+		}
+	}
+	return nil
+}
+
+// FindOneTimeToken retrieves a one-time token without using it up.
+func (repo *MemoryImpl) FindOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error) {
+	if err := repo.faults.inject("FindOneTimeToken"); err != nil {
+		return nil, err
+	}
+	var token model.OneTimeToken
+	err := repo.view(func(tx *MemoryTx) error {
+		var ok bool
+		if token, ok = tx.oneTimeTokens[oneTimeTokenKey(purpose, tokenHash)]; !ok || !token.ExpiresAt.After(time.Now()) {
+			return fmt.Errorf("one-time token %w", ErrNotFound) // Return error if the token is unknown, used or expired.
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err // Return error if the lookup fails.
+	}
+	return &token, nil // Return the found token.
+}
+
+// ConsumeOneTimeToken retrieves and deletes a one-time token in the same write, so it can only be used once.
+func (repo *MemoryImpl) ConsumeOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error) {
+	if err := repo.faults.inject("ConsumeOneTimeToken"); err != nil {
+		return nil, err
+	}
+	var token model.OneTimeToken
+	err := repo.update(func(tx *MemoryTx) error {
+		key := oneTimeTokenKey(purpose, tokenHash)
+		var ok bool
+		if token, ok = tx.oneTimeTokens[key]; !ok || !token.ExpiresAt.After(time.Now()) {
+			return fmt.Errorf("one-time token %w", ErrNotFound) // Return error if the token is unknown, used or expired.
+		}
+		delete(tx.oneTimeTokens, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err // Return error if consuming fails.
+	}
+	return &token, nil // Return the consumed token.
+}
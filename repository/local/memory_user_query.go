@@ -0,0 +1,128 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// userSortValue returns the value of the field a user is sorted by, as it is stored in a page cursor.
+func userSortValue(sortBy string, user *model.User) string {
+	switch sortBy {
+	case model.SortByCreatedAt:
+		return user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case model.SortByLastname:
+		return user.Lastname
+	default:
+		return user.Username
+	}
+}
+
+// compareUserPosition orders a user against the position of a sort value and key in ascending order,
+// like the indexes of the other backends: times chronologically, text ignoring case, ties by ID.
+func compareUserPosition(sortBy string, user *model.User, value string, key string) int {
+	c := 0
+	if sortBy == model.SortByCreatedAt {
+		at, _ := time.Parse(time.RFC3339Nano, value)
+		c = user.CreatedAt.Compare(at)
+	} else {
+		c = strings.Compare(strings.ToLower(userSortValue(sortBy, user)), strings.ToLower(value))
+	}
+	if c == 0 {
+		c = strings.Compare(user.ID, key)
+	}
+	return c
+}
+
+// matchesMemoryUserQuery applies the filters of a query to a user. Deleted users never match.
+func matchesMemoryUserQuery(user *model.User, query *model.UserQuery) bool {
+	if user.IsDeleted() {
+		return false
+	}
+	if query.Role != "" && user.Role != query.Role {
+		return false
+	}
+	if user.Age < query.MinAge || (query.MaxAge > 0 && user.Age > query.MaxAge) {
+		return false
+	}
+	if query.NamePrefix != "" && !hasPrefixFold(user.Name, query.NamePrefix) && !hasPrefixFold(user.Lastname, query.NamePrefix) {
+		return false
+	}
+	if query.UsernamePrefix != "" && !hasPrefixFold(user.Username, query.UsernamePrefix) {
+		return false
+	}
+	return true
+}
+
+// FindUsers returns a page of users matching the query. Pages continue after the cursor in the sort order,
+// so users created or deleted between requests neither shift nor repeat entries. Total is only counted when
+// the query asks for it.
+func (repo *MemoryImpl) FindUsers(query model.UserQuery) (*model.UserPage, error) {
+	if err := repo.faults.inject("FindUsers"); err != nil {
+		return nil, err
+	}
+	if query.SortBy == "" {
+		query.SortBy = model.SortByCreatedAt
+	}
+	if _, ok := userIndexes[query.SortBy]; !ok {
+		return nil, fmt.Errorf("unknown sort field %q: %w", query.SortBy, ErrInvalidData)
+	}
+
+	if query.Limit < 1 {
+		return nil, fmt.Errorf("page limit must be positive: %w", ErrInvalidData)
+	}
+
+	var cursor *userCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeUserCursor(query.Cursor, query.SortBy, query.Descending); err != nil {
+			return nil, err
+		}
+		if _, err := time.Parse(time.RFC3339Nano, cursor.Value); query.SortBy == model.SortByCreatedAt && err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	// Collect the matches in the sort order
+	var users []*model.User
+	_ = repo.view(func(tx *MemoryTx) error {
+		for _, user := range tx.users {
+			user := user
+			if matchesMemoryUserQuery(&user, &query) {
+				users = append(users, &user)
+			}
+		}
+		return nil
+	})
+	sort.Slice(users, func(i, j int) bool {
+		c := compareUserPosition(query.SortBy, users[i], userSortValue(query.SortBy, users[j]), users[j].ID)
+		if query.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	page := &model.UserPage{Users: []*model.User{}}
+	if query.CountTotal {
+		page.Total = len(users)
+	}
+	for _, user := range users {
+		if cursor != nil {
+			c := compareUserPosition(query.SortBy, user, cursor.Value, cursor.Key)
+			if (!query.Descending && c <= 0) || (query.Descending && c >= 0) {
+				continue // Already on an earlier page.
+			}
+		}
+		if len(page.Users) == query.Limit {
+			// Another match follows the full page, so there is a next page starting after its last user
+			last := page.Users[query.Limit-1]
+			page.Next = userCursor{SortBy: query.SortBy, Descending: query.Descending, Value: userSortValue(query.SortBy, last), Key: last.ID}.encode()
+			break
+		}
+		page.Users = append(page.Users, user)
+	}
+	return page, nil
+}
@@ -2,18 +2,55 @@ package local
 
 import (
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"time"
 )
 
 type Repository interface {
+	RevocationStore
+
 	// Typed as instance
 	Create(user *model.User) error
 	FindOneByID(userID string) (*model.User, error)
 	FindAll() ([]*model.User, error)
-	UpdateOneByID(userID string, updateData *model.User) error
+	FindUsers(query model.UserQuery) (*model.UserPage, error)
+	UpdateOneByID(userID string, updateData *model.User, expectedVersion int64) (*model.User, error)
+	ModifyOneByID(userID string, modify func(user *model.User) error) (*model.User, error)
 	DeleteOneByID(userID string) error
+	RestoreOneByID(userID string, deletedAfter time.Time) (*model.User, error)
+	FindDeletedUsers() ([]*model.User, error)
+	PurgeDeletedUsers(deletedBefore time.Time) (int, error)
+	RegisterUserCleanup(name string, cleanup UserCleanup)
 	FindOneByEmail(email string) (*model.User, error)
-	SaveRefreshToken(UserID string, refreshToken string) error
-	FindRefreshToken(UserID string) (string, error)
-	DeleteRefreshToken(userID string) error
+	FindOneByUsername(username string) (*model.User, error)
+	SaveSession(session *model.Session) error
+	RotateSession(session *model.Session, previousRefreshTokenHash string) error
+	FindSession(userID string, sessionID string) (*model.Session, error)
+	FindSessionByRefreshToken(refreshTokenHash string) (*model.Session, error)
+	FindRotatedRefreshToken(refreshTokenHash string) (userID string, sessionID string, err error)
+	FindSessionsByUserID(userID string) ([]*model.Session, error)
+	DeleteSession(userID string, sessionID string) error
+	DeleteSessionsByUserID(userID string) error
+	SaveOneTimeToken(tokenHash string, token *model.OneTimeToken) error
+	FindOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error)
+	ConsumeOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error)
+	FindMFA(userID string) (*model.MFA, error)
+	ModifyMFA(userID string, modify func(mfa *model.MFA) error) (*model.MFA, error)
+	DeleteMFA(userID string) error
+	FindLoginAttempts(key string) (*model.LoginAttempts, error)
+	ModifyLoginAttempts(key string, modify func(attempts *model.LoginAttempts) error) (*model.LoginAttempts, error)
+	ResetLoginAttempts(key string) error
+	SaveRole(role *model.Role) error
+	FindRole(name string) (*model.Role, error)
+	FindAllRoles() ([]*model.Role, error)
+	DeleteRole(name string) error
+	SaveLoginEvent(event *model.LoginEvent, ttl time.Duration) error
+	FindLoginEvents(userID string, limit int) ([]*model.LoginEvent, error)
+	DeleteLoginEvents(userID string) error
 	Close() error
 }
+
+// RevocationStore keeps track of access tokens that were revoked before they expired.
+type RevocationStore interface {
+	RevokeAccessToken(tokenID string, ttl time.Duration) error
+	IsAccessTokenRevoked(tokenID string) (bool, error)
+}
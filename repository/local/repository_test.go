@@ -0,0 +1,1443 @@
+package local
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// The tests in this file are the conformance suite of the Repository interface, every storage backend has to pass them.
+
+// testBackends open an empty repository of every storage backend. PostgreSQL is only tested when
+// TEST_POSTGRES_DSN names a database the tests may create schemas in.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) (Repository, error)
+}{
+	{name: "bunt", open: func(t *testing.T) (Repository, error) {
+		return NewBuntRepository(filepath.Join(t.TempDir(), "test.db"))
+	}},
+	{name: "sqlite", open: func(t *testing.T) (Repository, error) {
+		return NewSQLRepository("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	}},
+	{name: "postgres", open: openPostgresTestRepository},
+	{name: "memory", open: func(t *testing.T) (Repository, error) {
+		return NewMemoryRepository(), nil
+	}},
+}
+
+// runBackends runs a test once for every storage backend, each time with an empty repository.
+func runBackends(t *testing.T, test func(t *testing.T, repo Repository)) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			repo, err := backend.open(t)
+			if err != nil {
+				t.Fatalf("Error creating repository: %v", err)
+			}
+			defer repo.Close()
+			test(t, repo)
+		})
+	}
+}
+
+// openPostgresTestRepository opens a repository in a new schema of the TEST_POSTGRES_DSN database, which
+// is dropped when the test ends.
+func openPostgresTestRepository(t *testing.T) (Repository, error) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	})
+
+	// Unknown connection parameters are sent to the server as settings
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return NewSQLRepository("postgres", dsn+separator+"search_path="+url.QueryEscape(schema))
+	}
+	return NewSQLRepository("postgres", dsn+" search_path="+schema)
+}
+
+func TestCreateUser(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		testCases := []struct {
+			name    string
+			user    model.User
+			wantErr bool
+		}{
+			{
+				name:    "Valid User",
+				user:    model.User{ID: "1", Username: "testuser", Email: "test@example.com", Password: "password123", Name: "Test", Lastname: "User", Age: 25},
+				wantErr: false,
+			},
+			{
+				name:    "Empty Fields",
+				user:    model.User{ID: "2", Username: "", Email: "", Password: "", Name: "", Lastname: "", Age: 0},
+				wantErr: false,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := repo.Create(&tc.user)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("Create() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+			})
+		}
+	})
+}
+
+func TestFindOneByID(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Set up a known user
+		_ = repo.Create(&model.User{ID: "123", Email: "test@example.com"})
+
+		testCases := []struct {
+			name    string
+			userID  string
+			wantErr bool
+		}{
+			{
+				name:    "User Exists",
+				userID:  "123",
+				wantErr: false,
+			},
+			{
+				name:    "User Does Not Exist",
+				userID:  "999",
+				wantErr: true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := repo.FindOneByID(tc.userID)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("FindOneByID() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+			})
+		}
+	})
+}
+
+func TestUpdateUser(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Setup: Create user
+		_ = repo.Create(&model.User{ID: "123", Email: "old@example.com", Password: "oldpass"})
+
+		testCases := []struct {
+			name        string
+			userID      string
+			updateData  model.User
+			version     int64
+			wantErr     bool
+			wantVersion int64
+		}{
+			{
+				name:        "Update Email and Password",
+				userID:      "123",
+				updateData:  model.User{Email: "new@example.com", Password: "newpass"},
+				version:     AnyVersion,
+				wantErr:     false,
+				wantVersion: 2,
+			},
+			{
+				name:       "Update Non-Existent User",
+				userID:     "999",
+				updateData: model.User{Email: "new@example.com"},
+				version:    AnyVersion,
+				wantErr:    true,
+			},
+			{
+				name:       "Update Stale Version",
+				userID:     "123",
+				updateData: model.User{Name: "John"},
+				version:    1,
+				wantErr:    true,
+			},
+			{
+				name:        "Update Current Version",
+				userID:      "123",
+				updateData:  model.User{Name: "John"},
+				version:     2,
+				wantErr:     false,
+				wantVersion: 3,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				user, err := repo.UpdateOneByID(tc.userID, &tc.updateData, tc.version)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("UpdateOneByID() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+				if err == nil && user.Version != tc.wantVersion {
+					t.Fatalf("UpdateOneByID() version = %d, want %d", user.Version, tc.wantVersion)
+				}
+			})
+		}
+	})
+}
+
+func TestModifyUser(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Setup: Create user
+		_ = repo.Create(&model.User{ID: "123", Email: "old@example.com", PendingEmail: "new@example.com"})
+
+		testCases := []struct {
+			name      string
+			userID    string
+			modify    func(user *model.User) error
+			wantErr   bool
+			wantEmail string
+		}{
+			{
+				name:   "Confirm Pending Email",
+				userID: "123",
+				modify: func(user *model.User) error {
+					user.Email, user.PendingEmail, user.EmailVerified = user.PendingEmail, "", true
+					return nil
+				},
+				wantErr:   false,
+				wantEmail: "new@example.com",
+			},
+			{
+				name:   "Rejected Modification Is Not Saved",
+				userID: "123",
+				modify: func(user *model.User) error {
+					user.Email = "other@example.com"
+					return fmt.Errorf("rejected")
+				},
+				wantErr:   true,
+				wantEmail: "new@example.com",
+			},
+			{
+				name:      "Modify Non-Existent User",
+				userID:    "999",
+				modify:    func(user *model.User) error { return nil },
+				wantErr:   true,
+				wantEmail: "new@example.com",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := repo.ModifyOneByID(tc.userID, tc.modify)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("ModifyOneByID() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+
+				user, _ := repo.FindOneByID("123")
+				if user.Email != tc.wantEmail {
+					t.Fatalf("Email = %v, want %v", user.Email, tc.wantEmail)
+				}
+			})
+		}
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Setup: Create user
+		_ = repo.Create(&model.User{ID: "123"})
+
+		testCases := []struct {
+			name    string
+			userID  string
+			wantErr bool
+		}{
+			{
+				name:    "Delete Existing User",
+				userID:  "123",
+				wantErr: false,
+			},
+			{
+				name:    "Delete Non-Existent User",
+				userID:  "999",
+				wantErr: true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := repo.DeleteOneByID(tc.userID)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("DeleteOneByID() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+			})
+		}
+	})
+}
+
+func TestFindOneByEmail(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Setup: Create user
+		_ = repo.Create(&model.User{ID: "123", Email: "test@example.com"})
+
+		testCases := []struct {
+			name    string
+			email   string
+			wantErr bool
+		}{
+			{
+				name:    "Find Existing User by Email",
+				email:   "test@example.com",
+				wantErr: false,
+			},
+			{
+				name:    "Find Non-Existent User by Email",
+				email:   "nonexistent@example.com",
+				wantErr: true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := repo.FindOneByEmail(tc.email)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("FindOneByEmail() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+			})
+		}
+	})
+}
+
+func TestFindOneByUsername(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Setup: Create user
+		_ = repo.Create(&model.User{ID: "123", Username: "testuser", Email: "test@example.com"})
+
+		testCases := []struct {
+			name     string
+			username string
+			wantErr  bool
+		}{
+			{
+				name:     "Find Existing User by Username",
+				username: "testuser",
+				wantErr:  false,
+			},
+			{
+				name:     "Find Non-Existent User by Username",
+				username: "nobody",
+				wantErr:  true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := repo.FindOneByUsername(tc.username)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("FindOneByUsername() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+			})
+		}
+	})
+}
+
+func TestFindAll(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Add multiple users to the repository
+		users := []*model.User{
+			{ID: "1", Username: "user1", Email: "user1@example.com"},
+			{ID: "2", Username: "user2", Email: "user2@example.com"},
+			{ID: "3", Username: "user3", Email: "user3@example.com"},
+		}
+
+		// Insert users into the database
+		for _, user := range users {
+			err := repo.Create(user)
+			if err != nil {
+				t.Fatalf("Error creating user %s: %v", user.Username, err)
+			}
+		}
+
+		// Call the FindAll function
+		foundUsers, err := repo.FindAll()
+		if err != nil {
+			t.Fatalf("Error finding all users: %v", err)
+		}
+
+		// Verify that all users are retrieved
+		if len(foundUsers) != len(users) {
+			t.Fatalf("Expected %d users, found %d", len(users), len(foundUsers))
+		}
+
+		// Map the found users by ID for easier comparison
+		foundUserMap := make(map[string]*model.User)
+		for _, user := range foundUsers {
+			foundUserMap[user.ID] = user
+		}
+
+		// Check that all created users are found
+		for _, user := range users {
+			foundUser, exists := foundUserMap[user.ID]
+			if !exists {
+				t.Fatalf("User with ID %s not found", user.ID)
+			}
+			if foundUser.Username != user.Username || foundUser.Email != user.Email {
+				t.Fatalf("Expected user %v, found %v", user, foundUser)
+			}
+		}
+	})
+}
+
+func TestFindUsers(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Timestamps with fractional seconds of different lengths must still sort chronologically
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		users := []*model.User{
+			{ID: "1", Username: "carol", Lastname: "Young", Name: "Carol", Age: 31, Role: model.AdminRole, CreatedAt: start.Add(1500 * time.Millisecond)},
+			{ID: "2", Username: "alice", Lastname: "Smith", Name: "Alice", Age: 25, Role: model.DefaultRole, CreatedAt: start.Add(1 * time.Second)},
+			{ID: "3", Username: "bob", Lastname: "Adams", Name: "Bob", Age: 40, Role: model.DefaultRole, CreatedAt: start.Add(1250 * time.Millisecond)},
+			{ID: "4", Username: "alex", Lastname: "Smithers", Name: "Alex", Age: 17, Role: model.DefaultRole, CreatedAt: start.Add(3 * time.Second)},
+		}
+		for _, user := range users {
+			if err := repo.Create(user); err != nil {
+				t.Fatalf("Error creating user %s: %v", user.Username, err)
+			}
+		}
+
+		testCases := []struct {
+			name      string
+			query     model.UserQuery
+			wantPages [][]string // Usernames of every page, following the cursors
+			wantTotal int
+		}{
+			{
+				name:      "Created Date In Pages",
+				query:     model.UserQuery{Limit: 3},
+				wantPages: [][]string{{"alice", "bob", "carol"}, {"alex"}},
+				wantTotal: 4,
+			},
+			{
+				name:      "Username Descending",
+				query:     model.UserQuery{SortBy: model.SortByUsername, Descending: true, Limit: 2},
+				wantPages: [][]string{{"carol", "bob"}, {"alice", "alex"}},
+				wantTotal: 4,
+			},
+			{
+				name:      "Lastname",
+				query:     model.UserQuery{SortBy: model.SortByLastname, Limit: 10},
+				wantPages: [][]string{{"bob", "alice", "alex", "carol"}},
+				wantTotal: 4,
+			},
+			{
+				name:      "Role And Age Range",
+				query:     model.UserQuery{Role: model.DefaultRole, MinAge: 18, MaxAge: 39, Limit: 10},
+				wantPages: [][]string{{"alice"}},
+				wantTotal: 1,
+			},
+			{
+				name:      "Name And Username Prefix",
+				query:     model.UserQuery{NamePrefix: "smith", UsernamePrefix: "AL", SortBy: model.SortByUsername, Limit: 1},
+				wantPages: [][]string{{"alex"}, {"alice"}},
+				wantTotal: 2,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				query := tc.query
+				query.CountTotal = true
+				var pages [][]string
+				for {
+					page, err := repo.FindUsers(query)
+					if err != nil {
+						t.Fatalf("FindUsers() error = %v", err)
+					}
+					if page.Total != tc.wantTotal {
+						t.Fatalf("FindUsers() total = %d, want %d", page.Total, tc.wantTotal)
+					}
+					var usernames []string
+					for _, user := range page.Users {
+						usernames = append(usernames, user.Username)
+					}
+					pages = append(pages, usernames)
+					if page.Next == "" || len(pages) > len(tc.wantPages) {
+						break
+					}
+					query.Cursor = page.Next
+				}
+				if !reflect.DeepEqual(pages, tc.wantPages) {
+					t.Fatalf("FindUsers() pages = %v, want %v", pages, tc.wantPages)
+				}
+			})
+		}
+
+		// Counting is left out unless asked for
+		page, err := repo.FindUsers(model.UserQuery{Limit: 1})
+		if err != nil {
+			t.Fatalf("FindUsers() error = %v", err)
+		}
+		if page.Total != 0 {
+			t.Fatalf("FindUsers() total = %d without CountTotal, want 0", page.Total)
+		}
+
+		// A cursor only continues the sort order it was issued for
+		if _, err := repo.FindUsers(model.UserQuery{SortBy: model.SortByUsername, Cursor: page.Next, Limit: 1}); err != ErrInvalidCursor {
+			t.Fatalf("FindUsers() error = %v, want %v", err, ErrInvalidCursor)
+		}
+
+		// Users sharing a sort value are paged by their keys in both directions
+		for _, user := range []*model.User{
+			{ID: "5", Username: "dave", Lastname: "adams", CreatedAt: start},
+			{ID: "6", Username: "erin", Lastname: "ADAMS", CreatedAt: start},
+		} {
+			if err := repo.Create(user); err != nil {
+				t.Fatalf("Error creating user %s: %v", user.Username, err)
+			}
+		}
+		for _, descending := range []bool{false, true} {
+			query := model.UserQuery{SortBy: model.SortByLastname, Descending: descending, Limit: 1}
+			var usernames []string
+			for {
+				page, err := repo.FindUsers(query)
+				if err != nil {
+					t.Fatalf("FindUsers() error = %v", err)
+				}
+				for _, user := range page.Users {
+					usernames = append(usernames, user.Username)
+				}
+				if page.Next == "" || len(usernames) > 6 {
+					break
+				}
+				query.Cursor = page.Next
+			}
+			want := []string{"bob", "dave", "erin", "alice", "alex", "carol"}
+			if descending {
+				want = []string{"carol", "alex", "alice", "erin", "dave", "bob"}
+			}
+			if !reflect.DeepEqual(usernames, want) {
+				t.Fatalf("FindUsers() descending %v = %v, want %v", descending, usernames, want)
+			}
+		}
+	})
+}
+
+func TestSaveSession(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Save a session for a user
+		session := &model.Session{ID: "session1", UserID: "user123", RefreshTokenHash: "hash1", ExpiresAt: time.Now().Add(time.Hour)}
+		if err := repo.SaveSession(session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+
+		// Verify that the session can be found by its refresh token
+		found, err := repo.FindSessionByRefreshToken("hash1")
+		if err != nil {
+			t.Fatalf("Error finding session: %v", err)
+		}
+		if found.UserID != "user123" || found.ID != "session1" {
+			t.Fatalf("Expected session 'user123/session1', got '%s/%s'", found.UserID, found.ID)
+		}
+
+		// Rotate the refresh token and verify the old one no longer resolves
+		session.RefreshTokenHash = "hash2"
+		if err := repo.SaveSession(session); err != nil {
+			t.Fatalf("Error rotating session: %v", err)
+		}
+		if _, err := repo.FindSessionByRefreshToken("hash1"); err == nil {
+			t.Fatalf("Expected error finding rotated refresh token, got nil")
+		}
+		if _, err := repo.FindSessionByRefreshToken("hash2"); err != nil {
+			t.Fatalf("Error finding rotated session: %v", err)
+		}
+	})
+}
+
+func TestRotateSession(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Save a session and rotate its refresh token
+		session := &model.Session{ID: "session1", UserID: "user123", RefreshTokenHash: "hash1", ExpiresAt: time.Now().Add(time.Hour)}
+		_ = repo.SaveSession(session)
+		session.RefreshTokenHash = "hash2"
+		if err := repo.RotateSession(session, "hash1"); err != nil {
+			t.Fatalf("Error rotating session: %v", err)
+		}
+
+		// The rotated token must resolve to its family instead of an active session
+		if _, err := repo.FindSessionByRefreshToken("hash1"); err == nil {
+			t.Fatalf("Expected error finding rotated refresh token, got nil")
+		}
+		userID, sessionID, err := repo.FindRotatedRefreshToken("hash1")
+		if err != nil {
+			t.Fatalf("Error finding rotated refresh token: %v", err)
+		}
+		if userID != "user123" || sessionID != "session1" {
+			t.Fatalf("Expected family 'user123/session1', got '%s/%s'", userID, sessionID)
+		}
+
+		// Rotating again from the old token is reported as reuse
+		session.RefreshTokenHash = "hash3"
+		if err := repo.RotateSession(session, "hash1"); err != ErrRefreshTokenReused {
+			t.Fatalf("RotateSession() error = %v, want %v", err, ErrRefreshTokenReused)
+		}
+	})
+}
+
+func TestFindSessionByRefreshToken(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Save a session
+		_ = repo.SaveSession(&model.Session{ID: "session1", UserID: "user123", RefreshTokenHash: "hash123", ExpiresAt: time.Now().Add(time.Hour)})
+
+		testCases := []struct {
+			name       string
+			tokenHash  string
+			wantUserID string
+			wantErr    bool
+		}{
+			{
+				name:       "Token Exists",
+				tokenHash:  "hash123",
+				wantUserID: "user123",
+				wantErr:    false,
+			},
+			{
+				name:       "Token Does Not Exist",
+				tokenHash:  "hash999",
+				wantUserID: "",
+				wantErr:    true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				session, err := repo.FindSessionByRefreshToken(tc.tokenHash)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("FindSessionByRefreshToken() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+				if session != nil && session.UserID != tc.wantUserID {
+					t.Fatalf("FindSessionByRefreshToken() userID = %v, want %v", session.UserID, tc.wantUserID)
+				}
+			})
+		}
+	})
+}
+
+func TestDeleteSession(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Save two sessions of the same user, e.g. phone and laptop
+		_ = repo.SaveSession(&model.Session{ID: "phone", UserID: "user123", RefreshTokenHash: "hash1", ExpiresAt: time.Now().Add(time.Hour)})
+		_ = repo.SaveSession(&model.Session{ID: "laptop", UserID: "user123", RefreshTokenHash: "hash2", ExpiresAt: time.Now().Add(time.Hour)})
+
+		// Delete one session
+		if err := repo.DeleteSession("user123", "phone"); err != nil {
+			t.Fatalf("Error deleting session: %v", err)
+		}
+
+		// Verify that only the deleted session is gone
+		if _, err := repo.FindSessionByRefreshToken("hash1"); err == nil {
+			t.Fatalf("Expected error finding deleted session, got nil")
+		}
+		if _, err := repo.FindSessionByRefreshToken("hash2"); err != nil {
+			t.Fatalf("Expected other session to survive, got %v", err)
+		}
+
+		// Delete the remaining sessions of the user
+		if err := repo.DeleteSessionsByUserID("user123"); err != nil {
+			t.Fatalf("Error deleting sessions: %v", err)
+		}
+		sessions, err := repo.FindSessionsByUserID("user123")
+		if err != nil {
+			t.Fatalf("Error finding sessions: %v", err)
+		}
+		if len(sessions) != 0 {
+			t.Fatalf("Expected 0 sessions, found %d", len(sessions))
+		}
+	})
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Revoke a token with a short TTL
+		if err := repo.RevokeAccessToken("jti123", 50*time.Millisecond); err != nil {
+			t.Fatalf("Error revoking access token: %v", err)
+		}
+
+		testCases := []struct {
+			name        string
+			tokenID     string
+			wait        time.Duration
+			wantRevoked bool
+		}{
+			{
+				name:        "Revoked Token",
+				tokenID:     "jti123",
+				wantRevoked: true,
+			},
+			{
+				name:        "Unknown Token",
+				tokenID:     "jti999",
+				wantRevoked: false,
+			},
+			{
+				name:        "Revocation Expired With Token",
+				tokenID:     "jti123",
+				wait:        100 * time.Millisecond,
+				wantRevoked: false,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				time.Sleep(tc.wait)
+				revoked, err := repo.IsAccessTokenRevoked(tc.tokenID)
+				if err != nil {
+					t.Fatalf("IsAccessTokenRevoked() error = %v", err)
+				}
+				if revoked != tc.wantRevoked {
+					t.Fatalf("IsAccessTokenRevoked() = %v, want %v", revoked, tc.wantRevoked)
+				}
+			})
+		}
+	})
+}
+
+func TestFindOneTimeToken(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		err := repo.SaveOneTimeToken("hash123", &model.OneTimeToken{Purpose: model.TokenPurposePasswordReset, UserID: "user123", ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("Error saving one-time token: %v", err)
+		}
+
+		testCases := []struct {
+			name       string
+			purpose    string
+			tokenHash  string
+			wantUserID string
+			wantErr    bool
+		}{
+			{name: "Wrong Purpose", purpose: "email_verification", tokenHash: "hash123", wantErr: true},
+			{name: "Unknown Token", purpose: model.TokenPurposePasswordReset, tokenHash: "unknown", wantErr: true},
+			{name: "First Lookup", purpose: model.TokenPurposePasswordReset, tokenHash: "hash123", wantUserID: "user123"},
+			{name: "Second Lookup", purpose: model.TokenPurposePasswordReset, tokenHash: "hash123", wantUserID: "user123"},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				token, err := repo.FindOneTimeToken(tc.purpose, tc.tokenHash)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("FindOneTimeToken() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+				if err == nil && token.UserID != tc.wantUserID {
+					t.Fatalf("FindOneTimeToken() userID = %v, want %v", token.UserID, tc.wantUserID)
+				}
+			})
+		}
+
+		// Looking a token up does not use it up
+		if _, err := repo.ConsumeOneTimeToken(model.TokenPurposePasswordReset, "hash123"); err != nil {
+			t.Fatalf("ConsumeOneTimeToken() error = %v", err)
+		}
+		if _, err := repo.FindOneTimeToken(model.TokenPurposePasswordReset, "hash123"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("FindOneTimeToken() after consume error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestConsumeOneTimeToken(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		// Save a password reset token
+		err := repo.SaveOneTimeToken("hash123", &model.OneTimeToken{Purpose: model.TokenPurposePasswordReset, UserID: "user123", ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("Error saving one-time token: %v", err)
+		}
+
+		testCases := []struct {
+			name       string
+			purpose    string
+			tokenHash  string
+			wantUserID string
+			wantErr    bool
+		}{
+			{
+				name:      "Wrong Purpose",
+				purpose:   "email_verification",
+				tokenHash: "hash123",
+				wantErr:   true,
+			},
+			{
+				name:       "First Use",
+				purpose:    model.TokenPurposePasswordReset,
+				tokenHash:  "hash123",
+				wantUserID: "user123",
+				wantErr:    false,
+			},
+			{
+				name:      "Second Use",
+				purpose:   model.TokenPurposePasswordReset,
+				tokenHash: "hash123",
+				wantErr:   true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				token, err := repo.ConsumeOneTimeToken(tc.purpose, tc.tokenHash)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("ConsumeOneTimeToken() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+				if token != nil && token.UserID != tc.wantUserID {
+					t.Fatalf("ConsumeOneTimeToken() userID = %v, want %v", token.UserID, tc.wantUserID)
+				}
+			})
+		}
+	})
+}
+
+func TestModifyMFA(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		testCases := []struct {
+			name        string
+			modify      func(mfa *model.MFA) error
+			wantErr     bool
+			wantEnabled bool
+		}{
+			{
+				name:        "Missing Record Reads As Disabled",
+				modify:      func(mfa *model.MFA) error { return fmt.Errorf("rejected") },
+				wantErr:     true,
+				wantEnabled: false,
+			},
+			{
+				name: "Enable",
+				modify: func(mfa *model.MFA) error {
+					mfa.Secret, mfa.Enabled = "SECRET", true
+					return nil
+				},
+				wantErr:     false,
+				wantEnabled: true,
+			},
+			{
+				name: "Rejected Modification Is Not Saved",
+				modify: func(mfa *model.MFA) error {
+					mfa.Enabled = false
+					return fmt.Errorf("rejected")
+				},
+				wantErr:     true,
+				wantEnabled: true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := repo.ModifyMFA("user123", tc.modify)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("ModifyMFA() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+
+				mfa, err := repo.FindMFA("user123")
+				if err != nil {
+					t.Fatalf("FindMFA() error = %v", err)
+				}
+				if mfa.Enabled != tc.wantEnabled {
+					t.Fatalf("FindMFA() enabled = %v, want %v", mfa.Enabled, tc.wantEnabled)
+				}
+			})
+		}
+
+		// Deleting the record turns the second factor off
+		if err := repo.DeleteMFA("user123"); err != nil {
+			t.Fatalf("DeleteMFA() error = %v", err)
+		}
+		if mfa, _ := repo.FindMFA("user123"); mfa.Enabled {
+			t.Fatalf("FindMFA() enabled after DeleteMFA()")
+		}
+	})
+}
+
+func TestModifyLoginAttempts(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		countFailure := func(ttl time.Duration) func(attempts *model.LoginAttempts) error {
+			return func(attempts *model.LoginAttempts) error {
+				attempts.Failures++
+				attempts.ExpiresAt = time.Now().Add(ttl)
+				return nil
+			}
+		}
+
+		testCases := []struct {
+			name         string
+			key          string
+			modify       func(attempts *model.LoginAttempts) error
+			wait         time.Duration
+			wantFailures int
+		}{
+			{
+				name:         "First Failure",
+				key:          "account:test@example.com",
+				modify:       countFailure(time.Minute),
+				wantFailures: 1,
+			},
+			{
+				name:         "Failures Accumulate",
+				key:          "account:test@example.com",
+				modify:       countFailure(time.Minute),
+				wantFailures: 2,
+			},
+			{
+				name:         "Keys Are Independent",
+				key:          "ip:127.0.0.1",
+				modify:       countFailure(time.Minute),
+				wantFailures: 1,
+			},
+			{
+				name:         "Counter Expires",
+				key:          "ip:10.0.0.1",
+				modify:       countFailure(time.Second),
+				wait:         1500 * time.Millisecond,
+				wantFailures: 0,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if _, err := repo.ModifyLoginAttempts(tc.key, tc.modify); err != nil {
+					t.Fatalf("ModifyLoginAttempts() error = %v", err)
+				}
+				time.Sleep(tc.wait)
+
+				attempts, err := repo.FindLoginAttempts(tc.key)
+				if err != nil {
+					t.Fatalf("FindLoginAttempts() error = %v", err)
+				}
+				if attempts.Failures != tc.wantFailures {
+					t.Fatalf("FindLoginAttempts() failures = %v, want %v", attempts.Failures, tc.wantFailures)
+				}
+			})
+		}
+
+		// Concurrent first failures of a key are all counted
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repo.ModifyLoginAttempts("ip:10.0.0.2", countFailure(time.Minute)); err != nil {
+					t.Errorf("ModifyLoginAttempts() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if attempts, _ := repo.FindLoginAttempts("ip:10.0.0.2"); attempts.Failures != 10 {
+			t.Fatalf("FindLoginAttempts() failures after concurrent failures = %v, want 10", attempts.Failures)
+		}
+
+		// Resetting forgets the failures
+		if err := repo.ResetLoginAttempts("account:test@example.com"); err != nil {
+			t.Fatalf("ResetLoginAttempts() error = %v", err)
+		}
+		if attempts, _ := repo.FindLoginAttempts("account:test@example.com"); attempts.Failures != 0 {
+			t.Fatalf("FindLoginAttempts() failures after reset = %v, want 0", attempts.Failures)
+		}
+	})
+}
+
+func TestSaveRole(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		for i := range model.DefaultRoles {
+			if err := repo.SaveRole(&model.DefaultRoles[i]); err != nil {
+				t.Fatalf("SaveRole() error = %v", err)
+			}
+		}
+
+		testCases := []struct {
+			name       string
+			role       string
+			permission string
+			wantErr    bool
+			wantHas    bool
+		}{
+			{
+				name:       "User Reads",
+				role:       model.DefaultRole,
+				permission: model.PermissionUsersRead,
+				wantErr:    false,
+				wantHas:    true,
+			},
+			{
+				name:       "User Cannot Delete Others",
+				role:       model.DefaultRole,
+				permission: model.PermissionUsersDeleteAny,
+				wantErr:    false,
+				wantHas:    false,
+			},
+			{
+				name:       "Admin Deletes Others",
+				role:       model.AdminRole,
+				permission: model.PermissionUsersDeleteAny,
+				wantErr:    false,
+				wantHas:    true,
+			},
+			{
+				name:       "Unknown Role",
+				role:       "auditor",
+				permission: model.PermissionUsersRead,
+				wantErr:    true,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				role, err := repo.FindRole(tc.role)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("FindRole() error = %v, wantErr = %v", err, tc.wantErr)
+				}
+				if err == nil && role.Has(tc.permission) != tc.wantHas {
+					t.Fatalf("Has(%q) = %v, want %v", tc.permission, role.Has(tc.permission), tc.wantHas)
+				}
+			})
+		}
+
+		// Listing returns every role, deleting removes one
+		roles, err := repo.FindAllRoles()
+		if err != nil || len(roles) != len(model.DefaultRoles) {
+			t.Fatalf("FindAllRoles() = %d roles, error = %v, want %d", len(roles), err, len(model.DefaultRoles))
+		}
+		if err := repo.DeleteRole(model.AdminRole); err != nil {
+			t.Fatalf("DeleteRole() error = %v", err)
+		}
+		if _, err := repo.FindRole(model.AdminRole); err == nil {
+			t.Fatalf("FindRole() found a deleted role")
+		}
+	})
+}
+
+func TestFindLoginEvents(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		start := time.Now()
+		outcomes := []string{model.LoginInvalidPassword, model.LoginInvalidPassword, model.LoginSucceeded}
+		for i, outcome := range outcomes {
+			event := &model.LoginEvent{UserID: "user123", Outcome: outcome, CreatedAt: start.Add(time.Duration(i) * time.Second)}
+			if err := repo.SaveLoginEvent(event, time.Hour); err != nil {
+				t.Fatalf("SaveLoginEvent() error = %v", err)
+			}
+		}
+		if err := repo.SaveLoginEvent(&model.LoginEvent{UserID: "user456", Outcome: model.LoginSucceeded, CreatedAt: start}, time.Hour); err != nil {
+			t.Fatalf("SaveLoginEvent() error = %v", err)
+		}
+
+		// Events that share a timestamp are all kept, the one saved last counts as the newest
+		for _, outcome := range []string{model.LoginInvalidPassword, model.LoginSucceeded} {
+			if err := repo.SaveLoginEvent(&model.LoginEvent{UserID: "user555", Outcome: outcome, CreatedAt: start}, time.Hour); err != nil {
+				t.Fatalf("SaveLoginEvent() error = %v", err)
+			}
+		}
+
+		testCases := []struct {
+			name         string
+			userID       string
+			limit        int
+			wantOutcomes []string
+		}{
+			{
+				name:         "Newest First",
+				userID:       "user123",
+				limit:        0,
+				wantOutcomes: []string{model.LoginSucceeded, model.LoginInvalidPassword, model.LoginInvalidPassword},
+			},
+			{
+				name:         "Limited",
+				userID:       "user123",
+				limit:        1,
+				wantOutcomes: []string{model.LoginSucceeded},
+			},
+			{
+				name:         "Same Timestamp",
+				userID:       "user555",
+				limit:        0,
+				wantOutcomes: []string{model.LoginSucceeded, model.LoginInvalidPassword},
+			},
+			{
+				name:         "Unknown User",
+				userID:       "user789",
+				limit:        0,
+				wantOutcomes: nil,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				events, err := repo.FindLoginEvents(tc.userID, tc.limit)
+				if err != nil {
+					t.Fatalf("FindLoginEvents() error = %v", err)
+				}
+				var outcomes []string
+				for _, event := range events {
+					outcomes = append(outcomes, event.Outcome)
+				}
+				if !reflect.DeepEqual(outcomes, tc.wantOutcomes) {
+					t.Fatalf("FindLoginEvents() outcomes = %v, want %v", outcomes, tc.wantOutcomes)
+				}
+			})
+		}
+
+		// Deleting the history of one user leaves the others alone
+		if err := repo.DeleteLoginEvents("user123"); err != nil {
+			t.Fatalf("DeleteLoginEvents() error = %v", err)
+		}
+		if events, _ := repo.FindLoginEvents("user123", 0); len(events) != 0 {
+			t.Fatalf("FindLoginEvents() = %d events after DeleteLoginEvents(), want 0", len(events))
+		}
+		if events, _ := repo.FindLoginEvents("user456", 0); len(events) != 1 {
+			t.Fatalf("FindLoginEvents() = %d events of another user, want 1", len(events))
+		}
+	})
+}
+
+func TestUserUniqueness(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		if err := repo.Create(&model.User{ID: "1", Username: "john", Email: "john@example.com"}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+
+		testCases := []struct {
+			name      string
+			write     func() error
+			wantField string // Field of the expected conflict, empty if the write succeeds
+		}{
+			{name: "Same Email", write: func() error {
+				return repo.Create(&model.User{ID: "2", Username: "jane", Email: "john@example.com"})
+			}, wantField: "email"},
+			{name: "Email In Other Case", write: func() error {
+				return repo.Create(&model.User{ID: "2", Username: "jane", Email: " John@Example.COM"})
+			}, wantField: "email"},
+			{name: "Username In Other Unicode Form", write: func() error {
+				return repo.Create(&model.User{ID: "2", Username: "ｊｏｈｎ", Email: "jane@example.com"})
+			}, wantField: "username"},
+			{name: "Free Values", write: func() error {
+				return repo.Create(&model.User{ID: "2", Username: "jane", Email: "jane@example.com"})
+			}},
+			{name: "Update To Taken Username", write: func() error {
+				_, err := repo.UpdateOneByID("2", &model.User{Username: "JOHN"}, AnyVersion)
+				return err
+			}, wantField: "username"},
+			{name: "Modify To Taken Email", write: func() error {
+				_, err := repo.ModifyOneByID("2", func(user *model.User) error {
+					user.Email = "john@example.com"
+					return nil
+				})
+				return err
+			}, wantField: "email"},
+			{name: "Keep Own Values In Other Case", write: func() error {
+				_, err := repo.UpdateOneByID("1", &model.User{Username: "John"}, AnyVersion)
+				return err
+			}},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := tc.write()
+				var conflict *ConflictError
+				if tc.wantField == "" {
+					if err != nil {
+						t.Fatalf("write error = %v, want none", err)
+					}
+					return
+				}
+				if !errors.As(err, &conflict) || conflict.Field != tc.wantField {
+					t.Fatalf("write error = %v, want conflict on %s", err, tc.wantField)
+				}
+			})
+		}
+
+		// A refused write leaves the existing users and their lookup keys untouched
+		user, err := repo.FindOneByEmail("JANE@example.com")
+		if err != nil || user.ID != "2" || user.Username != "jane" {
+			t.Fatalf("FindOneByEmail() = %v, %v, want unchanged user 2", user, err)
+		}
+	})
+}
+
+func TestRepositoryErrors(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		if err := repo.Create(&model.User{ID: "1", Username: "john", Email: "john@example.com"}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+
+		testCases := []struct {
+			name string
+			call func() error
+			want error
+		}{
+			{name: "Missing User", call: func() error { _, err := repo.FindOneByID("2"); return err }, want: ErrNotFound},
+			{name: "Missing Email", call: func() error { _, err := repo.FindOneByEmail("jane@example.com"); return err }, want: ErrNotFound},
+			{name: "Update Missing User", call: func() error {
+				_, err := repo.UpdateOneByID("2", &model.User{Name: "Jane"}, AnyVersion)
+				return err
+			}, want: ErrNotFound},
+			{name: "Delete Missing User", call: func() error { return repo.DeleteOneByID("2") }, want: ErrNotFound},
+			{name: "Missing Role", call: func() error { _, err := repo.FindRole("nobody"); return err }, want: ErrNotFound},
+			{name: "Missing Session", call: func() error { _, err := repo.FindSession("1", "s1"); return err }, want: ErrNotFound},
+			{name: "Missing One-Time Token", call: func() error {
+				_, err := repo.ConsumeOneTimeToken(model.TokenPurposePasswordReset, "hash")
+				return err
+			}, want: ErrNotFound},
+			{name: "Taken Email", call: func() error {
+				return repo.Create(&model.User{ID: "2", Username: "jane", Email: "john@example.com"})
+			}, want: ErrConflict},
+			{name: "Invalid Cursor", call: func() error {
+				_, err := repo.FindUsers(model.UserQuery{Cursor: "garbage", Limit: 10})
+				return err
+			}, want: ErrInvalidData},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if err := tc.call(); !errors.Is(err, tc.want) {
+					t.Fatalf("error = %v, want %v", err, tc.want)
+				}
+			})
+		}
+	})
+}
+
+func TestDeleteUserCascade(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		expires := time.Now().Add(time.Hour)
+		for _, userID := range []string{"1", "2"} {
+			if err := repo.Create(&model.User{ID: userID, Username: "user" + userID, Email: userID + "@example.com"}); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			session := &model.Session{ID: "s" + userID, UserID: userID, RefreshTokenHash: "old" + userID, AccessTokenID: "jti" + userID, ExpiresAt: expires}
+			if err := repo.SaveSession(session); err != nil {
+				t.Fatalf("SaveSession() error = %v", err)
+			}
+			rotated := *session
+			rotated.RefreshTokenHash = "new" + userID
+			if err := repo.RotateSession(&rotated, "old"+userID); err != nil {
+				t.Fatalf("RotateSession() error = %v", err)
+			}
+			token := &model.OneTimeToken{Purpose: model.TokenPurposePasswordReset, UserID: userID, ExpiresAt: expires}
+			if err := repo.SaveOneTimeToken("reset"+userID, token); err != nil {
+				t.Fatalf("SaveOneTimeToken() error = %v", err)
+			}
+			if _, err := repo.ModifyMFA(userID, func(mfa *model.MFA) error {
+				mfa.Enabled = true
+				return nil
+			}); err != nil {
+				t.Fatalf("ModifyMFA() error = %v", err)
+			}
+			if err := repo.SaveLoginEvent(&model.LoginEvent{UserID: userID, Outcome: model.LoginSucceeded, CreatedAt: time.Now()}, time.Hour); err != nil {
+				t.Fatalf("SaveLoginEvent() error = %v", err)
+			}
+		}
+
+		// Deleting only signs the user out, its other records are kept for a restore
+		if err := repo.DeleteOneByID("1"); err != nil {
+			t.Fatalf("DeleteOneByID() error = %v", err)
+		}
+		if _, err := repo.FindSessionByRefreshToken("new1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("FindSessionByRefreshToken() after deletion error = %v, want ErrNotFound", err)
+		}
+		if mfa, err := repo.FindMFA("1"); err != nil || !mfa.Enabled {
+			t.Fatalf("FindMFA() after deletion = %v, %v, want enabled", mfa, err)
+		}
+
+		// A failing cleanup rolls the whole purge back
+		fail := true
+		repo.RegisterUserCleanup("test", func(tx UserCleanupTx, userID string) error {
+			if fail {
+				return fmt.Errorf("cleanup failed")
+			}
+			return nil
+		})
+		purgeBefore := time.Now().Add(time.Second)
+		if _, err := repo.PurgeDeletedUsers(purgeBefore); err == nil {
+			t.Fatalf("PurgeDeletedUsers() with a failing cleanup succeeded")
+		}
+		if deleted, err := repo.FindDeletedUsers(); err != nil || len(deleted) != 1 {
+			t.Fatalf("FindDeletedUsers() after failed purge = %d users, %v", len(deleted), err)
+		}
+		fail = false
+		if purged, err := repo.PurgeDeletedUsers(purgeBefore); err != nil || purged != 1 {
+			t.Fatalf("PurgeDeletedUsers() = %d, %v, want 1", purged, err)
+		}
+
+		testCases := []struct {
+			name   string
+			userID string
+			gone   bool
+		}{
+			{name: "Purged User", userID: "1", gone: true},
+			{name: "Other User", userID: "2", gone: false},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := repo.FindSessionByRefreshToken("new" + tc.userID)
+				if gone := errors.Is(err, ErrNotFound); gone != tc.gone {
+					t.Fatalf("refresh token gone = %v, want %v (error %v)", gone, tc.gone, err)
+				}
+				_, _, err = repo.FindRotatedRefreshToken("old" + tc.userID)
+				if gone := errors.Is(err, ErrNotFound); gone != tc.gone {
+					t.Fatalf("rotated token gone = %v, want %v (error %v)", gone, tc.gone, err)
+				}
+				revoked, err := repo.IsAccessTokenRevoked("jti" + tc.userID)
+				if err != nil || revoked != tc.gone {
+					t.Fatalf("IsAccessTokenRevoked() = %v, %v, want %v", revoked, err, tc.gone)
+				}
+				mfa, err := repo.FindMFA(tc.userID)
+				if err != nil || mfa.Enabled == tc.gone {
+					t.Fatalf("FindMFA() enabled = %v, %v, want %v", mfa.Enabled, err, !tc.gone)
+				}
+				events, err := repo.FindLoginEvents(tc.userID, 10)
+				if err != nil || (len(events) == 0) != tc.gone {
+					t.Fatalf("FindLoginEvents() = %d events, %v", len(events), err)
+				}
+				_, err = repo.ConsumeOneTimeToken(model.TokenPurposePasswordReset, "reset"+tc.userID)
+				if gone := errors.Is(err, ErrNotFound); gone != tc.gone {
+					t.Fatalf("one-time token gone = %v, want %v (error %v)", gone, tc.gone, err)
+				}
+			})
+		}
+	})
+}
+
+func TestSoftDeleteUser(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		for _, userID := range []string{"1", "2"} {
+			if err := repo.Create(&model.User{ID: userID, Username: "user" + userID, Email: userID + "@example.com"}); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+		if err := repo.DeleteOneByID("1"); err != nil {
+			t.Fatalf("DeleteOneByID() error = %v", err)
+		}
+
+		// Deleted users are hidden from every lookup
+		if _, err := repo.FindOneByID("1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("FindOneByID() of deleted user error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.FindOneByEmail("1@example.com"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("FindOneByEmail() of deleted user error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.ModifyOneByID("1", func(user *model.User) error { return nil }); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("ModifyOneByID() of deleted user error = %v, want ErrNotFound", err)
+		}
+		if err := repo.DeleteOneByID("1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("DeleteOneByID() of deleted user error = %v, want ErrNotFound", err)
+		}
+		if users, err := repo.FindAll(); err != nil || len(users) != 1 || users[0].ID != "2" {
+			t.Fatalf("FindAll() = %v, %v, want only user 2", users, err)
+		}
+		if page, err := repo.FindUsers(model.UserQuery{Limit: 10, CountTotal: true}); err != nil || page.Total != 1 || page.Users[0].ID != "2" {
+			t.Fatalf("FindUsers() = %+v, %v, want only user 2", page, err)
+		}
+
+		// Their email address and username stay reserved until they are purged
+		if err := repo.Create(&model.User{ID: "3", Username: "user3", Email: "1@example.com"}); !errors.Is(err, ErrConflict) {
+			t.Fatalf("Create() with email of deleted user error = %v, want ErrConflict", err)
+		}
+
+		testCases := []struct {
+			name         string
+			userID       string
+			deletedAfter time.Time
+			wantErr      error
+		}{
+			{name: "Restore Window Passed", userID: "1", deletedAfter: time.Now().Add(time.Hour), wantErr: ErrNotFound},
+			{name: "Restore Active User", userID: "2", deletedAfter: time.Time{}, wantErr: ErrNotFound},
+			{name: "Restore Non-Existent User", userID: "999", deletedAfter: time.Time{}, wantErr: ErrNotFound},
+			{name: "Restore Deleted User", userID: "1", deletedAfter: time.Now().Add(-time.Hour), wantErr: nil},
+			{name: "Restore Restored User", userID: "1", deletedAfter: time.Time{}, wantErr: ErrNotFound},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				user, err := repo.RestoreOneByID(tc.userID, tc.deletedAfter)
+				if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil) {
+					t.Fatalf("RestoreOneByID() error = %v, want %v", err, tc.wantErr)
+				}
+				if err == nil && user.IsDeleted() {
+					t.Fatalf("RestoreOneByID() returned a deleted user")
+				}
+			})
+		}
+
+		if user, err := repo.FindOneByEmail("1@example.com"); err != nil || user.ID != "1" {
+			t.Fatalf("FindOneByEmail() of restored user = %v, %v", user, err)
+		}
+	})
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	runBackends(t, func(t *testing.T, repo Repository) {
+		for _, userID := range []string{"1", "2", "3"} {
+			if err := repo.Create(&model.User{ID: userID, Username: "user" + userID, Email: userID + "@example.com"}); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+		_ = repo.DeleteOneByID("1")
+		cutoff := time.Now()
+		time.Sleep(10 * time.Millisecond)
+		_ = repo.DeleteOneByID("2")
+
+		purged, err := repo.PurgeDeletedUsers(cutoff.Add(5 * time.Millisecond))
+		if err != nil || purged != 1 {
+			t.Fatalf("PurgeDeletedUsers() = %d, %v, want 1", purged, err)
+		}
+
+		testCases := []struct {
+			name        string
+			userID      string
+			wantDeleted bool // Still stored as deleted
+			wantActive  bool
+		}{
+			{name: "Expired Deletion", userID: "1"},
+			{name: "Recent Deletion", userID: "2", wantDeleted: true},
+			{name: "Active User", userID: "3", wantActive: true},
+		}
+
+		deleted, err := repo.FindDeletedUsers()
+		if err != nil {
+			t.Fatalf("FindDeletedUsers() error = %v", err)
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				isDeleted := false
+				for _, user := range deleted {
+					isDeleted = isDeleted || user.ID == tc.userID
+				}
+				if isDeleted != tc.wantDeleted {
+					t.Fatalf("deleted = %v, want %v", isDeleted, tc.wantDeleted)
+				}
+				_, err := repo.FindOneByID(tc.userID)
+				if active := err == nil; active != tc.wantActive {
+					t.Fatalf("FindOneByID() error = %v, want active %v", err, tc.wantActive)
+				}
+			})
+		}
+
+		// The email address of a purged user is free again
+		if err := repo.Create(&model.User{ID: "4", Username: "user1", Email: "1@example.com"}); err != nil {
+			t.Fatalf("Create() with email of purged user error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package local
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqlDialect holds what differs between the SQL databases the SQL repository runs on.
+type sqlDialect struct {
+	driver        string // Name of the database/sql driver
+	timestampType string // Column type of timestamps
+	serialType    string // Column type of generated surrogate keys, numbered in insertion order
+	forUpdate     string // Appended to reads of rows that are written in the same transaction
+	numbered      bool   // Placeholders are $1, $2, ... instead of ?
+	maxOpenConns  int    // Maximum number of open connections, 0 for no limit
+
+	// uniqueViolation reports whether err is a violated unique constraint and, if the driver tells, its name.
+	uniqueViolation func(err error) (constraint string, ok bool)
+}
+
+// sqlDialects are the SQL databases NewSQLRepository supports, by name.
+var sqlDialects = map[string]*sqlDialect{
+	// SQLite allows a single writer at a time, so the repository uses a single connection. Every
+	// transaction then runs alone and checks made within it cannot be raced.
+	"sqlite": {
+		driver:        "sqlite",
+		timestampType: "TIMESTAMP",
+		serialType:    "INTEGER",
+		maxOpenConns:  1,
+		uniqueViolation: func(err error) (string, bool) {
+			var sqliteError *sqlite.Error
+			return "", errors.As(err, &sqliteError) && sqliteError.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+		},
+	},
+	// PostgreSQL runs transactions concurrently, rows that are read and then written are locked.
+	"postgres": {
+		driver:        "postgres",
+		timestampType: "TIMESTAMPTZ",
+		serialType:    "BIGSERIAL",
+		forUpdate:     " FOR UPDATE",
+		numbered:      true,
+		uniqueViolation: func(err error) (string, bool) {
+			var pqError *pq.Error
+			if errors.As(err, &pqError) && pqError.Code == "23505" {
+				return pqError.Constraint, true
+			}
+			return "", false
+		},
+	},
+}
+
+// rebind rewrites the ? placeholders of a query to the style of the dialect.
+func (d *sqlDialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var rebound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
@@ -0,0 +1,85 @@
+package local
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// loginAttemptsColumns are the columns a failed login counter is read from, in the order scanLoginAttempts expects.
+const loginAttemptsColumns = "failures, last_failure_at, locked_until, expires_at"
+
+// scanLoginAttempts reads a failed login counter selected with loginAttemptsColumns into attempts.
+func scanLoginAttempts(row rowScanner, attempts *model.LoginAttempts) error {
+	var lastFailureAt, lockedUntil, expiresAt sql.NullTime
+	if err := row.Scan(&attempts.Failures, &lastFailureAt, &lockedUntil, &expiresAt); err != nil {
+		return err // Return error if the counter is not found or cannot be read.
+	}
+	attempts.LastFailureAt = fromSQLTime(lastFailureAt)
+	attempts.LockedUntil = fromSQLTime(lockedUntil)
+	attempts.ExpiresAt = fromSQLTime(expiresAt)
+	return nil
+}
+
+// FindLoginAttempts retrieves the failed login counter for a key. Unknown keys have no failures.
+func (repo *SQLImpl) FindLoginAttempts(key string) (*model.LoginAttempts, error) {
+	var attempts model.LoginAttempts
+	err := scanLoginAttempts(repo.DB.QueryRow(repo.rebind("SELECT "+loginAttemptsColumns+" FROM login_attempts WHERE attempt_key = ? AND expires_at > ?"),
+		key, time.Now().UTC()), &attempts)
+	if err == sql.ErrNoRows {
+		return &model.LoginAttempts{}, nil // No failures within the window.
+	}
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return &attempts, nil
+}
+
+// ModifyLoginAttempts reads the failed login counter for a key, applies modify and writes it back
+// within a single transaction, so concurrent failures are all counted. The counter expires at its ExpiresAt.
+func (repo *SQLImpl) ModifyLoginAttempts(key string, modify func(attempts *model.LoginAttempts) error) (*model.LoginAttempts, error) {
+	var attempts model.LoginAttempts
+	err := repo.update(func(tx *sql.Tx) error {
+		// Make sure the row exists before locking it, FOR UPDATE locks nothing for a missing row and concurrent
+		// first failures would overwrite each other. The placeholder is already expired, so it counts as no failures.
+		now := time.Now()
+		_, err := tx.Exec(repo.rebind(`INSERT INTO login_attempts (attempt_key, `+loginAttemptsColumns+`) VALUES (?, 0, NULL, NULL, ?)
+			ON CONFLICT (attempt_key) DO NOTHING`), key, now.UTC())
+		if err != nil {
+			return err
+		}
+
+		attempts = model.LoginAttempts{}
+		err = scanLoginAttempts(tx.QueryRow(repo.rebind("SELECT "+loginAttemptsColumns+" FROM login_attempts WHERE attempt_key = ?"+repo.dialect.forUpdate), key), &attempts)
+		if err != nil {
+			return err
+		}
+		if !attempts.ExpiresAt.After(now) {
+			attempts = model.LoginAttempts{} // An expired counter starts over.
+		}
+
+		// Apply the requested changes.
+		if err := modify(&attempts); err != nil {
+			return err
+		}
+
+		if !attempts.ExpiresAt.After(time.Now()) {
+			_, err := tx.Exec(repo.rebind("DELETE FROM login_attempts WHERE attempt_key = ?"), key)
+			return err // An expired counter is simply forgotten.
+		}
+		_, err = tx.Exec(repo.rebind("UPDATE login_attempts SET failures = ?, last_failure_at = ?, locked_until = ?, expires_at = ? WHERE attempt_key = ?"),
+			attempts.Failures, sqlTime(attempts.LastFailureAt), sqlTime(attempts.LockedUntil), attempts.ExpiresAt.UTC(), key)
+		return err // Return any error encountered during save.
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return &attempts, nil
+}
+
+// ResetLoginAttempts forgets the failed logins of a key, e.g. after a successful login or an unlock.
+func (repo *SQLImpl) ResetLoginAttempts(key string) error {
+	_, err := repo.DB.Exec(repo.rebind("DELETE FROM login_attempts WHERE attempt_key = ?"), key)
+	return err
+}
@@ -0,0 +1,55 @@
+package local
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// SaveLoginEvent appends an event to the login history of a user. It is forgotten after ttl.
+func (repo *SQLImpl) SaveLoginEvent(event *model.LoginEvent, ttl time.Duration) error {
+	_, err := repo.DB.Exec(repo.rebind("INSERT INTO login_events (user_id, created_at, outcome, ip, user_agent, device, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		event.UserID, event.CreatedAt.UTC(), event.Outcome, event.IP, event.UserAgent, event.Device, time.Now().UTC().Add(ttl))
+	return err // Return any error encountered during save.
+}
+
+// FindLoginEvents retrieves the most recent login events of a user, newest first. A limit of 0 returns all of them.
+func (repo *SQLImpl) FindLoginEvents(userID string, limit int) ([]*model.LoginEvent, error) {
+	query := "SELECT user_id, created_at, outcome, ip, user_agent, device FROM login_events WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC, id DESC"
+	args := []interface{}{userID, time.Now().UTC()}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := repo.DB.Query(repo.rebind(query), args...)
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	defer rows.Close()
+
+	var events []*model.LoginEvent
+	for rows.Next() {
+		var event model.LoginEvent
+		var createdAt sql.NullTime
+		if err := rows.Scan(&event.UserID, &createdAt, &event.Outcome, &event.IP, &event.UserAgent, &event.Device); err != nil {
+			return nil, err // Return error if an event cannot be read.
+		}
+		event.CreatedAt = fromSQLTime(createdAt)
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+// DeleteLoginEvents removes the login history of a user.
+func (repo *SQLImpl) DeleteLoginEvents(userID string) error {
+	return repo.update(func(tx *sql.Tx) error {
+		return repo.deleteLoginEvents(tx, userID)
+	})
+}
+
+// deleteLoginEvents removes the login history of a user within a transaction.
+func (repo *SQLImpl) deleteLoginEvents(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(repo.rebind("DELETE FROM login_events WHERE user_id = ?"), userID)
+	return err
+}
@@ -0,0 +1,82 @@
+package local
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// mfaColumns are the columns a second factor is read from, in the order scanMFA expects.
+const mfaColumns = "secret, pending_secret, enabled, recovery_codes, last_used_step, enabled_at"
+
+// scanMFA reads a second factor selected with mfaColumns into mfa.
+func scanMFA(row rowScanner, mfa *model.MFA) error {
+	var recoveryCodes string
+	var enabledAt sql.NullTime
+	if err := row.Scan(&mfa.Secret, &mfa.PendingSecret, &mfa.Enabled, &recoveryCodes, &mfa.LastUsedStep, &enabledAt); err != nil {
+		return err // Return error if the record is not found or cannot be read.
+	}
+	mfa.EnabledAt = fromSQLTime(enabledAt)
+	return json.Unmarshal([]byte(recoveryCodes), &mfa.RecoveryCodes)
+}
+
+// FindMFA retrieves the second factor of a user. A user without a second factor gets a disabled record.
+func (repo *SQLImpl) FindMFA(userID string) (*model.MFA, error) {
+	mfa := model.MFA{UserID: userID}
+	err := scanMFA(repo.DB.QueryRow(repo.rebind("SELECT "+mfaColumns+" FROM mfa WHERE user_id = ?"), userID), &mfa)
+	if err == sql.ErrNoRows {
+		return &model.MFA{UserID: userID}, nil // No second factor was ever enrolled.
+	}
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	return &mfa, nil
+}
+
+// ModifyMFA reads the second factor of a user, applies modify and writes the result back within a single transaction.
+// A user without a second factor starts from an empty record. If modify returns an error nothing is written.
+func (repo *SQLImpl) ModifyMFA(userID string, modify func(mfa *model.MFA) error) (*model.MFA, error) {
+	var mfa model.MFA
+	err := repo.update(func(tx *sql.Tx) error {
+		mfa = model.MFA{UserID: userID}
+		err := scanMFA(tx.QueryRow(repo.rebind("SELECT "+mfaColumns+" FROM mfa WHERE user_id = ?"+repo.dialect.forUpdate), userID), &mfa)
+		if err == sql.ErrNoRows {
+			mfa = model.MFA{UserID: userID}
+		} else if err != nil {
+			return err
+		}
+
+		// Apply the requested changes.
+		if err := modify(&mfa); err != nil {
+			return err
+		}
+
+		recoveryCodes, err := json.Marshal(mfa.RecoveryCodes)
+		if err != nil {
+			return err // Return error if JSON marshaling fails.
+		}
+		_, err = tx.Exec(repo.rebind(`INSERT INTO mfa (user_id, `+mfaColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, pending_secret = excluded.pending_secret, enabled = excluded.enabled,
+			recovery_codes = excluded.recovery_codes, last_used_step = excluded.last_used_step, enabled_at = excluded.enabled_at`),
+			userID, mfa.Secret, mfa.PendingSecret, mfa.Enabled, string(recoveryCodes), mfa.LastUsedStep, sqlTime(mfa.EnabledAt))
+		return err // Return any error encountered during save.
+	})
+	if err != nil {
+		return nil, err // Return error if the modification fails.
+	}
+	return &mfa, nil
+}
+
+// DeleteMFA removes the second factor of a user. Deleting a missing record is not an error.
+func (repo *SQLImpl) DeleteMFA(userID string) error {
+	return repo.update(func(tx *sql.Tx) error {
+		return repo.deleteMFA(tx, userID)
+	})
+}
+
+// deleteMFA removes the second factor of a user within a transaction.
+func (repo *SQLImpl) deleteMFA(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(repo.rebind("DELETE FROM mfa WHERE user_id = ?"), userID)
+	return err
+}
@@ -0,0 +1,36 @@
+package local
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RevokeAccessToken adds an access token to the revocation list. The entry expires after ttl,
+// which should be at least the remaining lifetime of the token.
+func (repo *SQLImpl) RevokeAccessToken(tokenID string, ttl time.Duration) error {
+	return repo.update(func(tx *sql.Tx) error {
+		return repo.revokeAccessToken(tx, tokenID, ttl)
+	})
+}
+
+// revokeAccessToken adds an access token to the revocation list within a transaction.
+func (repo *SQLImpl) revokeAccessToken(tx *sql.Tx, tokenID string, ttl time.Duration) error {
+	now := time.Now().UTC()
+	_, err := tx.Exec(repo.rebind(`INSERT INTO revoked_tokens (token_id, revoked_at, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (token_id) DO UPDATE SET revoked_at = excluded.revoked_at, expires_at = excluded.expires_at`),
+		tokenID, now, now.Add(ttl))
+	return err // Return any error encountered during save.
+}
+
+// IsAccessTokenRevoked reports whether an access token is on the revocation list.
+func (repo *SQLImpl) IsAccessTokenRevoked(tokenID string) (bool, error) {
+	var revoked int
+	err := repo.DB.QueryRow(repo.rebind("SELECT 1 FROM revoked_tokens WHERE token_id = ? AND expires_at > ?"), tokenID, time.Now().UTC()).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		return false, nil // Token was never revoked or the entry already expired together with the token.
+	}
+	if err != nil {
+		return false, err // Return error if the lookup fails.
+	}
+	return true, nil
+}
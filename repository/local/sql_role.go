@@ -0,0 +1,65 @@
+package local
+
+import (
+	"encoding/json"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// SaveRole stores a role definition, replacing an existing role of the same name.
+func (repo *SQLImpl) SaveRole(role *model.Role) error {
+	permissions, err := json.Marshal(role.Permissions)
+	if err != nil {
+		return err // Return error if JSON marshaling fails.
+	}
+	_, err = repo.DB.Exec(repo.rebind(`INSERT INTO roles (name, permissions) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET permissions = excluded.permissions`), role.Name, string(permissions))
+	return err // Return any error encountered during save.
+}
+
+// scanRole reads a role selected as name and permissions.
+func scanRole(row rowScanner) (*model.Role, error) {
+	var role model.Role
+	var permissions string
+	if err := row.Scan(&role.Name, &permissions); err != nil {
+		return nil, err // Return error if the role is not found or cannot be read.
+	}
+	if err := json.Unmarshal([]byte(permissions), &role.Permissions); err != nil {
+		return nil, err // Return error if the permissions are corrupted.
+	}
+	return &role, nil
+}
+
+// FindRole retrieves a role definition by its name.
+func (repo *SQLImpl) FindRole(name string) (*model.Role, error) {
+	role, err := scanRole(repo.DB.QueryRow(repo.rebind("SELECT name, permissions FROM roles WHERE name = ?"), name))
+	if err != nil {
+		return nil, notFound(err, "role") // Return error if fetching fails.
+	}
+	return role, nil
+}
+
+// FindAllRoles retrieves every stored role definition.
+func (repo *SQLImpl) FindAllRoles() ([]*model.Role, error) {
+	rows, err := repo.DB.Query("SELECT name, permissions FROM roles ORDER BY name")
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	defer rows.Close()
+
+	var roles []*model.Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err // Return error if a role cannot be read.
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// DeleteRole removes a role definition. Deleting a missing role is not an error.
+func (repo *SQLImpl) DeleteRole(name string) error {
+	_, err := repo.DB.Exec(repo.rebind("DELETE FROM roles WHERE name = ?"), name)
+	return err
+}
@@ -0,0 +1,174 @@
+package local
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/jwt"
+)
+
+// sessionColumns are the columns a session is read from, in the order scanSession expects.
+const sessionColumns = `user_id, id, device, user_agent, ip, created_at, last_used_at, expires_at,
+	refresh_token_hash, access_token_id, generation`
+
+// scanSession reads a session selected with sessionColumns.
+func scanSession(row rowScanner) (*model.Session, error) {
+	var session model.Session
+	var createdAt, lastUsedAt, expiresAt sql.NullTime
+	err := row.Scan(&session.UserID, &session.ID, &session.Device, &session.UserAgent, &session.IP, &createdAt, &lastUsedAt, &expiresAt,
+		&session.RefreshTokenHash, &session.AccessTokenID, &session.Generation)
+	if err != nil {
+		return nil, err // Return error if the session is not found or cannot be read.
+	}
+	session.CreatedAt = fromSQLTime(createdAt)
+	session.LastUsedAt = fromSQLTime(lastUsedAt)
+	session.ExpiresAt = fromSQLTime(expiresAt)
+	return &session, nil
+}
+
+// setSession inserts or replaces a session within a transaction. Its refresh token is a column of the
+// session, so replacing it also drops the lookup of the previous one.
+func (repo *SQLImpl) setSession(tx *sql.Tx, session *model.Session) error {
+	_, err := tx.Exec(repo.rebind(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, id) DO UPDATE SET device = excluded.device, user_agent = excluded.user_agent, ip = excluded.ip,
+		created_at = excluded.created_at, last_used_at = excluded.last_used_at, expires_at = excluded.expires_at,
+		refresh_token_hash = excluded.refresh_token_hash, access_token_id = excluded.access_token_id, generation = excluded.generation`),
+		session.UserID, session.ID, session.Device, session.UserAgent, session.IP, sqlTime(session.CreatedAt), sqlTime(session.LastUsedAt),
+		session.ExpiresAt.UTC(), session.RefreshTokenHash, session.AccessTokenID, session.Generation)
+	return err // Return any error encountered during save.
+}
+
+// SaveSession creates or replaces a session.
+func (repo *SQLImpl) SaveSession(session *model.Session) error {
+	return repo.update(func(tx *sql.Tx) error {
+		return repo.setSession(tx, session)
+	})
+}
+
+// RotateSession binds a new refresh token to the session, provided the session is still bound to previousRefreshTokenHash.
+// The previous token is remembered as rotated until it would have expired, so that replaying it can be detected.
+func (repo *SQLImpl) RotateSession(session *model.Session, previousRefreshTokenHash string) error {
+	return repo.update(func(tx *sql.Tx) error {
+		// Read and lock the stored session to make sure nobody rotated it in the meantime.
+		current, err := scanSession(tx.QueryRow(repo.rebind("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND id = ? AND expires_at > ?"+repo.dialect.forUpdate),
+			session.UserID, session.ID, time.Now().UTC()))
+		if err != nil {
+			return notFound(err, "session") // Return error if the session is gone.
+		}
+		if current.RefreshTokenHash != previousRefreshTokenHash {
+			return ErrRefreshTokenReused // The presented token was already rotated by another request.
+		}
+
+		// Remember the previous token as rotated out of the family.
+		if _, err := tx.Exec(repo.rebind(`INSERT INTO rotated_tokens (token_hash, user_id, session_id, expires_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (token_hash) DO UPDATE SET user_id = excluded.user_id, session_id = excluded.session_id, expires_at = excluded.expires_at`),
+			previousRefreshTokenHash, session.UserID, session.ID, current.ExpiresAt.UTC()); err != nil {
+			return err // Return error if the rotated token cannot be recorded.
+		}
+		return repo.setSession(tx, session)
+	})
+}
+
+// FindRotatedRefreshToken resolves a refresh token hash that has already been rotated to the session family it belonged to.
+func (repo *SQLImpl) FindRotatedRefreshToken(refreshTokenHash string) (string, string, error) {
+	var userID, sessionID string
+	err := repo.DB.QueryRow(repo.rebind("SELECT user_id, session_id FROM rotated_tokens WHERE token_hash = ? AND expires_at > ?"),
+		refreshTokenHash, time.Now().UTC()).Scan(&userID, &sessionID)
+	if err != nil {
+		return "", "", notFound(err, "rotated refresh token") // Return error if the token was never rotated.
+	}
+	return userID, sessionID, nil // Return the owner of the token family.
+}
+
+// FindSession retrieves a single session of a user.
+func (repo *SQLImpl) FindSession(userID string, sessionID string) (*model.Session, error) {
+	session, err := scanSession(repo.DB.QueryRow(repo.rebind("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND id = ? AND expires_at > ?"),
+		userID, sessionID, time.Now().UTC()))
+	if err != nil {
+		return nil, notFound(err, "session") // Return error if the session is not found.
+	}
+	return session, nil // Return the found session.
+}
+
+// FindSessionByRefreshToken retrieves the session currently bound to the given refresh token hash.
+func (repo *SQLImpl) FindSessionByRefreshToken(refreshTokenHash string) (*model.Session, error) {
+	session, err := scanSession(repo.DB.QueryRow(repo.rebind("SELECT "+sessionColumns+" FROM sessions WHERE refresh_token_hash = ? AND expires_at > ?"),
+		refreshTokenHash, time.Now().UTC()))
+	if err != nil {
+		return nil, notFound(err, "refresh token") // Return error if the refresh token is unknown.
+	}
+	return session, nil // Return the found session.
+}
+
+// FindSessionsByUserID retrieves all active sessions of a user.
+func (repo *SQLImpl) FindSessionsByUserID(userID string) ([]*model.Session, error) {
+	rows, err := repo.DB.Query(repo.rebind("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY id"),
+		userID, time.Now().UTC())
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	defer rows.Close()
+
+	sessions := []*model.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err // Return error if a session cannot be read.
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession removes a single session.
+func (repo *SQLImpl) DeleteSession(userID string, sessionID string) error {
+	result, err := repo.DB.Exec(repo.rebind("DELETE FROM sessions WHERE user_id = ? AND id = ?"), userID, sessionID)
+	if err != nil {
+		return err // Return error if the delete fails.
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return fmt.Errorf("session %w", ErrNotFound) // Return error if the session is not found.
+	}
+	return nil
+}
+
+// DeleteSessionsByUserID removes every session of a user, signing them out on all devices.
+func (repo *SQLImpl) DeleteSessionsByUserID(userID string) error {
+	_, err := repo.DB.Exec(repo.rebind("DELETE FROM sessions WHERE user_id = ?"), userID)
+	return err // Return any error from the delete operation.
+}
+
+// deleteUserSessions signs a deleted user out everywhere: the access token of every session is revoked,
+// the sessions are removed, and so are the rotated tokens of their families.
+func (repo *SQLImpl) deleteUserSessions(tx *sql.Tx, userID string) error {
+	rows, err := tx.Query(repo.rebind("SELECT access_token_id FROM sessions WHERE user_id = ? AND access_token_id <> ''"), userID)
+	if err != nil {
+		return err
+	}
+	var accessTokenIDs []string
+	for rows.Next() {
+		var tokenID string
+		if err := rows.Scan(&tokenID); err != nil {
+			rows.Close()
+			return err
+		}
+		accessTokenIDs = append(accessTokenIDs, tokenID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, tokenID := range accessTokenIDs {
+		if err := repo.revokeAccessToken(tx, tokenID, jwt.AccessTokenTTL); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(repo.rebind("DELETE FROM sessions WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(repo.rebind("DELETE FROM rotated_tokens WHERE user_id = ?"), userID)
+	return err
+}
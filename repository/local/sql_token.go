@@ -0,0 +1,73 @@
+package local
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// SaveOneTimeToken stores a one-time token by its hash until it expires.
+func (repo *SQLImpl) SaveOneTimeToken(tokenHash string, token *model.OneTimeToken) error {
+	if !token.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("one-time token already expired: %w", ErrInvalidData)
+	}
+	_, err := repo.DB.Exec(repo.rebind(`INSERT INTO one_time_tokens (purpose, token_hash, user_id, payload, expires_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (purpose, token_hash) DO UPDATE SET user_id = excluded.user_id, payload = excluded.payload, expires_at = excluded.expires_at`),
+		token.Purpose, tokenHash, token.UserID, token.Payload, token.ExpiresAt.UTC())
+	return err // Return any error encountered during save.
+}
+
+// deleteUserOneTimeTokens removes the one-time tokens issued to a user, e.g. pending password resets.
+func (repo *SQLImpl) deleteUserOneTimeTokens(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(repo.rebind("DELETE FROM one_time_tokens WHERE user_id = ?"), userID)
+	return err
+}
+
+// FindOneTimeToken retrieves a one-time token without using it up.
+func (repo *SQLImpl) FindOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error) {
+	token := model.OneTimeToken{Purpose: purpose}
+	var expiresAt sql.NullTime
+	err := repo.DB.QueryRow(repo.rebind("SELECT user_id, payload, expires_at FROM one_time_tokens WHERE purpose = ? AND token_hash = ?"),
+		purpose, tokenHash).Scan(&token.UserID, &token.Payload, &expiresAt)
+	if err != nil {
+		return nil, notFound(err, "one-time token") // Return error if the token is unknown or already used.
+	}
+	token.ExpiresAt = fromSQLTime(expiresAt)
+	if time.Now().After(token.ExpiresAt) {
+		return nil, fmt.Errorf("one-time token %w", ErrNotFound) // Expired tokens behave like unknown ones.
+	}
+	return &token, nil // Return the found token.
+}
+
+// ConsumeOneTimeToken retrieves and deletes a one-time token in the same transaction, so it can only be used once.
+func (repo *SQLImpl) ConsumeOneTimeToken(purpose string, tokenHash string) (*model.OneTimeToken, error) {
+	token := model.OneTimeToken{Purpose: purpose}
+	err := repo.update(func(tx *sql.Tx) error {
+		var expiresAt sql.NullTime
+		err := tx.QueryRow(repo.rebind("SELECT user_id, payload, expires_at FROM one_time_tokens WHERE purpose = ? AND token_hash = ?"+repo.dialect.forUpdate),
+			purpose, tokenHash).Scan(&token.UserID, &token.Payload, &expiresAt)
+		if err != nil {
+			return notFound(err, "one-time token") // Return error if the token is unknown or already used.
+		}
+		token.ExpiresAt = fromSQLTime(expiresAt)
+
+		// The delete decides which of two concurrent requests consumed the token.
+		result, err := tx.Exec(repo.rebind("DELETE FROM one_time_tokens WHERE purpose = ? AND token_hash = ?"), purpose, tokenHash)
+		if err != nil {
+			return err
+		}
+		if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+			return fmt.Errorf("one-time token %w", ErrNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err // Return error if consuming fails.
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, fmt.Errorf("one-time token %w", ErrNotFound) // Expired tokens behave like unknown ones.
+	}
+	return &token, nil // Return the consumed token.
+}
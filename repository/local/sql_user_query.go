@@ -0,0 +1,130 @@
+package local
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+)
+
+// sqlSortColumns maps every sortable field to the expression users are ordered by, each backed by an index.
+var sqlSortColumns = map[string]string{
+	model.SortByCreatedAt: "created_at",
+	model.SortByUsername:  "LOWER(username)",
+	model.SortByLastname:  "LOWER(lastname)",
+}
+
+// likePrefix builds a LIKE pattern matching values that start with prefix, ignoring case.
+func likePrefix(prefix string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(prefix))
+	return escaped + "%"
+}
+
+// FindUsers returns a page of users matching the query. Pages continue after the cursor through the index of
+// the sort field, so users created or deleted between requests neither shift nor repeat entries. Total is only
+// counted when the query asks for it.
+func (repo *SQLImpl) FindUsers(query model.UserQuery) (*model.UserPage, error) {
+	if query.SortBy == "" {
+		query.SortBy = model.SortByCreatedAt
+	}
+	sortColumn, ok := sqlSortColumns[query.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q: %w", query.SortBy, ErrInvalidData)
+	}
+
+	if query.Limit < 1 {
+		return nil, fmt.Errorf("page limit must be positive: %w", ErrInvalidData)
+	}
+
+	var cursor *userCursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = decodeUserCursor(query.Cursor, query.SortBy, query.Descending); err != nil {
+			return nil, err
+		}
+	}
+
+	// Filters shared by the page and the total, deleted users never match
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	if query.Role != "" {
+		conditions = append(conditions, "role = ?")
+		args = append(args, query.Role)
+	}
+	if query.MinAge != 0 {
+		conditions = append(conditions, "age >= ?")
+		args = append(args, query.MinAge)
+	}
+	if query.MaxAge > 0 {
+		conditions = append(conditions, "age <= ?")
+		args = append(args, query.MaxAge)
+	}
+	if query.NamePrefix != "" {
+		conditions = append(conditions, `(LOWER(name) LIKE ? ESCAPE '\' OR LOWER(lastname) LIKE ? ESCAPE '\')`)
+		args = append(args, likePrefix(query.NamePrefix), likePrefix(query.NamePrefix))
+	}
+	if query.UsernamePrefix != "" {
+		conditions = append(conditions, `LOWER(username) LIKE ? ESCAPE '\'`)
+		args = append(args, likePrefix(query.UsernamePrefix))
+	}
+
+	page := &model.UserPage{Users: []*model.User{}}
+	if query.CountTotal {
+		if err := repo.DB.QueryRow(repo.rebind("SELECT COUNT(*) FROM users WHERE "+strings.Join(conditions, " AND ")), args...).Scan(&page.Total); err != nil {
+			return nil, err // Return error if counting fails.
+		}
+	}
+
+	// Continue after the cursor in the sort order, ties are broken by ID
+	order, compare := "ASC", ">"
+	if query.Descending {
+		order, compare = "DESC", "<"
+	}
+	if cursor != nil {
+		var value interface{} = cursor.Value
+		placeholder := "LOWER(?)"
+		if query.SortBy == model.SortByCreatedAt {
+			createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			value, placeholder = createdAt.UTC(), "?"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[2]s ?))", sortColumn, compare, placeholder))
+		args = append(args, value, value, cursor.Key)
+	}
+
+	// One more user than the page holds tells whether there is a next page
+	args = append(args, query.Limit+1)
+	rows, err := repo.DB.Query(repo.rebind("SELECT "+userColumns+" FROM users WHERE "+strings.Join(conditions, " AND ")+
+		" ORDER BY "+sortColumn+" "+order+", id "+order+" LIMIT ?"), args...)
+	if err != nil {
+		return nil, err // Return error if fetching fails.
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err // Return error if a user cannot be read.
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Users) > query.Limit {
+		page.Users = page.Users[:query.Limit]
+		last := page.Users[query.Limit-1]
+		value := last.Username
+		switch query.SortBy {
+		case model.SortByCreatedAt:
+			value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+		case model.SortByLastname:
+			value = last.Lastname
+		}
+		page.Next = userCursor{SortBy: query.SortBy, Descending: query.Descending, Value: value, Key: last.ID}.encode()
+	}
+	return page, nil
+}
@@ -0,0 +1,36 @@
+package local
+
+import (
+	"fmt"
+)
+
+// UserCleanupTx is the transaction a purge runs the user cleanups in. Every backend passes its own
+// transaction, which cleanups reach through BuntUserCleanup, SQLUserCleanup or MemoryUserCleanup.
+type UserCleanupTx interface {
+	backend() string // Name of the storage backend, for cleanups that do not support it
+}
+
+// UserCleanup removes the records a subsystem keeps for a user. Cleanups run inside the transaction that
+// purges the user, so if one of them fails the user and all of its records are kept.
+type UserCleanup func(tx UserCleanupTx, userID string) error
+
+// userCleanup is a registered UserCleanup with the name of its subsystem.
+type userCleanup struct {
+	name    string
+	cleanup UserCleanup
+}
+
+// runUserCleanups runs the registered cleanups for a purged user, in registration order.
+func runUserCleanups(cleanups []userCleanup, tx UserCleanupTx, userID string) error {
+	for _, c := range cleanups {
+		if err := c.cleanup(tx, userID); err != nil {
+			return fmt.Errorf("error deleting %s of user: %w", c.name, err) // Return error if a cleanup fails.
+		}
+	}
+	return nil
+}
+
+// unsupportedCleanupTx reports a cleanup that was registered with a repository of another backend.
+func unsupportedCleanupTx(want string, tx UserCleanupTx) error {
+	return fmt.Errorf("%s cleanup cannot run in a %s transaction", want, tx.backend())
+}
@@ -0,0 +1,52 @@
+package local
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestUserCleanupAdapters(t *testing.T) {
+	var ran []string
+	record := func(backend string) func(userID string) error {
+		return func(userID string) error {
+			ran = append(ran, backend+":"+userID)
+			return nil
+		}
+	}
+	bunt := BuntUserCleanup(func(tx *buntdb.Tx, userID string) error { return record("bunt")(userID) })
+	sqlCleanup := SQLUserCleanup(func(tx *sql.Tx, userID string) error { return record("sql")(userID) })
+	memory := MemoryUserCleanup(func(tx *MemoryTx, userID string) error { return record("memory")(userID) })
+
+	testCases := []struct {
+		name    string
+		cleanup UserCleanup
+		tx      UserCleanupTx
+		wantErr bool
+	}{
+		{name: "BuntDB Transaction", cleanup: bunt, tx: buntCleanupTx{}},
+		{name: "SQL Transaction", cleanup: sqlCleanup, tx: sqlCleanupTx{}},
+		{name: "Memory Transaction", cleanup: memory, tx: &MemoryTx{}},
+		{name: "BuntDB Cleanup On SQL", cleanup: bunt, tx: sqlCleanupTx{}, wantErr: true},
+		{name: "SQL Cleanup In Memory", cleanup: sqlCleanup, tx: &MemoryTx{}, wantErr: true},
+		{name: "Memory Cleanup On BuntDB", cleanup: memory, tx: buntCleanupTx{}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ran = nil
+			err := tc.cleanup(tc.tx, "1")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("cleanup error = %v, wantErr = %v", err, tc.wantErr)
+			}
+			wantRuns := 1
+			if tc.wantErr {
+				wantRuns = 0
+			}
+			if len(ran) != wantRuns {
+				t.Fatalf("cleanup ran %d times, want %d", len(ran), wantRuns)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+	"go.uber.org/zap"
+)
+
+// PurgeWorker permanently removes deleted users once their restore window has passed.
+type PurgeWorker struct {
+	log           *zap.Logger      // Logger for logging purges and failures.
+	repo          local.Repository // Reference to the repository for database operations.
+	restoreWindow time.Duration    // How long deleted users can be restored before they are purged.
+	interval      time.Duration    // Time between two purges.
+}
+
+// NewPurgeWorker creates a worker purging users deleted longer than restoreWindow ago, every interval.
+func NewPurgeWorker(log *zap.Logger, repo local.Repository, restoreWindow time.Duration, interval time.Duration) *PurgeWorker {
+	return &PurgeWorker{log: log, repo: repo, restoreWindow: restoreWindow, interval: interval}
+}
+
+// Run purges expired users right away and then every interval, until ctx is done.
+func (w *PurgeWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.PurgeOnce(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce removes the users whose restore window ended before now and returns how many were removed.
+// Failures are logged, the next run tries again.
+func (w *PurgeWorker) PurgeOnce(now time.Time) int {
+	purged, err := w.repo.PurgeDeletedUsers(now.Add(-w.restoreWindow))
+	if err != nil {
+		w.log.Error("Failed to purge deleted users", zap.Int("purged", purged), zap.Error(err))
+	} else if purged > 0 {
+		w.log.Info("Purged deleted users", zap.Int("purged", purged))
+	}
+	return purged
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
+)
+
+// RoleService defines the interface for role and permission lookups.
+type RoleService interface {
+	LoadRoles(roles []model.Role) error                         // Replace the stored role definitions.
+	FindRole(name string) (*model.Role, error)                  // Retrieve a role definition by name.
+	HasPermission(role string, permission string) (bool, error) // Check if a role grants a permission.
+}
+
+type roleServiceImpl struct {
+	repo local.Repository // Reference to the repository for database operations.
+}
+
+// NewRoleService creates a new instance of RoleService.
+func NewRoleService(repo local.Repository) RoleService {
+	return &roleServiceImpl{repo: repo} // Initialize roleServiceImpl with the provided repository.
+}
+
+// LoadRoles stores the configured role definitions and removes stored roles that are no longer configured,
+// so the configuration stays the single source of truth across restarts.
+func (s *roleServiceImpl) LoadRoles(roles []model.Role) error {
+	configured := make(map[string]bool, len(roles))
+	for i := range roles {
+		if roles[i].Name == "" {
+			return fmt.Errorf("role without a name") // Return error if a definition is incomplete.
+		}
+		if err := s.repo.SaveRole(&roles[i]); err != nil {
+			return err // Return error if the role cannot be stored.
+		}
+		configured[roles[i].Name] = true
+	}
+
+	stored, err := s.repo.FindAllRoles()
+	if err != nil {
+		return err // Return error if the stored roles cannot be listed.
+	}
+	for _, role := range stored {
+		if !configured[role.Name] {
+			if err := s.repo.DeleteRole(role.Name); err != nil {
+				return err // Return error if a stale role cannot be removed.
+			}
+		}
+	}
+	return nil
+}
+
+// FindRole retrieves a role definition by name.
+func (s *roleServiceImpl) FindRole(name string) (*model.Role, error) {
+	return s.repo.FindRole(name)
+}
+
+// HasPermission checks if a role grants a permission. Unknown roles grant nothing.
+func (s *roleServiceImpl) HasPermission(role string, permission string) (bool, error) {
+	// Attempt to find the role by the provided name.
+	definition, err := s.repo.FindRole(role)
+	if err != nil {
+		// If the role is not defined, it grants no permissions.
+		if errors.Is(err, local.ErrNotFound) {
+			return false, nil
+		}
+		// If another error occurred while checking, return it.
+		return false, err
+	}
+	return definition.Has(permission), nil // Return true if the role grants the permission.
+}
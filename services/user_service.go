@@ -1,6 +1,8 @@
 package services
 
 import (
+	"errors"
+
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/repository/local"
 )
@@ -8,6 +10,7 @@ import (
 // UserService defines the interface for user-related operations.
 type UserService interface {
 	IsEmailTaken(email string) (bool, error)       // Check if an email is already taken.
+	IsUsernameTaken(username string) (bool, error) // Check if a username is already taken.
 	FindByEmail(email string) (*model.User, error) // Retrieve a user by their email address.
 }
 
@@ -26,7 +29,7 @@ func (s *userServiceImpl) IsEmailTaken(email string) (bool, error) {
 	user, err := s.repo.FindOneByEmail(email)
 	if err != nil {
 		// If user is not found, it means the email is not taken.
-		if err.Error() == "user not found" {
+		if errors.Is(err, local.ErrNotFound) {
 			return false, nil // Email is not taken, return false.
 		}
 		// If another error occurred while checking, return it.
@@ -36,6 +39,22 @@ func (s *userServiceImpl) IsEmailTaken(email string) (bool, error) {
 	return user != nil, nil // Return true if the user exists (email is taken).
 }
 
+// IsUsernameTaken checks if a username is already taken.
+func (s *userServiceImpl) IsUsernameTaken(username string) (bool, error) {
+	// Attempt to find a user by the provided username.
+	user, err := s.repo.FindOneByUsername(username)
+	if err != nil {
+		// If user is not found, it means the username is not taken.
+		if errors.Is(err, local.ErrNotFound) {
+			return false, nil // Username is not taken, return false.
+		}
+		// If another error occurred while checking, return it.
+		return false, err
+	}
+	// If user is found, username is taken.
+	return user != nil, nil // Return true if the user exists (username is taken).
+}
+
 // FindByEmail retrieves a user by their email address.
 func (s *userServiceImpl) FindByEmail(email string) (*model.User, error) {
 	// Attempt to find a user by the provided email.
@@ -0,0 +1,53 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ErrEdDSAVerification is returned when an EdDSA signature does not match.
+var ErrEdDSAVerification = errors.New("crypto/ed25519: verification error")
+
+// signingMethodEdDSA implements the EdDSA (Ed25519) signing method, which jwt-go v3 does not ship with.
+type signingMethodEdDSA struct{}
+
+// SigningMethodEdDSA signs tokens with Ed25519 keys.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// Alg returns the JWS algorithm name.
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks the signature of signingString with an ed25519.PublicKey.
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return ErrEdDSAVerification
+	}
+	return nil
+}
+
+// Sign signs signingString with an ed25519.PrivateKey.
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
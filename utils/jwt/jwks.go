@@ -0,0 +1,65 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is the JSON Web Key representation of a public verification key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public half of every asymmetric key, so other services can verify tokens
+// without holding a signing secret. HMAC keys are never published.
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.Keys() {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeBigInt(public.N, 0)
+			jwk.E = encodeBigInt(big.NewInt(int64(public.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = encodeBigInt(public.X, size)
+			jwk.Y = encodeBigInt(public.Y, size)
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue // Symmetric keys must stay secret.
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// encodeBigInt base64url encodes an integer, left padding it to size bytes when size is set.
+func encodeBigInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,95 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+// decodeBigInt decodes a base64url encoded integer of a JWK and returns it with its encoded length.
+func decodeBigInt(t *testing.T, value string) (*big.Int, int) {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("Error decoding %q: %v", value, err)
+	}
+	return new(big.Int).SetBytes(b), len(b)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaSigner := generateKey(t, "rsa")
+	ecSigner := generateKey(t, "ec256")
+	edSigner := generateKey(t, "ed25519")
+
+	keyring := NewKeyring()
+	for _, key := range []*Key{
+		NewHMACKey(DefaultKeyID, []byte("secret")),
+		parseKey(t, "ec-1", privatePEM(t, ecSigner)),
+		parseKey(t, "ed-1", privatePEM(t, edSigner)),
+		parseKey(t, "rsa-1", publicPEM(t, rsaSigner)),
+	} {
+		if err := keyring.Add(key); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	// The HMAC key is left out, the rest is ordered by key ID
+	set := keyring.JWKS()
+	if len(set.Keys) != 3 {
+		t.Fatalf("JWKS() = %d keys, want 3 without the HMAC key", len(set.Keys))
+	}
+
+	testCases := []struct {
+		name    string
+		jwk     JWK
+		wantKid string
+		wantKty string
+		wantAlg string
+		wantCrv string
+		check   func(t *testing.T, jwk JWK)
+	}{
+		{
+			name: "ECDSA", jwk: set.Keys[0], wantKid: "ec-1", wantKty: "EC", wantAlg: "ES256", wantCrv: "P-256",
+			check: func(t *testing.T, jwk JWK) {
+				public := ecSigner.Public().(*ecdsa.PublicKey)
+				x, xLen := decodeBigInt(t, jwk.X)
+				y, yLen := decodeBigInt(t, jwk.Y)
+				if x.Cmp(public.X) != 0 || y.Cmp(public.Y) != 0 || xLen != 32 || yLen != 32 {
+					t.Fatalf("EC coordinates do not match the key or are not padded to 32 bytes")
+				}
+			},
+		},
+		{
+			name: "Ed25519", jwk: set.Keys[1], wantKid: "ed-1", wantKty: "OKP", wantAlg: "EdDSA", wantCrv: "Ed25519",
+			check: func(t *testing.T, jwk JWK) {
+				public := edSigner.Public().(ed25519.PublicKey)
+				if jwk.X != base64.RawURLEncoding.EncodeToString(public) || jwk.Y != "" {
+					t.Fatalf("x = %q, y = %q, want the raw public key and no y", jwk.X, jwk.Y)
+				}
+			},
+		},
+		{
+			name: "RSA", jwk: set.Keys[2], wantKid: "rsa-1", wantKty: "RSA", wantAlg: "RS256", wantCrv: "",
+			check: func(t *testing.T, jwk JWK) {
+				public := rsaSigner.Public().(*rsa.PublicKey)
+				n, _ := decodeBigInt(t, jwk.N)
+				e, _ := decodeBigInt(t, jwk.E)
+				if n.Cmp(public.N) != 0 || e.Int64() != int64(public.E) || jwk.E != "AQAB" {
+					t.Fatalf("n and e do not match the key, e = %q", jwk.E)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.jwk.Kid != tc.wantKid || tc.jwk.Kty != tc.wantKty || tc.jwk.Alg != tc.wantAlg || tc.jwk.Crv != tc.wantCrv || tc.jwk.Use != "sig" {
+				t.Fatalf("JWK = %+v, want kid %s, kty %s, alg %s, crv %q, use sig", tc.jwk, tc.wantKid, tc.wantKty, tc.wantAlg, tc.wantCrv)
+			}
+			tc.check(t, tc.jwk)
+		})
+	}
+}
@@ -2,35 +2,62 @@ package jwt
 
 import (
 	"github.com/dgrijalva/jwt-go"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/id"
 	"time"
 )
 
-// GenerateTokens creates both access and refresh tokens for a user
-func GenerateTokens(userID, username, role string, jwtSecret []byte) (string, string, error) {
+const (
+	AccessTokenTTL  = 10 * time.Minute   // Lifetime of access tokens
+	RefreshTokenTTL = 7 * 24 * time.Hour // Lifetime of refresh tokens and the sessions they belong to
+)
+
+// Values of the typ claim, which keeps a token from being used in place of the other kind.
+const (
+	TokenTypeAccess  = "access"  // Bearer token for protected routes
+	TokenTypeRefresh = "refresh" // Token that is only accepted by the refresh endpoint
+)
+
+// GenerateTokens creates both access and refresh tokens for a user session, signed with the active key of the keyring
+func GenerateTokens(userID, username, role, sessionID string, keyring *Keyring) (string, string, error) {
 	// Access token for 10 minutes
 	accessTokenClaims := jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
-		"role":     role,
-		"exp":      time.Now().Add(10 * time.Minute).Unix(),
+		"user_id":    userID,
+		"username":   username,
+		"role":       role,
+		"session_id": sessionID,
+		"typ":        TokenTypeAccess,
+		"jti":        id.GenerateUUID(), // Identifies the token in the revocation list
+		"exp":        time.Now().Add(AccessTokenTTL).Unix(),
 	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessTokenString, err := accessToken.SignedString(jwtSecret)
+	accessTokenString, err := keyring.Sign(accessTokenClaims)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Refresh token for 7 days
 	refreshTokenClaims := jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
-		"exp":      time.Now().Add(7 * 24 * time.Hour).Unix(),
+		"user_id":    userID,
+		"username":   username,
+		"session_id": sessionID,
+		"typ":        TokenTypeRefresh,
+		"jti":        id.GenerateUUID(), // Unique per issuance so two refresh tokens of a session never collide
+		"exp":        time.Now().Add(RefreshTokenTTL).Unix(),
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	refreshTokenString, err := refreshToken.SignedString(jwtSecret)
+	refreshTokenString, err := keyring.Sign(refreshTokenClaims)
 	if err != nil {
 		return "", "", err
 	}
 
 	return accessTokenString, refreshTokenString, nil
 }
+
+// TokenID returns the jti claim of a token without verifying it, for tokens the caller has just issued itself.
+func TokenID(tokenStr string) string {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenStr, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	tokenID, _ := claims["jti"].(string)
+	return tokenID
+}
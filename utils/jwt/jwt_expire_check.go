@@ -1,26 +1,27 @@
 package jwt
 
 import (
-	"github.com/dgrijalva/jwt-go"
 	"time"
 )
 
-// IsExpired checks whether a given JWT token has expired.
-func IsExpired(tokenStr string, jwtSecret []byte) bool {
-	// Parse the token using the JWT secret
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil // Use the secret passed as a parameter
-	})
+// IsExpired checks whether a given refresh token has expired. Tokens of another type count as expired.
+func IsExpired(tokenStr string, keyring *Keyring) bool {
+	// Parse the token using the key named in its header
+	claims, err := keyring.Parse(tokenStr)
 
 	// If there's an error or token is invalid, assume it's expired
-	if err != nil || !token.Valid {
+	if err != nil {
+		return true
+	}
+
+	// Access tokens must not be exchanged for new tokens
+	if typ, _ := claims["typ"].(string); typ != TokenTypeRefresh {
 		return true
 	}
 
 	// Extract the exp claim (expiration time)
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		exp := int64(claims["exp"].(float64)) // Convert exp to int64
-		return time.Now().Unix() > exp        // Check if current time is past the expiration time
+	if exp, ok := claims["exp"].(float64); ok {
+		return time.Now().Unix() > int64(exp) // Check if current time is past the expiration time
 	}
 
 	return true // If claims are missing or invalid, assume token is expired
@@ -0,0 +1,238 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// DefaultKeyID is the key ID of the HS256 key derived from JWT_SECRET. Tokens issued before the keyring
+// existed carry no kid header and are verified with this key.
+const DefaultKeyID = "default"
+
+// Key is a single key of the keyring. Keys without a private half can only verify tokens.
+type Key struct {
+	ID         string            // Key ID published in the kid header and the JWKS
+	Method     jwt.SigningMethod // Signing method derived from the key type
+	signingKey interface{}       // Private key or HMAC secret, nil for verify-only keys
+	verifyKey  interface{}       // Public key or HMAC secret
+}
+
+// CanSign reports whether the key holds the material needed to sign tokens.
+func (k *Key) CanSign() bool {
+	return k.signingKey != nil
+}
+
+// Keyring holds every key tokens are verified with and the single active key new tokens are signed with.
+type Keyring struct {
+	keys   map[string]*Key
+	active *Key
+}
+
+// NewKeyring creates an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key)}
+}
+
+// NewHMACKey creates an HS256 key from a shared secret.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signingKey: secret, verifyKey: secret}
+}
+
+// ParseKeyPEM creates a key from a PEM encoded RSA, ECDSA or Ed25519 key. Private keys can sign,
+// public keys are verify-only.
+func ParseKeyPEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q: no PEM data found", id)
+	}
+
+	var signingKey, publicKey interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		signingKey = parsed
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		signingKey = parsed
+	case "EC PRIVATE KEY":
+		parsed, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		signingKey = parsed
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		publicKey = parsed
+	default:
+		return nil, fmt.Errorf("key %q: unsupported PEM block %q", id, block.Type)
+	}
+
+	// Derive the public half from private keys.
+	if signer, ok := signingKey.(crypto.Signer); ok {
+		publicKey = signer.Public()
+	}
+
+	method, err := methodFor(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	return &Key{ID: id, Method: method, signingKey: signingKey, verifyKey: publicKey}, nil
+}
+
+// methodFor picks the signing method matching a public key type.
+func methodFor(publicKey interface{}) (jwt.SigningMethod, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported elliptic curve %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", publicKey)
+}
+
+// LoadKeyring builds the keyring from the JWT secret and a directory of PEM files named <kid>.pem.
+// The key named activeKeyID signs new tokens, every other key is verify-only.
+func LoadKeyring(secret []byte, keysDir string, activeKeyID string) (*Keyring, error) {
+	keyring := NewKeyring()
+
+	// The shared secret stays available so tokens issued before a migration keep working.
+	if len(secret) > 0 {
+		if err := keyring.Add(NewHMACKey(DefaultKeyID, secret)); err != nil {
+			return nil, err
+		}
+	}
+
+	if keysDir != "" {
+		paths, err := filepath.Glob(filepath.Join(keysDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			key, err := ParseKeyPEM(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+			if err != nil {
+				return nil, err
+			}
+			if err := keyring.Add(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if activeKeyID == "" {
+		activeKeyID = DefaultKeyID
+	}
+	if err := keyring.SetActive(activeKeyID); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Add puts a key on the keyring as verify-only.
+func (k *Keyring) Add(key *Key) error {
+	if _, exists := k.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate key id %q", key.ID)
+	}
+	k.keys[key.ID] = key
+	return nil
+}
+
+// SetActive selects the key new tokens are signed with.
+func (k *Keyring) SetActive(keyID string) error {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return fmt.Errorf("active key %q is not on the keyring", keyID)
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("active key %q has no private key", keyID)
+	}
+	k.active = key
+	return nil
+}
+
+// Sign signs the claims with the active key and records its ID in the kid header.
+func (k *Keyring) Sign(claims jwt.MapClaims) (string, error) {
+	if k.active == nil {
+		return "", errors.New("keyring has no active key")
+	}
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.signingKey)
+}
+
+// Parse verifies a token against the key named in its kid header and returns its claims.
+func (k *Keyring) Parse(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, k.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	return claims, nil
+}
+
+// keyFunc resolves the verification key of a token, refusing tokens whose algorithm does not match the key.
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+	if keyID == "" {
+		keyID = DefaultKeyID // Tokens issued before the keyring existed have no kid header.
+	}
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", keyID)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), keyID)
+	}
+	return key.verifyKey, nil
+}
+
+// Keys returns the keys on the keyring ordered by ID.
+func (k *Keyring) Keys() []*Key {
+	keys := make([]*Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
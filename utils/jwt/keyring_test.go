@@ -0,0 +1,228 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// generateKey creates a private key of the given type: "rsa", "ec256", "ec384" or "ed25519".
+func generateKey(t *testing.T, keyType string) crypto.Signer {
+	t.Helper()
+	var signer crypto.Signer
+	var err error
+	switch keyType {
+	case "rsa":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ec256":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec384":
+		signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("Unknown key type %q", keyType)
+	}
+	if err != nil {
+		t.Fatalf("Error generating %s key: %v", keyType, err)
+	}
+	return signer
+}
+
+// privatePEM encodes a private key as a PKCS #8 PEM block.
+func privatePEM(t *testing.T, signer crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		t.Fatalf("Error encoding private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// publicPEM encodes the public half of a key as a PKIX PEM block.
+func publicPEM(t *testing.T, signer crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatalf("Error encoding public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// parseKey parses a PEM key and fails the test on errors.
+func parseKey(t *testing.T, id string, data []byte) *Key {
+	t.Helper()
+	key, err := ParseKeyPEM(id, data)
+	if err != nil {
+		t.Fatalf("ParseKeyPEM() error = %v", err)
+	}
+	return key
+}
+
+// activeKeyring creates a keyring that signs with the given key.
+func activeKeyring(t *testing.T, keys ...*Key) *Keyring {
+	t.Helper()
+	keyring := NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(key); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if err := keyring.SetActive(keys[0].ID); err != nil {
+		t.Fatalf("SetActive() error = %v", err)
+	}
+	return keyring
+}
+
+func TestKeyringSignAndParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		keyType string
+		wantAlg string
+	}{
+		{name: "RSA", keyType: "rsa", wantAlg: "RS256"},
+		{name: "ECDSA P-256", keyType: "ec256", wantAlg: "ES256"},
+		{name: "ECDSA P-384", keyType: "ec384", wantAlg: "ES384"},
+		{name: "Ed25519", keyType: "ed25519", wantAlg: "EdDSA"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := parseKey(t, "key-1", privatePEM(t, generateKey(t, tc.keyType)))
+			if key.Method.Alg() != tc.wantAlg || !key.CanSign() {
+				t.Fatalf("ParseKeyPEM() alg = %s, can sign = %v, want %s and a signing key", key.Method.Alg(), key.CanSign(), tc.wantAlg)
+			}
+			keyring := activeKeyring(t, key)
+
+			tokenStr, err := keyring.Sign(jwt.MapClaims{"sub": "user123"})
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			token, _, err := new(jwt.Parser).ParseUnverified(tokenStr, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("Error decoding token: %v", err)
+			}
+			if token.Header["kid"] != "key-1" || token.Header["alg"] != tc.wantAlg {
+				t.Fatalf("token header = %v, want kid key-1 and alg %s", token.Header, tc.wantAlg)
+			}
+
+			claims, err := keyring.Parse(tokenStr)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if claims["sub"] != "user123" {
+				t.Fatalf("Parse() sub = %v, want user123", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestKeyringRejectsTokens(t *testing.T) {
+	rsaKey := parseKey(t, "rsa-1", privatePEM(t, generateKey(t, "rsa")))
+	keyring := activeKeyring(t, rsaKey, NewHMACKey(DefaultKeyID, []byte("secret")))
+
+	// Tokens of a keyring that shares no keys with the one verifying them
+	other := activeKeyring(t, parseKey(t, "other-1", privatePEM(t, generateKey(t, "ec256"))))
+	unknownKid, err := other.Sign(jwt.MapClaims{"sub": "user123"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	sameKid := activeKeyring(t, parseKey(t, "rsa-1", privatePEM(t, generateKey(t, "rsa"))))
+	forged, err := sameKid.Sign(jwt.MapClaims{"sub": "user123"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	// A token signed with the public RSA key as HMAC secret must not pass as an RS256 token
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user123"})
+	confused.Header["kid"] = "rsa-1"
+	confusedStr, err := confused.SignedString(publicPEM(t, rsaKey.signingKey.(crypto.Signer)))
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{name: "Unknown Key ID", token: unknownKid},
+		{name: "Wrong Key With Known ID", token: forged},
+		{name: "Algorithm Of Another Key", token: confusedStr},
+		{name: "Malformed Token", token: "not.a.token"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := keyring.Parse(tc.token); err == nil {
+				t.Fatalf("Parse() accepted the token")
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldSigner := generateKey(t, "rsa")
+	oldKey := parseKey(t, "2023-01", privatePEM(t, oldSigner))
+	keyring := activeKeyring(t, oldKey)
+	oldToken, err := keyring.Sign(jwt.MapClaims{"sub": "user123"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	// Rotate to a new key, the old one is retired but stays on the keyring for verification
+	newKey := parseKey(t, "2024-01", privatePEM(t, generateKey(t, "ed25519")))
+	if err := keyring.Add(newKey); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := keyring.SetActive(newKey.ID); err != nil {
+		t.Fatalf("SetActive() error = %v", err)
+	}
+	newToken, err := keyring.Sign(jwt.MapClaims{"sub": "user123"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, jwt.MapClaims{})
+	if err != nil || token.Header["kid"] != newKey.ID {
+		t.Fatalf("token after rotation has kid %v, %v, want %s", token.Header["kid"], err, newKey.ID)
+	}
+
+	// A retired key that is only deployed as its public half verifies, but cannot sign
+	retired := parseKey(t, oldKey.ID, publicPEM(t, oldSigner))
+	if retired.CanSign() {
+		t.Fatalf("public key can sign")
+	}
+	verifier := activeKeyring(t, newKey)
+	if err := verifier.Add(retired); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := verifier.SetActive(retired.ID); err == nil {
+		t.Fatalf("SetActive() accepted a verify-only key")
+	}
+
+	testCases := []struct {
+		name    string
+		keyring *Keyring
+		token   string
+	}{
+		{name: "Old Token, Retired Private Key", keyring: keyring, token: oldToken},
+		{name: "New Token, Rotated Keyring", keyring: keyring, token: newToken},
+		{name: "Old Token, Retired Public Key", keyring: verifier, token: oldToken},
+		{name: "New Token, Verifier", keyring: verifier, token: newToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.keyring.Parse(tc.token); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedList tells whether a password is known from a data breach.
+type BreachedList interface {
+	Contains(password string) (bool, error)
+}
+
+// RangeDirectory is a breached password list in the k-anonymity range format of Have I Been Pwned:
+// one file per 5 character SHA-1 prefix, named "<PREFIX>" or "<PREFIX>.txt", with lines of
+// "<35 character SUFFIX>:<COUNT>". Files are read on demand, so the full dump never has to fit in memory.
+// A missing range file means no password with that prefix is known.
+type RangeDirectory struct {
+	Dir      string // Directory holding the range files
+	MinCount int    // Passwords seen fewer times than this are accepted, 0 or 1 rejects every listed password
+}
+
+// Contains looks the SHA-1 hash of the password up in its range file.
+func (d RangeDirectory) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := d.open(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineSuffix, count, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		if d.MinCount <= 1 {
+			return true, nil
+		}
+		n, err := strconv.Atoi(count)
+		return err == nil && n >= d.MinCount, nil
+	}
+	return false, scanner.Err()
+}
+
+// open opens the range file of a prefix, accepting both naming conventions of HIBP downloaders.
+func (d RangeDirectory) open(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(d.Dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	}
+	return file, err
+}
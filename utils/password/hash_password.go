@@ -1,14 +1,20 @@
 package password
 
-import (
-	"golang.org/x/crypto/bcrypt"
-)
+// defaultHasher hashes with Argon2id and still verifies the bcrypt hashes of accounts created before it.
+var defaultHasher = NewHasher(DefaultArgon2id, DefaultScrypt, DefaultBcrypt)
 
-// HashPassword hashes the given password using bcrypt.
+// SetDefault replaces the hasher used by HashPassword and VerifyPassword. It is meant to be called once at startup.
+func SetDefault(hasher *Hasher) {
+	defaultHasher = hasher
+}
+
+// HashPassword hashes the given password with the preferred algorithm of the default hasher.
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
+	return defaultHasher.Hash(password)
+}
+
+// VerifyPassword checks a password against a stored hash. needsRehash reports that the hash should be
+// replaced with HashPassword because it uses another algorithm or outdated parameters.
+func VerifyPassword(password string, hashed string) (match bool, needsRehash bool, err error) {
+	return defaultHasher.Verify(password, hashed)
 }
@@ -0,0 +1,229 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrUnknownAlgorithm is returned for hashes produced by an algorithm the hasher does not know.
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+// b64 is the unpadded standard base64 encoding PHC strings use for salts and hashes.
+var b64 = base64.RawStdEncoding
+
+// Algorithm hashes passwords into self-describing strings and verifies passwords against them.
+type Algorithm interface {
+	// ID is the algorithm identifier of the PHC string, e.g. "argon2id".
+	ID() string
+	// Hash returns the encoded hash of a password using the algorithm's current parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash, and whether the hash was
+	// produced with parameters other than the current ones.
+	Verify(password string, encoded string) (match bool, outdated bool, err error)
+}
+
+// Hasher hashes new passwords with its preferred algorithm and verifies hashes of every algorithm it knows.
+type Hasher struct {
+	preferred  Algorithm
+	algorithms map[string]Algorithm
+}
+
+// NewHasher creates a hasher that hashes with preferred and also verifies hashes of the other algorithms.
+func NewHasher(preferred Algorithm, others ...Algorithm) *Hasher {
+	hasher := &Hasher{preferred: preferred, algorithms: map[string]Algorithm{preferred.ID(): preferred}}
+	for _, algorithm := range others {
+		if _, exists := hasher.algorithms[algorithm.ID()]; !exists {
+			hasher.algorithms[algorithm.ID()] = algorithm
+		}
+	}
+	return hasher
+}
+
+// Hash hashes a password with the preferred algorithm.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+// Verify checks a password against an encoded hash. needsRehash is set when the password matches but the
+// hash was made with another algorithm or outdated parameters, so the caller should store a fresh hash.
+func (h *Hasher) Verify(password string, encoded string) (match bool, needsRehash bool, err error) {
+	id := algorithmID(encoded)
+	algorithm, ok := h.algorithms[id]
+	if !ok {
+		return false, false, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, id)
+	}
+	match, outdated, err := algorithm.Verify(password, encoded)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, outdated || id != h.preferred.ID(), nil
+}
+
+// algorithmID extracts the algorithm identifier of an encoded hash. bcrypt's own $2a$/$2b$/$2y$ prefixes all map to "bcrypt".
+func algorithmID(encoded string) string {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return ""
+	}
+	switch parts[1] {
+	case "2a", "2b", "2y":
+		return "bcrypt"
+	}
+	return parts[1]
+}
+
+// salt returns n random bytes.
+func salt(n uint32) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Argon2id hashes passwords with Argon2id (RFC 9106) into $argon2id$v=19$m=<KiB>,t=<iterations>,p=<lanes>$<salt>$<hash>.
+type Argon2id struct {
+	Memory      uint32 // Memory in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the second recommended option of RFC 9106 with a 64 MiB memory cost.
+var DefaultArgon2id = Argon2id{Memory: 64 * 1024, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}
+
+func (a Argon2id) ID() string { return "argon2id" }
+
+func (a Argon2id) Hash(password string) (string, error) {
+	s, err := salt(a.SaltLength)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), s, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations, a.Parallelism, b64.EncodeToString(s), b64.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(password string, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, fmt.Errorf("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	var params Argon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return false, false, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	s, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("malformed argon2id hash: %w", err)
+	}
+
+	candidate := argon2.IDKey([]byte(password), s, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	match := subtle.ConstantTimeCompare(candidate, key) == 1
+	outdated := params.Memory != a.Memory || params.Iterations != a.Iterations || params.Parallelism != a.Parallelism ||
+		uint32(len(s)) != a.SaltLength || uint32(len(key)) != a.KeyLength
+	return match, outdated, nil
+}
+
+// Scrypt hashes passwords with scrypt into $scrypt$ln=<log2 N>,r=<block size>,p=<parallelism>$<salt>$<hash>.
+type Scrypt struct {
+	LogN        uint8 // Cost parameter N as a power of two
+	BlockSize   int
+	Parallelism int
+	SaltLength  uint32
+	KeyLength   int
+}
+
+// DefaultScrypt uses N=2^15, r=8, p=1 as recommended for interactive logins.
+var DefaultScrypt = Scrypt{LogN: 15, BlockSize: 8, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func (s Scrypt) ID() string { return "scrypt" }
+
+func (s Scrypt) Hash(password string) (string, error) {
+	sa, err := salt(s.SaltLength)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), sa, 1<<s.LogN, s.BlockSize, s.Parallelism, s.KeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", s.LogN, s.BlockSize, s.Parallelism, b64.EncodeToString(sa), b64.EncodeToString(key)), nil
+}
+
+func (s Scrypt) Verify(password string, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return false, false, fmt.Errorf("malformed scrypt hash")
+	}
+	var params Scrypt
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.BlockSize, &params.Parallelism); err != nil {
+		return false, false, fmt.Errorf("malformed scrypt parameters: %w", err)
+	}
+	sa, err := b64.DecodeString(parts[3])
+	if err != nil {
+		return false, false, fmt.Errorf("malformed scrypt salt: %w", err)
+	}
+	key, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("malformed scrypt hash: %w", err)
+	}
+
+	candidate, err := scrypt.Key([]byte(password), sa, 1<<params.LogN, params.BlockSize, params.Parallelism, len(key))
+	if err != nil {
+		return false, false, err
+	}
+	match := subtle.ConstantTimeCompare(candidate, key) == 1
+	outdated := params.LogN != s.LogN || params.BlockSize != s.BlockSize || params.Parallelism != s.Parallelism ||
+		uint32(len(sa)) != s.SaltLength || len(key) != s.KeyLength
+	return match, outdated, nil
+}
+
+// Bcrypt hashes passwords with bcrypt in its own modular crypt format, $2a$<cost>$<salt and hash>.
+type Bcrypt struct {
+	Cost int
+}
+
+// DefaultBcrypt uses bcrypt's default cost, which existing accounts were hashed with.
+var DefaultBcrypt = Bcrypt{Cost: bcrypt.DefaultCost}
+
+func (b Bcrypt) ID() string { return "bcrypt" }
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (b Bcrypt) Verify(password string, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+	return true, cost != b.Cost, nil
+}
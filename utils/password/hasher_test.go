@@ -0,0 +1,95 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap parameters keep the tests fast, they are not meant for production.
+var (
+	testArgon2id = Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testScrypt   = Scrypt{LogN: 4, BlockSize: 8, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testBcrypt   = Bcrypt{Cost: 4}
+)
+
+func TestHasherVerify(t *testing.T) {
+	hasher := NewHasher(testArgon2id, testScrypt, testBcrypt)
+
+	hashWith := func(algorithm Algorithm) string {
+		encoded, err := algorithm.Hash("password123")
+		if err != nil {
+			t.Fatalf("Hash() error = %v", err)
+		}
+		return encoded
+	}
+
+	testCases := []struct {
+		name            string
+		encoded         string
+		password        string
+		wantMatch       bool
+		wantNeedsRehash bool
+		wantErr         bool
+	}{
+		{
+			name:      "Preferred Algorithm",
+			encoded:   hashWith(testArgon2id),
+			password:  "password123",
+			wantMatch: true,
+		},
+		{
+			name:     "Wrong Password",
+			encoded:  hashWith(testArgon2id),
+			password: "password124",
+		},
+		{
+			name:            "Outdated Parameters",
+			encoded:         hashWith(Argon2id{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}),
+			password:        "password123",
+			wantMatch:       true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:            "Other Algorithm Scrypt",
+			encoded:         hashWith(testScrypt),
+			password:        "password123",
+			wantMatch:       true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:            "Other Algorithm Bcrypt",
+			encoded:         hashWith(testBcrypt),
+			password:        "password123",
+			wantMatch:       true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:     "Unknown Algorithm",
+			encoded:  "$md5$abc$def",
+			password: "password123",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			match, needsRehash, err := hasher.Verify(tc.password, tc.encoded)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr = %v", err, tc.wantErr)
+			}
+			if match != tc.wantMatch || needsRehash != tc.wantNeedsRehash {
+				t.Fatalf("Verify() = (%v, %v), want (%v, %v)", match, needsRehash, tc.wantMatch, tc.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	encoded, err := testArgon2id.Hash("password123")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Hash() = %v, want a PHC string with the configured parameters", encoded)
+	}
+}
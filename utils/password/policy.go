@@ -0,0 +1,140 @@
+package password
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Codes of policy violations. They are stable identifiers clients can translate.
+const (
+	ViolationTooShort         = "password_too_short"
+	ViolationTooLong          = "password_too_long"
+	ViolationMissingUppercase = "password_missing_uppercase"
+	ViolationMissingLowercase = "password_missing_lowercase"
+	ViolationMissingDigit     = "password_missing_digit"
+	ViolationMissingSymbol    = "password_missing_symbol"
+	ViolationContainsUserInfo = "password_contains_user_info"
+	ViolationBreached         = "password_breached"
+)
+
+// Violation is a single reason a password was refused.
+type Violation struct {
+	Code    string // One of the Violation* codes
+	Param   string // Parameter of the rule, e.g. the minimum length
+	Message string // English explanation, clients can translate Code instead
+}
+
+// Policy decides which passwords are acceptable.
+type Policy struct {
+	MinLength        int          // Minimum number of characters
+	MaxLength        int          // Maximum number of bytes, bcrypt ignores everything after 72 bytes
+	RequireUppercase bool         // At least one upper case letter
+	RequireLowercase bool         // At least one lower case letter
+	RequireDigit     bool         // At least one digit
+	RequireSymbol    bool         // At least one character that is neither a letter nor a digit
+	ForbidUserInfo   bool         // Refuse passwords containing the username or email address
+	Breached         BreachedList // Known breached passwords, nil to skip the check
+}
+
+// DefaultPolicy follows NIST SP 800-63B: a minimum length and no composition rules.
+var DefaultPolicy = Policy{
+	MinLength:      8,
+	MaxLength:      72,
+	ForbidUserInfo: true,
+}
+
+// minIdentifierLength keeps very short identifiers from rejecting most passwords.
+const minIdentifierLength = 3
+
+// Check returns every rule the password breaks. identifiers are the username, email address and similar
+// values the password must not contain. An error means the breached password list could not be read.
+func (p *Policy) Check(password string, identifiers ...string) ([]Violation, error) {
+	var violations []Violation
+
+	if length := utf8.RuneCountInString(password); length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooShort,
+			Param:   strconv.Itoa(p.MinLength),
+			Message: "password must be at least " + strconv.Itoa(p.MinLength) + " characters long",
+		})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooLong,
+			Param:   strconv.Itoa(p.MaxLength),
+			Message: "password must be at most " + strconv.Itoa(p.MaxLength) + " bytes long",
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUppercase && !hasUpper {
+		violations = append(violations, Violation{Code: ViolationMissingUppercase, Message: "password must contain an upper case letter"})
+	}
+	if p.RequireLowercase && !hasLower {
+		violations = append(violations, Violation{Code: ViolationMissingLowercase, Message: "password must contain a lower case letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{Code: ViolationMissingDigit, Message: "password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{Code: ViolationMissingSymbol, Message: "password must contain a symbol"})
+	}
+
+	if p.ForbidUserInfo {
+		if containsIdentifier(password, identifiers) {
+			violations = append(violations, Violation{
+				Code:    ViolationContainsUserInfo,
+				Message: "password must not contain your username or email address",
+			})
+		}
+	}
+
+	// The breached list is only consulted for passwords that pass the cheap rules
+	if p.Breached != nil && len(violations) == 0 {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Code:    ViolationBreached,
+				Message: "password appears in a known data breach, choose a different one",
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// containsIdentifier reports whether the password contains one of the identifiers, ignoring case. Email
+// addresses are also checked by their local part, so "alice@example.com" rejects "alice2024!".
+func containsIdentifier(password string, identifiers []string) bool {
+	lowered := strings.ToLower(password)
+	for _, identifier := range identifiers {
+		candidates := []string{identifier}
+		if at := strings.LastIndex(identifier, "@"); at > 0 {
+			candidates = append(candidates, identifier[:at])
+		}
+		for _, candidate := range candidates {
+			candidate = strings.ToLower(strings.TrimSpace(candidate))
+			if utf8.RuneCountInString(candidate) >= minIdentifierLength && strings.Contains(lowered, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8, of "Tr0ub4dor&3" it is in no range file
+	dir := t.TempDir()
+	ranges := "0018A45C4D1DEF81644B54AB7F969B88D65:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(ranges), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	strict := Policy{
+		MinLength:        10,
+		MaxLength:        72,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		ForbidUserInfo:   true,
+	}
+
+	testCases := []struct {
+		name        string
+		policy      Policy
+		password    string
+		identifiers []string
+		wantCodes   []string
+	}{
+		{
+			name:     "Default Policy Accepts Long Password",
+			policy:   DefaultPolicy,
+			password: "correct horse battery staple",
+		},
+		{
+			name:      "Too Short",
+			policy:    DefaultPolicy,
+			password:  "short",
+			wantCodes: []string{ViolationTooShort},
+		},
+		{
+			name:      "Too Long",
+			policy:    DefaultPolicy,
+			password:  string(make([]byte, 73)),
+			wantCodes: []string{ViolationTooLong},
+		},
+		{
+			name:      "Missing Character Classes",
+			policy:    strict,
+			password:  "alllowercaseletters",
+			wantCodes: []string{ViolationMissingUppercase, ViolationMissingDigit, ViolationMissingSymbol},
+		},
+		{
+			name:        "Contains Username",
+			policy:      DefaultPolicy,
+			password:    "JohnDoe-1990",
+			identifiers: []string{"johndoe", "jd@example.com"},
+			wantCodes:   []string{ViolationContainsUserInfo},
+		},
+		{
+			name:        "Contains Email Local Part",
+			policy:      DefaultPolicy,
+			password:    "alice2024!",
+			identifiers: []string{"someone", "alice@example.com"},
+			wantCodes:   []string{ViolationContainsUserInfo},
+		},
+		{
+			name:        "Short Identifiers Are Ignored",
+			policy:      DefaultPolicy,
+			password:    "a long passphrase",
+			identifiers: []string{"a", "lo@example.com"},
+		},
+		{
+			name:      "Breached",
+			policy:    Policy{MinLength: 8, Breached: RangeDirectory{Dir: dir}},
+			password:  "password",
+			wantCodes: []string{ViolationBreached},
+		},
+		{
+			name:     "Breached Below Minimum Count",
+			policy:   Policy{MinLength: 8, Breached: RangeDirectory{Dir: dir, MinCount: 10000000}},
+			password: "password",
+		},
+		{
+			name:     "Not Breached",
+			policy:   Policy{MinLength: 8, Breached: RangeDirectory{Dir: dir}},
+			password: "Tr0ub4dor&3",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			violations, err := tc.policy.Check(tc.password, tc.identifiers...)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			var codes []string
+			for _, violation := range violations {
+				codes = append(codes, violation.Code)
+			}
+			if !reflect.DeepEqual(codes, tc.wantCodes) {
+				t.Errorf("Check() codes = %v, want %v", codes, tc.wantCodes)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, truncated to six digits.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	testCases := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "59", unix: 59, want: "287082"},
+		{name: "1111111109", unix: 1111111109, want: "081804"},
+		{name: "1111111111", unix: 1111111111, want: "050471"},
+		{name: "1234567890", unix: 1234567890, want: "005924"},
+		{name: "2000000000", unix: 2000000000, want: "279037"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := Code(secret, Step(time.Unix(tc.unix, 0)))
+			if err != nil {
+				t.Fatalf("Code() error = %v", err)
+			}
+			if code != tc.want {
+				t.Fatalf("Code() = %v, want %v", code, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, _ := GenerateSecret()
+	now := time.Now()
+	current, _ := Code(secret, Step(now))
+	previous, _ := Code(secret, Step(now)-1)
+	stale, _ := Code(secret, Step(now)-3)
+
+	testCases := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{name: "Current Step", code: current, wantOK: true},
+		{name: "Previous Step Within Skew", code: previous, wantOK: true},
+		{name: "Stale Step", code: stale, wantOK: stale == current || stale == previous},
+		{name: "Wrong Length", code: "123", wantOK: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, ok := Validate(secret, tc.code, now); ok != tc.wantOK {
+				t.Fatalf("Validate() ok = %v, want %v", ok, tc.wantOK)
+			}
+		})
+	}
+}
@@ -3,31 +3,68 @@ package validator
 import (
 	"github.com/go-playground/validator/v10"
 	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+	"reflect"
 	"regexp"
+	"strings"
 )
 
+var (
+	emailRegex    = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+	usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+)
+
+// FieldError describes why a single field failed validation.
+type FieldError struct {
+	Field   string `json:"field"`           // JSON name of the invalid field
+	Rule    string `json:"rule"`            // Validation rule that failed, e.g. "required" or "min"
+	Param   string `json:"param,omitempty"` // Parameter of the rule, e.g. the minimum length
+	Message string `json:"message"`         // Human readable explanation
+}
+
 // Validate interface for the validator.
 type Validate interface {
 	Struct(s interface{}) error
-	ValidateUser(user *model.User) (bool, string)
+	ValidateUser(user *model.User) []FieldError
+	ValidateUserUpdate(updateData *model.User) []FieldError
 	ValidateEmailFormat(email string) bool
+	ValidatePassword(plain string, user *model.User) ([]FieldError, error)
 }
 
 type validatorImpl struct {
-	v *validator.Validate
+	v      *validator.Validate
+	policy *password.Policy
 }
 
-// NewValidator creates a new instance of validator.
-func NewValidator() Validate {
+// NewValidator creates a new instance of validator. Passwords are checked against policy, or
+// password.DefaultPolicy when it is nil.
+func NewValidator(policy *password.Policy) Validate {
+	if policy == nil {
+		policy = &password.DefaultPolicy
+	}
+
 	v := validator.New()
 
+	// Report fields by their JSON names, which is what clients send
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
+		}
+		return name
+	})
+
 	// Email format validation with regex
 	v.RegisterValidation("email_format", func(fl validator.FieldLevel) bool {
-		re := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-		return re.MatchString(fl.Field().String())
+		return emailRegex.MatchString(fl.Field().String())
 	})
 
-	return &validatorImpl{v: v}
+	// Usernames are limited to letters, digits, dots, dashes and underscores
+	v.RegisterValidation("username_format", func(fl validator.FieldLevel) bool {
+		return usernameRegex.MatchString(fl.Field().String())
+	})
+
+	return &validatorImpl{v: v, policy: policy}
 }
 
 // Struct performs validation on a struct.
@@ -35,14 +72,109 @@ func (v *validatorImpl) Struct(s interface{}) error {
 	return v.v.Struct(s)
 }
 
-// ValidateUser validates the user struct.
-func (v *validatorImpl) ValidateUser(user *model.User) (bool, string) {
-	// Validation logic...
-	return true, ""
+// ValidateUser validates a complete user, as sent on registration.
+func (v *validatorImpl) ValidateUser(user *model.User) []FieldError {
+	return toFieldErrors(v.v.Struct(user))
+}
+
+// ValidateUserUpdate validates the fields present in a PATCH payload with the same rules as ValidateUser.
+// Fields left at their zero value are not being updated and are therefore skipped.
+func (v *validatorImpl) ValidateUserUpdate(updateData *model.User) []FieldError {
+	var fields []string
+	if updateData.Username != "" {
+		fields = append(fields, "Username")
+	}
+	if updateData.Email != "" {
+		fields = append(fields, "Email")
+	}
+	if updateData.Password != "" {
+		fields = append(fields, "Password")
+	}
+	if updateData.Name != "" {
+		fields = append(fields, "Name")
+	}
+	if updateData.Lastname != "" {
+		fields = append(fields, "Lastname")
+	}
+	if updateData.Age != 0 {
+		fields = append(fields, "Age")
+	}
+	if len(fields) == 0 {
+		return nil // Nothing to validate.
+	}
+	return toFieldErrors(v.v.StructPartial(updateData, fields...))
 }
 
 // ValidateEmailFormat checks the format of the email.
 func (v *validatorImpl) ValidateEmailFormat(email string) bool {
-	re := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	return re.MatchString(email)
+	return emailRegex.MatchString(email)
+}
+
+// ValidatePassword checks a new password against the password policy. user provides the username and
+// email address the password must not contain. An error means the policy could not be evaluated.
+func (v *validatorImpl) ValidatePassword(plain string, user *model.User) ([]FieldError, error) {
+	violations, err := v.policy.Check(plain, user.Username, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	var fieldErrors []FieldError
+	for _, violation := range violations {
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   "password",
+			Rule:    violation.Code,
+			Param:   violation.Param,
+			Message: violation.Message,
+		})
+	}
+	return fieldErrors, nil
+}
+
+// toFieldErrors converts validator errors into the per-field list returned to clients.
+func toFieldErrors(err error) []FieldError {
+	if err == nil {
+		return nil
+	}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []FieldError{{Field: "", Rule: "invalid", Message: err.Error()}}
+	}
+
+	fieldErrors := make([]FieldError, len(validationErrors))
+	for i, e := range validationErrors {
+		fieldErrors[i] = FieldError{
+			Field:   e.Field(),
+			Rule:    e.Tag(),
+			Param:   e.Param(),
+			Message: message(e),
+		}
+	}
+	return fieldErrors
+}
+
+// message builds a human readable explanation of a failed rule.
+func message(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return e.Field() + " is required"
+	case "min":
+		if e.Kind() == reflect.String {
+			return e.Field() + " must be at least " + e.Param() + " characters long"
+		}
+		return e.Field() + " must be at least " + e.Param()
+	case "max":
+		if e.Kind() == reflect.String {
+			return e.Field() + " must be at most " + e.Param() + " characters long"
+		}
+		return e.Field() + " must be at most " + e.Param()
+	case "gte":
+		return e.Field() + " must be at least " + e.Param()
+	case "lte":
+		return e.Field() + " must be at most " + e.Param()
+	case "email_format":
+		return e.Field() + " must be a valid email address"
+	case "username_format":
+		return e.Field() + " may only contain letters, digits, dots, dashes and underscores"
+	}
+	return e.Field() + " is invalid"
 }
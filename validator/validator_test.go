@@ -0,0 +1,157 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/model"
+	"gitlab.com/rapsodoinc/tr/architecture/golang-web-app/utils/password"
+)
+
+// validUser returns a user that passes every rule, for the tests to break one field at a time.
+func validUser() model.User {
+	return model.User{Username: "john.doe", Email: "john@example.com", Password: "correct horse battery", Name: "John", Lastname: "Doe", Age: 30}
+}
+
+// rules returns the field and rule of every error, e.g. "username:min".
+func rules(fieldErrors []FieldError) []string {
+	var got []string
+	for _, e := range fieldErrors {
+		got = append(got, e.Field+":"+e.Rule)
+	}
+	return got
+}
+
+func TestValidateUser(t *testing.T) {
+	v := NewValidator(nil)
+
+	testCases := []struct {
+		name      string
+		modify    func(user *model.User)
+		wantRules []string
+	}{
+		{name: "Valid User", modify: func(user *model.User) {}},
+		{name: "Missing Fields", modify: func(user *model.User) { *user = model.User{} },
+			wantRules: []string{"username:required", "email:required", "password:required", "name:required", "lastname:required"}},
+		{name: "Short Username", modify: func(user *model.User) { user.Username = "jo" }, wantRules: []string{"username:min"}},
+		{name: "Long Username", modify: func(user *model.User) { user.Username = strings.Repeat("j", 33) }, wantRules: []string{"username:max"}},
+		{name: "Username With Spaces", modify: func(user *model.User) { user.Username = "john doe" }, wantRules: []string{"username:username_format"}},
+		{name: "Username With Symbols", modify: func(user *model.User) { user.Username = "john_doe-1.x" }},
+		{name: "Invalid Email", modify: func(user *model.User) { user.Email = "john@example" }, wantRules: []string{"email:email_format"}},
+		{name: "Long Email", modify: func(user *model.User) { user.Email = strings.Repeat("j", 250) + "@example.com" }, wantRules: []string{"email:max"}},
+		{name: "Long Name", modify: func(user *model.User) { user.Name = strings.Repeat("j", 65) }, wantRules: []string{"name:max"}},
+		{name: "Long Lastname", modify: func(user *model.User) { user.Lastname = strings.Repeat("d", 65) }, wantRules: []string{"lastname:max"}},
+		{name: "Negative Age", modify: func(user *model.User) { user.Age = -1 }, wantRules: []string{"age:gte"}},
+		{name: "Age Too High", modify: func(user *model.User) { user.Age = 151 }, wantRules: []string{"age:lte"}},
+		{name: "Age Zero", modify: func(user *model.User) { user.Age = 0 }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := validUser()
+			tc.modify(&user)
+			if got := rules(v.ValidateUser(&user)); !reflect.DeepEqual(got, tc.wantRules) {
+				t.Fatalf("ValidateUser() = %v, want %v", got, tc.wantRules)
+			}
+		})
+	}
+}
+
+func TestValidateUserUpdate(t *testing.T) {
+	v := NewValidator(nil)
+
+	testCases := []struct {
+		name      string
+		update    model.User
+		wantRules []string
+	}{
+		{name: "Empty Update", update: model.User{}},
+		{name: "Valid Name", update: model.User{Name: "Johnny"}},
+		{name: "Invalid Email Only", update: model.User{Email: "john"}, wantRules: []string{"email:email_format"}},
+		{name: "Invalid Username And Age", update: model.User{Username: "j", Age: 200}, wantRules: []string{"username:min", "age:lte"}},
+		{name: "Long Lastname", update: model.User{Lastname: strings.Repeat("d", 65)}, wantRules: []string{"lastname:max"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rules(v.ValidateUserUpdate(&tc.update)); !reflect.DeepEqual(got, tc.wantRules) {
+				t.Fatalf("ValidateUserUpdate() = %v, want %v", got, tc.wantRules)
+			}
+		})
+	}
+}
+
+func TestFieldErrorMessages(t *testing.T) {
+	v := NewValidator(nil)
+	user := model.User{Username: "jo!", Email: "john", Name: strings.Repeat("j", 65), Lastname: "Doe", Age: 151}
+
+	want := map[string]FieldError{
+		"username": {Field: "username", Rule: "username_format", Message: "username may only contain letters, digits, dots, dashes and underscores"},
+		"email":    {Field: "email", Rule: "email_format", Message: "email must be a valid email address"},
+		"password": {Field: "password", Rule: "required", Message: "password is required"},
+		"name":     {Field: "name", Rule: "max", Param: "64", Message: "name must be at most 64 characters long"},
+		"age":      {Field: "age", Rule: "lte", Param: "150", Message: "age must be at most 150"},
+	}
+	fieldErrors := v.ValidateUser(&user)
+	if len(fieldErrors) != len(want) {
+		t.Fatalf("ValidateUser() = %v, want %d errors", fieldErrors, len(want))
+	}
+	for _, got := range fieldErrors {
+		if got != want[got.Field] {
+			t.Fatalf("ValidateUser() error = %+v, want %+v", got, want[got.Field])
+		}
+	}
+}
+
+func TestValidateEmailFormat(t *testing.T) {
+	v := NewValidator(nil)
+
+	testCases := []struct {
+		email string
+		want  bool
+	}{
+		{email: "john@example.com", want: true},
+		{email: "john.doe+tag@mail.example.co", want: true},
+		{email: "", want: false},
+		{email: "john", want: false},
+		{email: "john@example", want: false},
+		{email: "john doe@example.com", want: false},
+		{email: "@example.com", want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.email, func(t *testing.T) {
+			if got := v.ValidateEmailFormat(tc.email); got != tc.want {
+				t.Fatalf("ValidateEmailFormat(%q) = %v, want %v", tc.email, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	v := NewValidator(nil)
+	user := &model.User{Username: "johndoe", Email: "john@example.com"}
+
+	testCases := []struct {
+		name      string
+		password  string
+		wantRules []string
+	}{
+		{name: "Valid Password", password: "correct horse battery"},
+		{name: "Too Short", password: "short", wantRules: []string{"password:" + password.ViolationTooShort}},
+		{name: "Contains Username", password: "i am johndoe!", wantRules: []string{"password:" + password.ViolationContainsUserInfo}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors, err := v.ValidatePassword(tc.password, user)
+			if err != nil {
+				t.Fatalf("ValidatePassword() error = %v", err)
+			}
+			if got := rules(fieldErrors); !reflect.DeepEqual(got, tc.wantRules) {
+				t.Fatalf("ValidatePassword() = %v, want %v", got, tc.wantRules)
+			}
+		})
+	}
+}